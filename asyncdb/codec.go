@@ -0,0 +1,81 @@
+package asyncdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Codec encodes the values of a table to bytes and decodes them back to values of its type
+type Codec interface {
+	// Name identifies the encoding, and is recorded with the tables using the codec
+	Name() string
+	// ValueType is the type of the values the codec encodes and decodes
+	ValueType() reflect.Type
+	Encode(value interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+type jsonCodec[V any] struct{}
+
+// NewJSONCodec encodes values of type V as JSON. PgTable stores them in a JSONB column
+func NewJSONCodec[V any]() Codec {
+	return jsonCodec[V]{}
+}
+
+func (c jsonCodec[V]) Name() string {
+	return "json"
+}
+
+func (c jsonCodec[V]) ValueType() reflect.Type {
+	return reflect.TypeFor[V]()
+}
+
+func (c jsonCodec[V]) Encode(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode value: %w", err)
+	}
+	return data, nil
+}
+
+func (c jsonCodec[V]) Decode(data []byte) (interface{}, error) {
+	var value V
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("failed to decode value: %w", err)
+	}
+	return value, nil
+}
+
+type gobCodec[V any] struct{}
+
+// NewGobCodec encodes values of type V with encoding/gob. PgTable stores them in a BYTEA column
+func NewGobCodec[V any]() Codec {
+	return gobCodec[V]{}
+}
+
+func (c gobCodec[V]) Name() string {
+	return "gob"
+}
+
+func (c gobCodec[V]) ValueType() reflect.Type {
+	return reflect.TypeFor[V]()
+}
+
+func (c gobCodec[V]) Encode(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, fmt.Errorf("failed to encode value: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (c gobCodec[V]) Decode(data []byte) (interface{}, error) {
+	var value V
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to decode value: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,27 @@
+package asyncdb
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type codecTestValue struct {
+	Name   string
+	Counts map[string]int
+	Nested *codecTestValue
+}
+
+func TestCodecs_Should_Round_Trip_Values(t *testing.T) {
+	value := codecTestValue{Name: "a", Counts: map[string]int{"x": 1}, Nested: &codecTestValue{Name: "b"}}
+	for _, codec := range []Codec{NewJSONCodec[codecTestValue](), NewGobCodec[codecTestValue]()} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			data, err := codec.Encode(value)
+			assert.Nil(t, err)
+			decoded, err := codec.Decode(data)
+			assert.Nil(t, err)
+			assert.Equal(t, value, decoded)
+			_, err = codec.Decode([]byte("corrupted"))
+			assert.NotNil(t, err)
+		})
+	}
+}
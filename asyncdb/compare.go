@@ -0,0 +1,67 @@
+package asyncdb
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// CompareKeys orders keys of the same type: numbers, strings and booleans by value, time.Time chronologically,
+// and structs and arrays field by field in declaration order, so composite keys are ordered lexicographically.
+// Values of other types, or of different types, are compared by their string representation
+func CompareKeys(a interface{}, b interface{}) int {
+	return compareValues(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func compareValues(a reflect.Value, b reflect.Value) int {
+	if !a.IsValid() || !b.IsValid() {
+		return cmp.Compare(boolToInt(a.IsValid()), boolToInt(b.IsValid()))
+	}
+	if a.Type() != b.Type() {
+		return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+	}
+	if a.Type() == timeType && a.CanInterface() {
+		return a.Interface().(time.Time).Compare(b.Interface().(time.Time))
+	}
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float())
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Bool:
+		return cmp.Compare(boolToInt(a.Bool()), boolToInt(b.Bool()))
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if c := compareValues(a.Field(i), b.Field(i)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if c := compareValues(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case reflect.Pointer, reflect.Interface:
+		return compareValues(a.Elem(), b.Elem())
+	default:
+		return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,36 @@
+package asyncdb
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCompareKeys(t *testing.T) {
+	type compositeKey struct {
+		WarehouseId int
+		DistrictId  int
+		Name        string
+	}
+	now := time.Now()
+	cases := []struct {
+		name string
+		a    interface{}
+		b    interface{}
+		want int
+	}{
+		{name: "Ints", a: 1, b: 2, want: -1},
+		{name: "Strings", a: "b", b: "a", want: 1},
+		{name: "Floats", a: 1.5, b: 1.5, want: 0},
+		{name: "Times", a: now, b: now.Add(time.Second), want: -1},
+		{name: "Struct ordered by first field", a: compositeKey{1, 9, "z"}, b: compositeKey{2, 1, "a"}, want: -1},
+		{name: "Struct ordered by later field", a: compositeKey{1, 2, "b"}, b: compositeKey{1, 2, "a"}, want: 1},
+		{name: "Equal structs", a: compositeKey{1, 2, "a"}, b: compositeKey{1, 2, "a"}, want: 0},
+		{name: "Nil is the smallest", a: nil, b: 1, want: -1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, CompareKeys(c.a, c.b))
+		})
+	}
+}
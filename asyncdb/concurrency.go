@@ -0,0 +1,173 @@
+package asyncdb
+
+import (
+	"context"
+	"sync"
+)
+
+// concurrencyControl isolates the transactions of AsyncDB from each other. Writes of a transaction are kept
+// in its TransactionLog until commit, so implementations decide what committed data the transaction sees,
+// and whether it is allowed to commit
+type concurrencyControl interface {
+	// begin is called when the transaction starts, and can assign its timestamp
+	begin(txn *TransactInfo)
+	// read returns the committed value of the key the transaction is allowed to see
+	read(ctx context.Context, txn *TransactInfo, tableId uint64, table Table, key interface{}) (interface{}, error)
+	// scan returns the committed rows between from and to the transaction is allowed to see
+	scan(ctx context.Context, txn *TransactInfo, tableId uint64, table OrderedTable, from interface{}, to interface{}, limit int) ([]KeyValue, error)
+	// write is called before the transaction logs a write of the key
+	write(ctx context.Context, txn *TransactInfo, tableId uint64, key interface{}) error
+	// commit checks that the transaction can commit, and applies its log with apply
+	commit(txn *TransactInfo, tables map[uint64][]LogEntry, apply func() error) error
+	// end is called after the transaction committed or aborted
+	end(txn *TransactInfo) error
+}
+
+// lockingControl is strict two-phase locking: keys are locked before they are read or written,
+// and the locks are held until the transaction ends. Weaker isolation levels release read locks earlier.
+// Read-only transactions read snapshots without locks
+type lockingControl struct {
+	lManager  LockManager
+	snapshots *snapshotReads
+}
+
+func newLockingControl(lManager LockManager, data *ThreadSafeMap[uint64, Table]) *lockingControl {
+	return &lockingControl{lManager: lManager, snapshots: newSnapshotReads(data)}
+}
+
+func (c *lockingControl) begin(txn *TransactInfo) {
+	if txn.opts.ReadOnly {
+		c.snapshots.begin(txn)
+	}
+}
+
+func (c *lockingControl) read(ctx context.Context, txn *TransactInfo, tableId uint64, table Table, key interface{}) (interface{}, error) {
+	if txn.opts.ReadOnly {
+		return c.snapshots.read(ctx, txn, tableId, table, key)
+	}
+	// Shared lock, a later write of the key in the same transaction upgrades it
+	if err := lockError(c.lManager.LockContext(ctx, ReadLock, txn.tId, txn.ts, TableId(tableId), key)); err != nil {
+		return nil, err
+	}
+	value, err := getContext(ctx, table, key)
+	if txn.opts.Isolation == ReadCommitted {
+		_ = c.lManager.ReleaseReadLock(txn.tId, TableId(tableId), key)
+	}
+	return value, err
+}
+
+func (c *lockingControl) scan(ctx context.Context, txn *TransactInfo, tableId uint64, table OrderedTable, from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	// The range lock covers the keys that do not exist yet, so no rows can appear in the range
+	// until the transaction ends
+	if txn.opts.ReadOnly {
+		return c.snapshots.scan(ctx, txn, tableId, table, from, to, limit)
+	}
+	keyRange := KeyRange{From: from, To: to, Compare: table.CompareKeys}
+	if err := lockError(c.lManager.LockRangeContext(ctx, ReadLock, txn.tId, txn.ts, TableId(tableId), keyRange)); err != nil {
+		return nil, err
+	}
+	rows, err := scanContext(ctx, table, from, to, limit)
+	if txn.opts.Isolation == Serializable {
+		return rows, err
+	}
+	// Without the range lock, only the rows that were read are kept from changing
+	if err == nil && txn.opts.Isolation == RepeatableRead {
+		for _, row := range rows {
+			if err = lockError(c.lManager.LockContext(ctx, ReadLock, txn.tId, txn.ts, TableId(tableId), row.Key)); err != nil {
+				break
+			}
+		}
+	}
+	_ = c.lManager.ReleaseReadRange(txn.tId, TableId(tableId), keyRange)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (c *lockingControl) write(ctx context.Context, txn *TransactInfo, tableId uint64, key interface{}) error {
+	return lockError(c.lManager.LockContext(ctx, WriteLock, txn.tId, txn.ts, TableId(tableId), key))
+}
+
+func (c *lockingControl) commit(txn *TransactInfo, tables map[uint64][]LogEntry, apply func() error) error {
+	if txn.opts.ReadOnly {
+		return nil
+	}
+	return c.snapshots.commit(txn, tables, apply)
+}
+
+func (c *lockingControl) end(txn *TransactInfo) error {
+	if txn.opts.ReadOnly {
+		return c.snapshots.end(txn)
+	}
+	return c.lManager.ReleaseLocks(txn.tId)
+}
+
+// transactionClock orders the commits of the optimistic concurrency controls. The timestamp of a transaction
+// is the clock when it started, and the clock moves forward when a commit is published
+type transactionClock struct {
+	now int64
+	// active keeps the timestamps of running transactions
+	active map[TransactId]int64
+	m      *sync.Mutex
+}
+
+func newTransactionClock() *transactionClock {
+	return &transactionClock{
+		active: make(map[TransactId]int64),
+		m:      &sync.Mutex{},
+	}
+}
+
+func (c *transactionClock) begin(txn *TransactInfo) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	txn.ts = c.now
+	c.active[txn.tId] = txn.ts
+}
+
+// next returns the timestamp of the next commit. Commits have to be serialized by the caller
+func (c *transactionClock) next() int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now + 1
+}
+
+// publish moves the clock to the commit of the transaction tid, and returns the oldest timestamp
+// of the other running transactions
+func (c *transactionClock) publish(ts int64, tid TransactId) int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.now = ts
+	oldest := ts
+	for other, otherTs := range c.active {
+		if other != tid {
+			oldest = min(oldest, otherTs)
+		}
+	}
+	return oldest
+}
+
+// oldest returns the timestamp of the oldest running transaction, or the clock if there is none
+func (c *transactionClock) oldest() int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	oldest := c.now
+	for _, ts := range c.active {
+		oldest = min(oldest, ts)
+	}
+	return oldest
+}
+
+// running checks if any transaction is running
+func (c *transactionClock) running() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return len(c.active) > 0
+}
+
+func (c *transactionClock) end(tid TransactId) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	delete(c.active, tid)
+}
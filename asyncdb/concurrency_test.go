@@ -0,0 +1,55 @@
+package asyncdb
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestConcurrencyControl_Data_Consistency(t *testing.T) {
+	// GIVEN routineCount goroutines that increment a counter iterCount times, retrying aborted transactions
+	// WHEN each routineCount completes
+	// THEN no increment should be lost
+	cases := []struct {
+		name   string
+		option func(*AsyncDB)
+	}{
+		{name: "OCC", option: WithOCC()},
+		{name: "MVCC", option: WithMVCC()},
+		{name: "SSI", option: WithSSI()},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, _ := newSeededTestDB(t, c.option)
+			routineCount := 8
+			iterCount := 200
+			wg := sync.WaitGroup{}
+			wg.Add(routineCount)
+			for range routineCount {
+				go func() {
+					defer wg.Done()
+					ctx, _ := db.Connect()
+					for range iterCount {
+						for {
+							_ = db.BeginTransaction(ctx)
+							res := <-db.Get(ctx, "test", 1)
+							<-db.Put(ctx, "test", 1, res.Data.(int)+1)
+							err := db.CommitTransaction(ctx)
+							if err == nil {
+								break
+							}
+							if !errors.Is(err, ErrXactAborted) {
+								t.Error(err)
+								return
+							}
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			ctx, _ := db.Connect()
+			assert.Equal(t, 10+routineCount*iterCount, (<-db.Get(ctx, "test", 1)).Data)
+		})
+	}
+}
@@ -1,11 +1,16 @@
 package asyncdb
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/Volume999/AsyncDB/internal/databases"
 	"github.com/google/uuid"
+	"log"
+	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,6 +26,68 @@ type TransactInfo struct {
 	ts   int64
 	mode int
 	acts *sync.WaitGroup
+	opts TxOptions
+	// timer aborts the transaction when its timeout expires
+	timer *time.Timer
+	// abortErr is the reason the transaction was aborted from outside of its connection. It is returned
+	// by the operations and the commit, until the transaction is rolled back
+	abortErr error
+	// ops is cancelled when the transaction ends, to stop its running operations
+	ops    context.Context
+	cancel context.CancelFunc
+}
+
+func newTransactInfo(tId TransactId, mode int, ts int64, opts TxOptions) *TransactInfo {
+	ops, cancel := context.WithCancel(context.Background())
+	return &TransactInfo{tId: tId, mode: mode, ts: ts, acts: &sync.WaitGroup{}, opts: opts, ops: ops, cancel: cancel}
+}
+
+// IsolationLevel is the isolation of a transaction under the default locking concurrency control,
+// the other concurrency controls provide their own isolation regardless of the level
+type IsolationLevel int
+
+const (
+	// Serializable holds the read locks of keys and scanned ranges until the transaction ends
+	Serializable IsolationLevel = iota
+	// RepeatableRead holds the read locks of keys until the transaction ends, but not of scanned ranges,
+	// so rows inserted into a scanned range by other transactions can appear in the next scan
+	RepeatableRead
+	// ReadCommitted releases read locks right after reading, so reads only wait for writers to commit
+	ReadCommitted
+)
+
+// TxOptions configure a transaction started with BeginTransaction
+type TxOptions struct {
+	Isolation IsolationLevel
+	// ReadOnly transactions fail Put and Delete with ErrReadOnlyTxn and have no log. Under the default
+	// locking concurrency control, they read a snapshot taken at the start without locks, so they are never
+	// aborted by writers and never abort them
+	ReadOnly bool
+	// Timeout aborts the transaction if it does not end in time, zero means no timeout
+	Timeout time.Duration
+}
+
+// stop stops the timer and cancels the running operations of the ending transaction
+func (t *TransactInfo) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.cancel()
+}
+
+// operationContext returns the context of an operation, which is done when either the context given to
+// the operation is done or the transaction ends. Operations without a context of their own use the context
+// of the transaction as is
+func (t *TransactInfo) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == context.Background() {
+		return t.ops, func() {}
+	}
+	opCtx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(t.ops, cancel)
+	return opCtx, func() {
+		stop()
+		cancel()
+	}
 }
 
 // Debugging functions
@@ -43,6 +110,14 @@ var ErrTableNotFound = errors.New("table not found")
 var ErrXactAborted = errors.New("transaction aborted")
 var ErrXactInTerminalState = errors.New("transaction in terminal state")
 var ErrXactInProgress = errors.New("transaction in progress")
+var ErrCommitFailed = errors.New("commit failed")
+var ErrCommitNotApplied = errors.New("transaction committed, but not applied by all tables")
+var ErrInvalidLogEntry = errors.New("invalid log entry")
+var ErrReadOnlyTxn = errors.New("transaction is read-only")
+var ErrXactTimeout = errors.New("transaction timed out")
+
+// scanBufferSize is the number of rows a scan can send before the reader starts receiving them
+const scanBufferSize = 64
 
 type Hasher interface {
 	HashStringUint64(string) uint64
@@ -52,8 +127,24 @@ type AsyncDB struct {
 	data            *ThreadSafeMap[uint64, Table]
 	tManager        TransactionManager
 	lManager        LockManager
+	cc              concurrencyControl
 	hasher          Hasher
+	wal             WriteAheadLog
 	withImplicitTxn bool
+	// txns finds the connection of a transaction the lock manager wants to abort
+	txns *ThreadSafeMap[TransactId, *ConnectionContext]
+	// commitMu lets checkpoints wait for the commits being applied
+	commitMu *sync.RWMutex
+	// checkpointEvery is the number of logged commits between automatic checkpoints, zero disables them
+	checkpointEvery int64
+	logged          atomic.Int64
+	// checkpointMu runs one checkpoint at a time, and checkpointing is set while one runs in the background
+	checkpointMu  *sync.Mutex
+	checkpointing atomic.Bool
+	checkpoints   *sync.WaitGroup
+	// tail holds the records logged while a checkpoint scans the tables, it is nil at other times
+	tailMu *sync.Mutex
+	tail   []WALRecord
 }
 
 func NewAsyncDB(tManager TransactionManager, lManager LockManager, hasher Hasher, options ...func(*AsyncDB)) *AsyncDB {
@@ -62,12 +153,21 @@ func NewAsyncDB(tManager TransactionManager, lManager LockManager, hasher Hasher
 		lManager:        lManager,
 		data:            NewThreadSafeMap[uint64, Table](),
 		hasher:          hasher,
+		commitMu:        &sync.RWMutex{},
+		checkpointMu:    &sync.Mutex{},
+		checkpoints:     &sync.WaitGroup{},
+		tailMu:          &sync.Mutex{},
 		withImplicitTxn: true,
+		txns:            NewThreadSafeMap[TransactId, *ConnectionContext](),
 	}
+	db.cc = newLockingControl(lManager, db.data)
 
 	for _, option := range options {
 		option(db)
 	}
+	if wlm, ok := lManager.(WoundingLockManager); ok {
+		wlm.SetWoundHandler(db.woundTransaction)
+	}
 	return db
 }
 
@@ -77,6 +177,20 @@ func WithExplicitTxn() func(*AsyncDB) {
 	}
 }
 
+// WithWAL makes commits durable: the commit record is written to the log before the changes are applied to the tables
+func WithWAL(wal WriteAheadLog) func(*AsyncDB) {
+	return func(db *AsyncDB) {
+		db.wal = wal
+	}
+}
+
+// WithCheckpointEvery checkpoints the write-ahead log in the background after every given number of logged commits
+func WithCheckpointEvery(commits int) func(*AsyncDB) {
+	return func(db *AsyncDB) {
+		db.checkpointEvery = int64(commits)
+	}
+}
+
 func (p *AsyncDB) Connect() (*ConnectionContext, error) {
 	guid := uuid.New()
 	return &ConnectionContext{ID: guid, Txn: nil, TxnMu: &sync.RWMutex{}}, nil
@@ -89,6 +203,9 @@ func (p *AsyncDB) Disconnect(ctx *ConnectionContext) error {
 	if ctx.Txn != nil && ctx.Txn.mode != Ready {
 		return ErrXactInProgress
 	}
+	if ctx.Txn != nil {
+		p.txns.Delete(ctx.Txn.tId)
+	}
 	ctx.Txn = nil
 	return nil
 }
@@ -100,6 +217,10 @@ func (p *AsyncDB) CreateTable(_ *ConnectionContext, table Table) error {
 	if _, ok := p.data.GetUnsafe(hash); ok {
 		return fmt.Errorf("%w - %s", ErrTableExists, table.Name())
 	}
+	// Checkpoints keep the rows of tables that do not persist their writes by scanning them
+	if _, ok := table.(OrderedTable); !ok && p.wal != nil && needsCheckpoint(table) {
+		return fmt.Errorf("%w - %s is not durable, so it needs scans for checkpoints of the write-ahead log", ErrScanNotSupported, table.Name())
+	}
 	p.data.PutUnsafe(hash, table)
 	return nil
 }
@@ -125,16 +246,41 @@ func (p *AsyncDB) DropTable(_ *ConnectionContext, tableName string) error {
 	return nil
 }
 
-func (p *AsyncDB) BeginTransaction(ctx *ConnectionContext) error {
-	tId, err := p.tManager.StartTransaction(ctx.ID)
+// BeginTransaction starts a transaction in the connection. Without options, the transaction is serializable
+func (p *AsyncDB) BeginTransaction(ctx *ConnectionContext, opts ...TxOptions) error {
+	var txOpts TxOptions
+	if len(opts) > 0 {
+		txOpts = opts[0]
+	}
+	return p.beginTransaction(ctx, txOpts, time.Now().UnixNano())
+}
+
+// beginTransaction starts a transaction with the timestamp, which the optimistic concurrency controls
+// replace by their snapshot
+func (p *AsyncDB) beginTransaction(ctx *ConnectionContext, txOpts TxOptions, ts int64) error {
+	tId, err := p.startTransaction(ctx, txOpts)
 
 	if err != nil {
 		return err
 	}
-	ctx.Txn = &TransactInfo{tId: tId, mode: Active, ts: time.Now().UnixNano(), acts: &sync.WaitGroup{}}
+	ctx.Txn = newTransactInfo(tId, Active, ts, txOpts)
+	p.cc.begin(ctx.Txn)
+	p.txns.Put(tId, ctx)
+	if ctx.Txn.opts.Timeout > 0 {
+		ctx.Txn.timer = time.AfterFunc(ctx.Txn.opts.Timeout, func() {
+			p.abortAsync(tId, fmt.Errorf("%w: %w", ErrXactAborted, ErrXactTimeout))
+		})
+	}
 	return nil
 }
 
+func (p *AsyncDB) startTransaction(ctx *ConnectionContext, opts TxOptions) (TransactId, error) {
+	if opts.ReadOnly {
+		return p.tManager.StartReadOnlyTransaction(ctx.ID)
+	}
+	return p.tManager.StartTransaction(ctx.ID)
+}
+
 func (p *AsyncDB) CommitTransaction(ctx *ConnectionContext) error {
 	ctx.TxnMu.Lock()
 	defer ctx.TxnMu.Unlock()
@@ -144,18 +290,38 @@ func (p *AsyncDB) CommitTransaction(ctx *ConnectionContext) error {
 	ctx.Txn.mode = Committing
 	// Todo: Maybe wait can be outside of the locking scheme, because Status is locked by WLock
 	ctx.Txn.acts.Wait()
-	tLog, err := p.tManager.GetLog(ctx.ID)
-	if err != nil {
-		return err
+	tables := make(map[uint64][]LogEntry)
+	if !ctx.Txn.opts.ReadOnly {
+		tLog, err := p.tManager.GetLog(ctx.ID)
+		if err != nil {
+			return err
+		}
+		tables = tLog.entries()
+	}
+	var err error
+	if ctx.Txn.abortErr != nil {
+		err = ctx.Txn.abortErr
+	} else {
+		err = p.cc.commit(ctx.Txn, tables, func() error {
+			if err := p.validateLogs(tables); err != nil {
+				return err
+			}
+			return p.applyLogs(ctx.Txn.tId, tables)
+		})
+	}
+	if err != nil && !errors.Is(err, ErrCommitNotApplied) {
+		err = fmt.Errorf("%w: %w", ErrCommitFailed, err)
 	}
-	// Todo: Error handling?
-	// TODO: Log validation before applying
-	err = errors.Join(err, p.applyLogs(tLog))
 
+	ctx.Txn.stop()
 	// Currently, we do not expect errors from lock release
-	_ = p.lManager.ReleaseLocks(ctx.Txn.tId)
+	_ = p.cc.end(ctx.Txn)
 	err = errors.Join(err, p.tManager.EndTransaction(ctx.ID))
+	p.txns.Delete(ctx.Txn.tId)
 	ctx.Txn = nil
+	if err == nil && p.checkpointDue() {
+		p.checkpointInBackground()
+	}
 	return err
 }
 
@@ -165,21 +331,56 @@ func (p *AsyncDB) abortTransaction(ctx *ConnectionContext) error {
 	if ctx.Txn == nil {
 		return ErrConnNotInXact
 	}
+	return p.abortTransactionUnsafe(ctx)
+}
+
+// woundTransaction aborts the transaction on request of the lock manager
+func (p *AsyncDB) woundTransaction(tid TransactId) {
+	p.abortAsync(tid, ErrXactAborted)
+}
+
+// abortAsync aborts the transaction from outside of its connection, unless it has already ended, and the reason
+// is reported to the connection. It does not wait for the abort, as the transaction may be committing
+// and holding the connection
+func (p *AsyncDB) abortAsync(tid TransactId, reason error) {
+	ctx, ok := p.txns.Get(tid)
+	if !ok {
+		return
+	}
+	go func() {
+		ctx.TxnMu.Lock()
+		defer ctx.TxnMu.Unlock()
+		if ctx.Txn == nil || ctx.Txn.tId != tid {
+			return
+		}
+		// Todo: Logging
+		_ = p.abortTransactionUnsafe(ctx)
+		ctx.Txn.abortErr = reason
+	}()
+}
+
+// abortTransactionUnsafe requires ctx.TxnMu
+func (p *AsyncDB) abortTransactionUnsafe(ctx *ConnectionContext) error {
 	ctx.Txn.mode = Aborting
+	ctx.Txn.stop()
 
-	err := p.lManager.ReleaseLocks(ctx.Txn.tId)
+	err := p.cc.end(ctx.Txn)
 
 	//todo: maybe need a wait here?
 	ctx.Txn.acts.Wait()
 
 	ts := ctx.Txn.ts
+	opts := ctx.Txn.opts
+	p.txns.Delete(ctx.Txn.tId)
 
 	err = errors.Join(err, p.tManager.EndTransaction(ctx.ID))
 	//err = errors.Join(err, p.tManager.DeleteLog(ctx.ID))
 	//ctx.Txn.mode = Active
-	tId, xactErr := p.tManager.StartTransaction(ctx.ID)
+	tId, xactErr := p.startTransaction(ctx, opts)
 	err = errors.Join(err, xactErr)
-	ctx.Txn = &TransactInfo{tId: tId, mode: Ready, ts: ts, acts: &sync.WaitGroup{}}
+	ctx.Txn = newTransactInfo(tId, Ready, ts, opts)
+	p.cc.begin(ctx.Txn)
+	p.txns.Put(tId, ctx)
 	return err
 }
 
@@ -190,116 +391,195 @@ func (p *AsyncDB) RollbackTransaction(ctx *ConnectionContext) error {
 		return ErrConnNotInXact
 	}
 	ctx.Txn.mode = Aborting
+	ctx.Txn.stop()
 
-	// Todo: Figure out how to cancel queries, instead of waiting for them to finish
-	err := p.lManager.ReleaseLocks(ctx.Txn.tId)
+	err := p.cc.end(ctx.Txn)
 
 	// Todo: maybe need a wait here?
 	ctx.Txn.acts.Wait()
 
 	err = errors.Join(err, p.tManager.EndTransaction(ctx.ID))
+	p.txns.Delete(ctx.Txn.tId)
 	ctx.Txn = nil
 	return err
 }
 
-func (p *AsyncDB) Put(ctx *ConnectionContext, tableName string, key interface{}, value interface{}) <-chan databases.RequestResult {
+// Savepoint marks the current state of the transaction, so that later operations can be undone with
+// RollbackToSavepoint. Operations submitted before are waited for
+func (p *AsyncDB) Savepoint(ctx *ConnectionContext, name string) error {
+	return p.withLog(ctx, func(tLog *TransactionLog) error {
+		tLog.savepoint(name)
+		return nil
+	})
+}
+
+// RollbackToSavepoint undoes the writes made after the savepoint. The locks taken since are kept until
+// the transaction ends, and the savepoint can be rolled back to again
+func (p *AsyncDB) RollbackToSavepoint(ctx *ConnectionContext, name string) error {
+	return p.withLog(ctx, func(tLog *TransactionLog) error {
+		return tLog.rollbackTo(name)
+	})
+}
+
+// ReleaseSavepoint forgets the savepoint and the savepoints made after it, keeping the writes
+func (p *AsyncDB) ReleaseSavepoint(ctx *ConnectionContext, name string) error {
+	return p.withLog(ctx, func(tLog *TransactionLog) error {
+		return tLog.release(name)
+	})
+}
+
+// withLog runs f with the log of the explicit transaction of the connection, once its operations are finished
+func (p *AsyncDB) withLog(ctx *ConnectionContext, f func(tLog *TransactionLog) error) error {
+	ctx.TxnMu.Lock()
+	defer ctx.TxnMu.Unlock()
+	if ctx.Txn == nil {
+		return ErrConnNotInXact
+	}
+	if ctx.Txn.abortErr != nil {
+		return ctx.Txn.abortErr
+	}
+	ctx.Txn.acts.Wait()
+	tLog, err := p.tManager.GetLog(ctx.ID)
+	if err != nil {
+		return err
+	}
+	return f(tLog)
+}
+
+// xactAbortError is returned by operations that have to abort the transaction, for example on a lock conflict
+type xactAbortError struct {
+	err error
+}
+
+func (e *xactAbortError) Error() string {
+	return e.err.Error()
+}
+
+func (e *xactAbortError) Unwrap() error {
+	return e.err
+}
+
+// beginOperation registers an operation in the transaction of the connection. If the connection is not in
+// a transaction and implicit transactions are enabled, a transaction is started for this operation only
+func (p *AsyncDB) beginOperation(ctx *ConnectionContext) (txn *TransactInfo, implTransaction bool, err error) {
+	if !ctx.TxnMu.TryRLock() {
+		return nil, false, ErrXactInTerminalState
+	}
+	defer ctx.TxnMu.RUnlock()
+	if ctx.Txn == nil {
+		if !p.withImplicitTxn {
+			return nil, false, ErrConnNotInXact
+		}
+		txnId, err := p.tManager.StartTransaction(ctx.ID)
+		if err != nil {
+			return nil, false, errors.Join(fmt.Errorf("error with implicit transaction"), err)
+		}
+		implTransaction = true
+		ctx.Txn = newTransactInfo(txnId, Active, time.Now().UnixNano(), TxOptions{})
+		p.cc.begin(ctx.Txn)
+		p.txns.Put(txnId, ctx)
+	}
+	// Possibly Redundant
+	if ctx.Txn.mode == Committing || ctx.Txn.mode == Aborting {
+		return nil, false, ErrXactInTerminalState
+	}
+	if ctx.Txn.abortErr != nil {
+		return nil, false, ctx.Txn.abortErr
+	}
+	ctx.Txn.acts.Add(1)
+	return ctx.Txn, implTransaction, nil
+}
+
+// endOperation deregisters the operation from the transaction. Implicit transactions are committed if the
+// operation succeeded and rolled back otherwise, explicit transactions are aborted if the operation requires it
+func (p *AsyncDB) endOperation(ctx *ConnectionContext, txn *TransactInfo, implTransaction bool, err error) error {
+	txn.acts.Done()
+	var abortErr *xactAbortError
+	if errors.As(err, &abortErr) {
+		err = abortErr.err
+		if !implTransaction {
+			// Todo: Same as above, logging
+			_ = p.abortTransaction(ctx)
+			// The restarted transaction keeps its timestamp, so retrying before the transaction that won
+			// the conflict runs only loses again, typically on a key read by both and then written
+			runtime.Gosched()
+			return err
+		}
+	}
+	if !implTransaction {
+		return err
+	}
+	if err != nil {
+		_ = p.RollbackTransaction(ctx)
+		return err
+	}
+	return p.CommitTransaction(ctx)
+}
+
+// runOperation executes the operation in the transaction of the connection and sends its result to the channel.
+// The operation is given a context that is done when goCtx is done or the transaction ends
+func (p *AsyncDB) runOperation(goCtx context.Context, ctx *ConnectionContext, op func(goCtx context.Context, txn *TransactInfo) (interface{}, error)) <-chan databases.RequestResult {
 	resultChan := make(chan databases.RequestResult, 1)
 	go func() {
-		// If the connection is not in a transaction and implicit transactions are allowed - start a transaction
-		implTransaction := false
-		transactionAborted := false
-		if !ctx.TxnMu.TryRLock() {
+		txn, implTransaction, err := p.beginOperation(ctx)
+		if err != nil {
 			resultChan <- databases.RequestResult{
 				Data: nil,
-				Err:  ErrXactInTerminalState,
+				Err:  err,
 			}
 			return
 		}
-		if ctx.Txn == nil {
-			if !p.withImplicitTxn {
-				resultChan <- databases.RequestResult{
-					Data: nil,
-					Err:  ErrConnNotInXact,
-				}
-				return
-			}
-			txnId, err := p.tManager.StartTransaction(ctx.ID)
-			if err != nil {
-				resultChan <- databases.RequestResult{
-					Data: nil,
-					Err:  errors.Join(fmt.Errorf("error with implicit transaction"), err),
-				}
-				return
-			}
-			implTransaction = true
-			ctx.Txn = &TransactInfo{
-				tId:  txnId,
-				mode: Active,
-				ts:   time.Now().UnixNano(),
-				acts: &sync.WaitGroup{},
-			}
-		}
-		if ctx.Txn.mode == Committing || ctx.Txn.mode == Aborting {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  ErrXactInTerminalState,
-			}
-			return
+		opCtx, cancel := txn.operationContext(goCtx)
+		data, err := op(opCtx, txn)
+		cancel()
+		err = p.endOperation(ctx, txn, implTransaction, err)
+		resultChan <- databases.RequestResult{
+			Data: data,
+			Err:  err,
 		}
-		ctx.Txn.acts.Add(1)
-		defer func() {
-			if !implTransaction && !transactionAborted {
-				ctx.Txn.acts.Done()
-			}
-		}()
-		ctx.TxnMu.RUnlock()
+	}()
+	return resultChan
+}
+
+// lockError converts an error of the lock manager to the error of the operation
+func lockError(err error) error {
+	// TODO: Change this logic
+	// Locks are released only when the transaction is aborted
+	// This is temporary, in the future we need a better way of handling this
+	if errors.Is(err, ErrLocksReleased) {
+		return ErrXactInTerminalState
+	}
+	// The operation gave up waiting, but the transaction can go on
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if err != nil {
+		return &xactAbortError{err: err}
+	}
+	return nil
+}
+
+func (p *AsyncDB) Put(ctx *ConnectionContext, tableName string, key interface{}, value interface{}) <-chan databases.RequestResult {
+	return p.PutContext(context.Background(), ctx, tableName, key, value)
+}
+
+// PutContext is Put that gives up waiting for locks when goCtx is done, and returns its error
+func (p *AsyncDB) PutContext(goCtx context.Context, ctx *ConnectionContext, tableName string, key interface{}, value interface{}) <-chan databases.RequestResult {
+	return p.runOperation(goCtx, ctx, func(goCtx context.Context, txn *TransactInfo) (interface{}, error) {
 		hash := p.hasher.HashStringUint64(tableName)
 		table, ok := p.data.Get(hash)
 		if !ok {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  fmt.Errorf("%w - %s", ErrTableNotFound, tableName),
-			}
-			return
+			return nil, fmt.Errorf("%w - %s", ErrTableNotFound, tableName)
 		}
-		err := table.ValidateTypes(key, value)
-		if err != nil {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  err,
-			}
-			return
+		if err := table.ValidateTypes(key, value); err != nil {
+			return nil, err
 		}
-
 		tLog, err := p.tManager.GetLog(ctx.ID)
 		if err != nil {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  err,
-			}
-			return
+			return nil, err
 		}
-		err = p.lManager.Lock(WriteLock, ctx.Txn.tId, ctx.Txn.ts, TableId(hash), key)
-		// TODO: Change this logic
-		// Locks are released only when the transaction is aborted
-		// This is temporary, in the future we need a better way of handling this
-		if errors.Is(err, ErrLocksReleased) {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  ErrXactInTerminalState,
-			}
-			return
-		}
-		if err != nil {
-			// Todo: Same as above, logging
-			transactionAborted = true
-			ctx.Txn.acts.Done()
-			_ = p.abortTransaction(ctx)
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  err,
-			}
-			return
+		if err = p.cc.write(goCtx, txn, hash, key); err != nil {
+			return nil, err
 		}
 		// TODO: Want to handle some errors?
 		tLog.addAction(Action{
@@ -308,275 +588,690 @@ func (p *AsyncDB) Put(ctx *ConnectionContext, tableName string, key interface{},
 			Key:     key,
 			Value:   value,
 		})
-		if implTransaction {
-			ctx.Txn.acts.Done()
-			_ = p.CommitTransaction(ctx)
-		}
-		resultChan <- databases.RequestResult{
-			Data: nil,
-			Err:  err,
-		}
-	}()
-	return resultChan
+		return nil, nil
+	})
 }
 
 func (p *AsyncDB) Get(ctx *ConnectionContext, tableName string, key interface{}) <-chan databases.RequestResult {
-	resultChan := make(chan databases.RequestResult, 1)
-	go func() {
-		implTransaction := false
-		transactionAborted := false
-		if !ctx.TxnMu.TryRLock() {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  ErrXactInTerminalState,
-			}
-			return
+	return p.GetContext(context.Background(), ctx, tableName, key)
+}
+
+// GetContext is Get that stops waiting for locks, and the reads of tables implementing ContextTable,
+// when goCtx is done, and returns its error
+func (p *AsyncDB) GetContext(goCtx context.Context, ctx *ConnectionContext, tableName string, key interface{}) <-chan databases.RequestResult {
+	return p.runOperation(goCtx, ctx, func(goCtx context.Context, txn *TransactInfo) (interface{}, error) {
+		hash := p.hasher.HashStringUint64(tableName)
+		table, ok := p.data.Get(hash)
+		if !ok {
+			return nil, fmt.Errorf("%w - %s", ErrTableNotFound, tableName)
 		}
-		if ctx.Txn == nil {
-			if !p.withImplicitTxn {
-				resultChan <- databases.RequestResult{
-					Data: nil,
-					Err:  ErrConnNotInXact,
-				}
-				return
-			}
-			txnId, err := p.tManager.StartTransaction(ctx.ID)
-			if err != nil {
-				resultChan <- databases.RequestResult{
-					Data: nil,
-					Err:  errors.Join(fmt.Errorf("error with implicit transaction"), err),
-				}
-				return
-			}
-			implTransaction = true
-			ctx.Txn = &TransactInfo{
-				tId:  txnId,
-				mode: Active,
-				ts:   time.Now().UnixNano(),
-				acts: &sync.WaitGroup{},
-			}
+		if txn.opts.ReadOnly {
+			return p.cc.read(goCtx, txn, hash, table, key)
 		}
-		// Possibly Redundant
-		if ctx.Txn.mode == Committing || ctx.Txn.mode == Aborting {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  ErrXactInTerminalState,
-			}
-			return
+		log, err := p.tManager.GetLog(ctx.ID)
+		if err != nil {
+			return nil, err
 		}
-		ctx.Txn.acts.Add(1)
-		defer func() {
-			if !implTransaction && !transactionAborted {
-				ctx.Txn.acts.Done()
-			}
-		}()
-		ctx.TxnMu.RUnlock()
+		if res, found := log.findLastValue(hash, key); found {
+			return res, nil
+		}
+		return p.cc.read(goCtx, txn, hash, table, key)
+	})
+}
 
-		hash := p.hasher.HashStringUint64(tableName)
+func (p *AsyncDB) Delete(ctx *ConnectionContext, tableName string, key interface{}) <-chan databases.RequestResult {
+	return p.DeleteContext(context.Background(), ctx, tableName, key)
+}
 
-		// Write lock even for Read operations because they are easier to reason about
-		err := p.lManager.Lock(WriteLock, ctx.Txn.tId, ctx.Txn.ts, TableId(hash), key)
-		// TODO: Change this logic
-		// Locks are released only when the transaction is aborted
-		// This is temporary, in the future we need a better way of handling this
-		if errors.Is(err, ErrLocksReleased) {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  ErrXactInTerminalState,
-			}
-			return
+// DeleteContext is Delete that stops waiting for locks and reads when goCtx is done, like GetContext
+func (p *AsyncDB) DeleteContext(goCtx context.Context, ctx *ConnectionContext, tableName string, key interface{}) <-chan databases.RequestResult {
+	return p.runOperation(goCtx, ctx, func(goCtx context.Context, txn *TransactInfo) (interface{}, error) {
+		hash := p.hasher.HashStringUint64(tableName)
+		table, ok := p.data.Get(hash)
+		if !ok {
+			return nil, fmt.Errorf("%w - %s", ErrTableNotFound, tableName)
 		}
+		tLog, err := p.tManager.GetLog(ctx.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.cc.write(goCtx, txn, hash, key); err != nil {
+			return nil, err
+		}
+		if _, err = p.cc.read(goCtx, txn, hash, table, key); err != nil {
+			return nil, err
+		}
+		tLog.addAction(Action{
+			Op:      LDelete,
+			tableId: hash,
+			Key:     key,
+			Value:   nil,
+		})
+		return nil, nil
+	})
+}
+
+// Scan sends the rows of the table with keys between from and to, both inclusive, in key order.
+// Nil bounds leave that side of the range open, and a limit less than or equal to zero means no limit.
+// Rows written by the transaction itself are merged into the result. The channel is closed after the last row,
+// and if the scan fails, the only value sent is the error. The table has to implement OrderedTable.
+// All rows are read from the table and merged before the first one is sent, so the whole result is held
+// in memory; use the limit to page through large tables
+func (p *AsyncDB) Scan(ctx *ConnectionContext, tableName string, from interface{}, to interface{}, limit int) <-chan databases.RequestResult {
+	return p.ScanContext(context.Background(), ctx, tableName, from, to, limit)
+}
+
+// ScanContext is Scan that stops waiting for locks, and the scans of tables implementing ContextOrderedTable,
+// when goCtx is done, and returns its error
+func (p *AsyncDB) ScanContext(goCtx context.Context, ctx *ConnectionContext, tableName string, from interface{}, to interface{}, limit int) <-chan databases.RequestResult {
+	resultChan := make(chan databases.RequestResult, scanBufferSize)
+	go func() {
+		defer close(resultChan)
+		txn, implTransaction, err := p.beginOperation(ctx)
 		if err != nil {
-			// Todo: Same as above, logging
-			transactionAborted = true
-			ctx.Txn.acts.Done()
-			_ = p.abortTransaction(ctx)
 			resultChan <- databases.RequestResult{
 				Data: nil,
 				Err:  err,
 			}
 			return
 		}
-		log, err := p.tManager.GetLog(ctx.ID)
-		if err != nil {
+		opCtx, cancel := txn.operationContext(goCtx)
+		rows, err := p.scan(opCtx, ctx, txn, tableName, from, to, limit)
+		cancel()
+		if err = p.endOperation(ctx, txn, implTransaction, err); err != nil {
 			resultChan <- databases.RequestResult{
 				Data: nil,
 				Err:  err,
 			}
 			return
 		}
-		if res, found := log.findLastValue(hash, key); found {
+		for _, row := range rows {
 			resultChan <- databases.RequestResult{
-				Data: res,
+				Data: row,
 				Err:  nil,
 			}
-			return
 		}
-		res := p.getValue(ctx, tableName, key)
+	}()
+	return resultChan
+}
+
+func (p *AsyncDB) scan(goCtx context.Context, ctx *ConnectionContext, txn *TransactInfo, tableName string, from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	hash := p.hasher.HashStringUint64(tableName)
+	table, ok := p.data.Get(hash)
+	if !ok {
+		return nil, fmt.Errorf("%w - %s", ErrTableNotFound, tableName)
+	}
+	ordered, ok := table.(OrderedTable)
+	if !ok {
+		return nil, fmt.Errorf("%w - %s", ErrScanNotSupported, tableName)
+	}
+	for _, bound := range []interface{}{from, to} {
+		if bound == nil {
+			continue
+		}
+		if err := table.ValidateTypes(bound, nil); err != nil {
+			return nil, err
+		}
+	}
+	// Read-only transactions have no log to merge
+	var written []LogEntry
+	if !txn.opts.ReadOnly {
+		tLog, err := p.tManager.GetLog(ctx.ID)
+		if err != nil {
+			return nil, err
+		}
+		written = tLog.lastEntries(hash)
+	}
+	inRange := func(key interface{}) bool {
+		return (from == nil || ordered.CompareKeys(key, from) >= 0) && (to == nil || ordered.CompareKeys(key, to) <= 0)
+	}
+	own := make([]LogEntry, 0)
+	fetchLimit := limit
+	for _, entry := range written {
+		if !inRange(entry.Key) {
+			continue
+		}
+		own = append(own, entry)
+		// Every row deleted by the transaction can take a place in the table's result
+		if entry.Op == LDelete && fetchLimit > 0 {
+			fetchLimit++
+		}
+	}
+	slices.SortFunc(own, func(a, b LogEntry) int {
+		return ordered.CompareKeys(a.Key, b.Key)
+	})
+	rows, err := p.cc.scan(goCtx, txn, hash, ordered, from, to, fetchLimit)
+	if err != nil {
+		return nil, err
+	}
+	return mergeScan(rows, own, ordered.CompareKeys, limit), nil
+}
 
-		if implTransaction {
-			ctx.Txn.acts.Done()
-			_ = p.CommitTransaction(ctx)
+// mergeScan merges the rows of the table with the writes of the transaction, both sorted by key.
+// For keys present in both, the transaction's write wins
+func mergeScan(rows []KeyValue, own []LogEntry, compare func(a, b interface{}) int, limit int) []KeyValue {
+	res := make([]KeyValue, 0, len(rows)+len(own))
+	i, j := 0, 0
+	for (i < len(rows) || j < len(own)) && (limit <= 0 || len(res) < limit) {
+		c := 0
+		switch {
+		case j == len(own):
+			c = -1
+		case i == len(rows):
+			c = 1
+		default:
+			c = compare(rows[i].Key, own[j].Key)
 		}
-		resultChan <- databases.RequestResult{
-			Data: res.Data,
-			Err:  errors.Join(err, res.Err),
+		if c < 0 {
+			res = append(res, rows[i])
+			i++
+			continue
 		}
-	}()
-	return resultChan
+		if own[j].Op == LPut {
+			res = append(res, KeyValue{Key: own[j].Key, Value: own[j].Value})
+		}
+		if c == 0 {
+			i++
+		}
+		j++
+	}
+	return res
 }
 
-func (p *AsyncDB) Delete(ctx *ConnectionContext, tableName string, key interface{}) <-chan databases.RequestResult {
-	resultChan := make(chan databases.RequestResult, 1)
-	go func() {
-		implTransaction := false
-		transactionAborted := false
-		if !ctx.TxnMu.TryRLock() {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  ErrXactInTerminalState,
-			}
-			return
+// validateLogs checks that every entry can be applied before any table is modified.
+// Deletes are checked against the table state as changed by the earlier entries of the same log
+func (p *AsyncDB) validateLogs(tables map[uint64][]LogEntry) error {
+	for hash, entries := range tables {
+		table, ok := p.data.Get(hash)
+		if !ok {
+			return fmt.Errorf("%w - %d", ErrTableNotFound, hash)
 		}
-		if ctx.Txn == nil {
-			if !p.withImplicitTxn {
-				resultChan <- databases.RequestResult{
-					Data: nil,
-					Err:  ErrConnNotInXact,
+		exists := make(map[interface{}]bool)
+		for _, entry := range entries {
+			switch entry.Op {
+			case LPut:
+				if err := table.ValidateTypes(entry.Key, entry.Value); err != nil {
+					return err
 				}
-				return
+				exists[entry.Key] = true
+			case LDelete:
+				if err := table.ValidateTypes(entry.Key, nil); err != nil {
+					return err
+				}
+				found, ok := exists[entry.Key]
+				if !ok {
+					_, err := table.Get(entry.Key)
+					if err != nil && !errors.Is(err, ErrKeyNotFound) {
+						return err
+					}
+					found = err == nil
+				}
+				if !found {
+					return fmt.Errorf("%w - %v", ErrKeyNotFound, entry.Key)
+				}
+				exists[entry.Key] = false
+			default:
+				return fmt.Errorf("%w: unknown operation %d", ErrInvalidLogEntry, entry.Op)
 			}
-			txnId, err := p.tManager.StartTransaction(ctx.ID)
+		}
+	}
+	return nil
+}
+
+// undoEntry is the before-image of a key modified during commit
+type undoEntry struct {
+	table   Table
+	key     interface{}
+	value   interface{}
+	existed bool
+}
+
+// applyLogs commits the entries. When more than one table can prepare, they vote in the first phase of
+// a two-phase commit, then the decision is logged and the other tables are written directly. Otherwise the
+// decision is logged and every table is written directly, in batches if they are BatchTables, so a single
+// backend never needs prepared transactions. The entries of every table are kept in the order of the transaction.
+// Until the prepared tables are told to commit, any failure rolls back every table
+func (p *AsyncDB) applyLogs(tId TransactId, tables map[uint64][]LogEntry) error {
+	p.commitMu.RLock()
+	defer p.commitMu.RUnlock()
+	preparing := make(map[uint64]PreparableTable)
+	for hash := range tables {
+		table, ok := p.data.Get(hash)
+		if !ok {
+			return fmt.Errorf("%w - %d", ErrTableNotFound, hash)
+		}
+		if pt, ok := asPreparable(table); ok {
+			preparing[hash] = pt
+		}
+	}
+	if len(preparing) < 2 {
+		preparing = nil
+	}
+	prepared := make([]PreparableTable, 0, len(preparing))
+	direct := make(map[uint64][]LogEntry)
+	for hash, entries := range tables {
+		pt, ok := preparing[hash]
+		if !ok {
+			direct[hash] = entries
+			continue
+		}
+		if err := pt.Prepare(tId, entries); err != nil {
+			return errors.Join(err, abortPrepared(tId, prepared))
+		}
+		prepared = append(prepared, pt)
+	}
+	if err := p.logCommit(tId, tables); err != nil {
+		return errors.Join(err, abortPrepared(tId, prepared))
+	}
+	if undo, err := p.applyDirect(direct); err != nil {
+		// The commit record is already in the log, so the abort record has to be durable before anything
+		// is rolled back. Otherwise Recover would apply the transaction that was reported as failed
+		if abortErr := p.logAbort(tId); abortErr != nil {
+			return fmt.Errorf("%w: %w", ErrCommitNotApplied, errors.Join(err, abortErr))
+		}
+		return errors.Join(err, undoLogs(undo), abortPrepared(tId, prepared))
+	}
+	var err error
+	for _, pt := range prepared {
+		err = errors.Join(err, pt.CommitPrepared(tId))
+	}
+	if err != nil {
+		// The decision is final, the remaining prepared transactions are committed by Recover
+		return fmt.Errorf("%w: %w", ErrCommitNotApplied, err)
+	}
+	return nil
+}
+
+// prepareChecker is a preparable table that can only prepare in some configurations,
+// like a PgTable on a server without prepared transactions
+type prepareChecker interface {
+	canPrepare() bool
+}
+
+// asPreparable returns the table as a PreparableTable if it can take part in two-phase commit
+func asPreparable(table Table) (PreparableTable, bool) {
+	pt, ok := table.(PreparableTable)
+	if checker, isChecker := table.(prepareChecker); ok && isChecker {
+		ok = checker.canPrepare()
+	}
+	return pt, ok
+}
+
+func abortPrepared(tId TransactId, prepared []PreparableTable) error {
+	var err error
+	for _, pt := range prepared {
+		err = errors.Join(err, pt.AbortPrepared(tId))
+	}
+	return err
+}
+
+// applyDirect writes the entries to tables that do not use two-phase commit. It returns the before-images
+// of the writes it made, which undo them if any write fails, so either all entries are applied or none
+func (p *AsyncDB) applyDirect(tables map[uint64][]LogEntry) ([]undoEntry, error) {
+	undo := make([]undoEntry, 0)
+	for hash, entries := range tables {
+		table, ok := p.data.Get(hash)
+		if !ok {
+			return undo, fmt.Errorf("%w - %d", ErrTableNotFound, hash)
+		}
+		if bt, ok := table.(BatchTable); ok {
+			keys := make([]interface{}, len(entries))
+			for i, entry := range entries {
+				keys[i] = entry.Key
+			}
+			prev, found, err := bt.GetMany(keys)
 			if err != nil {
-				resultChan <- databases.RequestResult{
-					Data: nil,
-					Err:  errors.Join(fmt.Errorf("error with implicit transaction"), err),
-				}
-				return
+				return undo, err
 			}
-			implTransaction = true
-			ctx.Txn = &TransactInfo{
-				tId:  txnId,
-				mode: Active,
-				ts:   time.Now().UnixNano(),
-				acts: &sync.WaitGroup{},
+			for i, key := range keys {
+				undo = append(undo, undoEntry{table: table, key: key, value: prev[i], existed: found[i]})
 			}
-		}
-		hash := p.hasher.HashStringUint64(tableName)
-		// Possibly Redundant
-		if ctx.Txn.mode == Committing || ctx.Txn.mode == Aborting {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  ErrXactInTerminalState,
+			if err = applyBatch(bt, entries); err != nil {
+				return undo, err
 			}
-			return
+			continue
 		}
-		ctx.Txn.acts.Add(1)
-		defer func() {
-			if !implTransaction && !transactionAborted {
-				ctx.Txn.acts.Done()
+		for _, entry := range entries {
+			prev, err := table.Get(entry.Key)
+			if err != nil && !errors.Is(err, ErrKeyNotFound) {
+				return undo, err
 			}
-		}()
-		ctx.TxnMu.RUnlock()
-		tLog, err := p.tManager.GetLog(ctx.ID)
-		if err != nil {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  err,
+			undo = append(undo, undoEntry{table: table, key: entry.Key, value: prev, existed: err == nil})
+			switch entry.Op {
+			case LPut:
+				err = table.Put(entry.Key, entry.Value)
+			case LDelete:
+				err = table.Delete(entry.Key)
+			}
+			if err != nil {
+				return undo, err
 			}
-			return
 		}
-		err = p.lManager.Lock(WriteLock, ctx.Txn.tId, ctx.Txn.ts, TableId(hash), key)
-		// TODO: Change this logic
-		// Locks are released only when the transaction is aborted
-		// This is temporary, in the future we need a better way of handling this
-		if errors.Is(err, ErrLocksReleased) {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  ErrXactInTerminalState,
+	}
+	return undo, nil
+}
+
+// entryBatchTable is a BatchTable that applies mixed puts and deletes at once, like PgTable does in one
+// Postgres transaction
+type entryBatchTable interface {
+	applyEntries(entries []LogEntry) error
+}
+
+// applyBatch writes every run of consecutive entries with the same operation in one call, keeping their order.
+// Tables that apply mixed entries get all of them in one call
+func applyBatch(table BatchTable, entries []LogEntry) error {
+	if et, ok := table.(entryBatchTable); ok {
+		return et.applyEntries(entries)
+	}
+	for start := 0; start < len(entries); {
+		end := start + 1
+		for end < len(entries) && entries[end].Op == entries[start].Op {
+			end++
+		}
+		var err error
+		switch entries[start].Op {
+		case LPut:
+			rows := make([]KeyValue, 0, end-start)
+			for _, entry := range entries[start:end] {
+				rows = append(rows, KeyValue{Key: entry.Key, Value: entry.Value})
 			}
-			return
+			err = table.PutMany(rows)
+		case LDelete:
+			keys := make([]interface{}, 0, end-start)
+			for _, entry := range entries[start:end] {
+				keys = append(keys, entry.Key)
+			}
+			err = table.DeleteMany(keys)
 		}
 		if err != nil {
-			// Todo: Same as above, logging
-			transactionAborted = true
-			ctx.Txn.acts.Done()
-			_ = p.abortTransaction(ctx)
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  err,
-			}
-			return
+			return err
 		}
-		res := p.getValue(ctx, tableName, key)
-		if res.Err != nil {
-			resultChan <- databases.RequestResult{
-				Data: nil,
-				Err:  res.Err,
-			}
+		start = end
+	}
+	return nil
+}
 
-			return
+// undoLogs restores the before-images in reverse order
+func undoLogs(undo []undoEntry) error {
+	var err error
+	for i := len(undo) - 1; i >= 0; i-- {
+		u := undo[i]
+		if u.existed {
+			err = errors.Join(err, u.table.Put(u.key, u.value))
+		} else if delErr := u.table.Delete(u.key); !errors.Is(delErr, ErrKeyNotFound) {
+			err = errors.Join(err, delErr)
 		}
+	}
+	return err
+}
 
-		tLog.addAction(Action{
-			Op:      LDelete,
-			tableId: hash,
-			Key:     key,
-			Value:   nil,
+// logCommit writes the commit record of the transaction to the write-ahead log.
+// Once it returns nil, the transaction is committed even if the process crashes before applying the log
+func (p *AsyncDB) logCommit(tId TransactId, tables map[uint64][]LogEntry) error {
+	if p.wal == nil || len(tables) == 0 {
+		return nil
+	}
+	rec := WALRecord{Type: WALCommit, TxnId: tId, Tables: tables}
+	if err := p.wal.Append(rec); err != nil {
+		return err
+	}
+	p.logged.Add(1)
+	p.addToTail(rec)
+	return nil
+}
+
+// logAbort marks a logged transaction as rolled back, so it is skipped by Recover
+func (p *AsyncDB) logAbort(tId TransactId) error {
+	if p.wal == nil {
+		return nil
+	}
+	rec := WALRecord{Type: WALAbort, TxnId: tId}
+	if err := p.wal.Append(rec); err != nil {
+		return err
+	}
+	p.addToTail(rec)
+	return nil
+}
+
+// addToTail keeps the record for the checkpoint scanning the tables, if there is one
+func (p *AsyncDB) addToTail(rec WALRecord) {
+	p.tailMu.Lock()
+	defer p.tailMu.Unlock()
+	if p.tail != nil {
+		p.tail = append(p.tail, rec)
+	}
+}
+
+// Recover finishes the transactions that were in flight when the process stopped. Prepared transactions
+// are committed if their commit record is in the write-ahead log and aborted otherwise, then the committed
+// transactions are replayed into the tables.
+// Tables must be created before calling Recover, as records reference tables by their name hash
+func (p *AsyncDB) Recover() error {
+	records := make([]WALRecord, 0)
+	committed := make(map[TransactId]bool)
+	if p.wal != nil {
+		err := p.wal.Replay(func(rec WALRecord) error {
+			records = append(records, rec)
+			committed[rec.TxnId] = true
+			return nil
 		})
-		if implTransaction {
-			ctx.Txn.acts.Done()
-			_ = p.CommitTransaction(ctx)
+		if err != nil {
+			return err
 		}
-		resultChan <- databases.RequestResult{
-			Data: nil,
-			Err:  err,
+	}
+	// Prepared transactions can hold locks in their tables, so they are resolved before replaying
+	if err := p.resolvePrepared(committed); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := p.replayRecord(rec); err != nil {
+			return err
+		}
+	}
+	// The replayed writes are applied, so the log restarts from them
+	return p.Checkpoint()
+}
+
+// durableTable is a table that persists its writes by itself, like a database table, so checkpoints skip it
+type durableTable interface {
+	durable() bool
+}
+
+// checkpointDue reports whether enough commits were logged since the last checkpoint
+func (p *AsyncDB) checkpointDue() bool {
+	return p.checkpointEvery > 0 && p.logged.Load() >= p.checkpointEvery
+}
+
+// checkpointInBackground starts a checkpoint unless one is running. The commit that made it due has already
+// succeeded, so a failed checkpoint is only logged, and the old log stays in place until the next one
+func (p *AsyncDB) checkpointInBackground() {
+	if !p.checkpointing.CompareAndSwap(false, true) {
+		return
+	}
+	p.checkpoints.Add(1)
+	go func() {
+		defer p.checkpoints.Done()
+		for {
+			err := p.Checkpoint()
+			p.checkpointing.Store(false)
+			if err != nil {
+				log.Printf("Failed to checkpoint the write-ahead log: %v", err)
+				return
+			}
+			// Commits that found this checkpoint running did not start another one
+			if !p.checkpointDue() || !p.checkpointing.CompareAndSwap(false, true) {
+				return
+			}
 		}
 	}()
-	return resultChan
 }
 
-// TODO: Implement this
-func (p *AsyncDB) applyLogs(log *TransactionLog) error {
-	// Todo: Add validation for the transaction log before applying
-	// Todo: I should not do it this way, it is better to implement a Range function of some sort
-	log.l.Lock()
-	defer log.l.Unlock()
-	for hash, actions := range log.l.m {
+// Checkpoint replaces the write-ahead log with a snapshot of the tables that do not persist their writes,
+// so the log does not grow forever. The tables are scanned while commits go on, and the records logged
+// meanwhile are written after the snapshot, so Recover brings the rows they changed up to date. The commit
+// records of transactions that are still prepared in a table are kept, so Recover can finish them
+func (p *AsyncDB) Checkpoint() error {
+	if p.wal == nil {
+		return nil
+	}
+	p.checkpointMu.Lock()
+	defer p.checkpointMu.Unlock()
+	// Every commit logged before the tail starts is fully applied, or rolled back, before the scan
+	p.commitMu.Lock()
+	pending, err := p.pendingRecords()
+	if err == nil {
+		p.setTail(make([]WALRecord, 0))
+	}
+	p.commitMu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer p.setTail(nil)
+	snapshot, err := p.snapshot()
+	if err != nil {
+		return err
+	}
+
+	p.commitMu.Lock()
+	defer p.commitMu.Unlock()
+	p.tailMu.Lock()
+	tail := p.tail
+	p.tailMu.Unlock()
+	if slices.ContainsFunc(tail, func(rec WALRecord) bool { return rec.Type == WALAbort }) {
+		// The scan could have seen writes of a transaction that was rolled back afterwards,
+		// so the snapshot is taken again while no commit is applied
+		if snapshot, err = p.snapshot(); err != nil {
+			return err
+		}
+		tail = nil
+	}
+	// The snapshot goes after the pending transactions, as the rows it holds are newer
+	records := pending
+	if len(snapshot) > 0 {
+		records = append(records, WALRecord{Type: WALCommit, Tables: snapshot})
+	}
+	records = append(records, tail...)
+	if err = p.wal.Checkpoint(records); err != nil {
+		return err
+	}
+	p.logged.Store(int64(len(tail)))
+	return nil
+}
+
+func (p *AsyncDB) setTail(tail []WALRecord) {
+	p.tailMu.Lock()
+	defer p.tailMu.Unlock()
+	p.tail = tail
+}
+
+// pendingRecords returns the commit records of the transactions that are prepared in a table
+func (p *AsyncDB) pendingRecords() ([]WALRecord, error) {
+	pending := make(map[TransactId]bool)
+	for _, table := range p.data.Values() {
+		pt, ok := table.(PreparableTable)
+		if !ok {
+			continue
+		}
+		tids, err := pt.PreparedTransactions()
+		if err != nil {
+			return nil, err
+		}
+		for _, tid := range tids {
+			pending[tid] = true
+		}
+	}
+	records := make([]WALRecord, 0)
+	if len(pending) == 0 {
+		return records, nil
+	}
+	err := p.wal.Replay(func(rec WALRecord) error {
+		if pending[rec.TxnId] {
+			records = append(records, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// snapshot returns the rows of the tables that do not persist their writes. CreateTable makes sure these
+// tables can be scanned when the write-ahead log is configured
+func (p *AsyncDB) snapshot() (map[uint64][]LogEntry, error) {
+	snapshot := make(map[uint64][]LogEntry)
+	for _, hash := range p.data.Keys() {
 		table, ok := p.data.Get(hash)
+		if !ok || !needsCheckpoint(table) {
+			continue
+		}
+		rows, err := table.(OrderedTable).Scan(nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		entries := make([]LogEntry, len(rows))
+		for i, row := range rows {
+			entries[i] = LogEntry{Op: LPut, Key: row.Key, Value: row.Value}
+		}
+		snapshot[hash] = entries
+	}
+	return snapshot, nil
+}
+
+// needsCheckpoint reports whether the rows of the table are kept only by the write-ahead log
+func needsCheckpoint(table Table) bool {
+	dt, ok := table.(durableTable)
+	return !ok || !dt.durable()
+}
+
+func (p *AsyncDB) resolvePrepared(committed map[TransactId]bool) error {
+	for _, table := range p.data.Values() {
+		pt, ok := table.(PreparableTable)
 		if !ok {
-			return fmt.Errorf("%w - %d", ErrTableNotFound, hash)
+			continue
 		}
-		for _, action := range actions {
-			switch action.Op {
-			case LPut:
-				err := table.Put(action.Key, action.Value)
-				if err != nil {
-					return err
-				}
-			case LDelete:
-				err := table.Delete(action.Key)
-				if err != nil {
-					return err
-				}
+		tids, err := pt.PreparedTransactions()
+		if err != nil {
+			return err
+		}
+		for _, tid := range tids {
+			if committed[tid] {
+				err = pt.CommitPrepared(tid)
+			} else {
+				err = pt.AbortPrepared(tid)
+			}
+			if err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
 
-func (p *AsyncDB) getValue(_ *ConnectionContext, tableName string, key interface{}) databases.RequestResult {
-	hash := p.hasher.HashStringUint64(tableName)
-	table, ok := p.data.Get(hash)
-	if !ok {
-		return databases.RequestResult{
-			Data: nil,
-			Err:  fmt.Errorf("%w - %s", ErrTableNotFound, tableName),
+func (p *AsyncDB) replayRecord(rec WALRecord) error {
+	for hash, entries := range rec.Tables {
+		table, ok := p.data.Get(hash)
+		if !ok {
+			return fmt.Errorf("%w - %d", ErrTableNotFound, hash)
+		}
+		for _, entry := range entries {
+			var err error
+			switch entry.Op {
+			case LPut:
+				err = table.Put(entry.Key, entry.Value)
+			case LDelete:
+				// The delete could have been applied before the crash
+				if err = table.Delete(entry.Key); errors.Is(err, ErrKeyNotFound) {
+					err = nil
+				}
+			}
+			if err != nil {
+				return err
+			}
 		}
 	}
-	value, err := table.Get(key)
-	return databases.RequestResult{
-		Data: value,
-		Err:  err,
-	}
+	return nil
 }
@@ -0,0 +1,227 @@
+package asyncdb
+
+import (
+	"slices"
+	"sync"
+)
+
+// DeadlockPolicy decides what a transaction does when the lock it requests is held by other transactions
+type DeadlockPolicy int
+
+const (
+	// WaitDie lets a transaction wait only for younger transactions, younger requesters abort ("die")
+	WaitDie DeadlockPolicy = iota
+	// WoundWait lets a transaction always wait, but an older requester aborts ("wounds") younger holders
+	WoundWait
+	// NoWait aborts the requester on every conflict
+	NoWait
+	// DeadlockDetection lets transactions wait, and aborts the youngest transaction of a cycle in the waits-for graph
+	DeadlockDetection
+)
+
+// WoundingLockManager aborts transactions other than the requester, so it has to notify the owner of
+// the transactions. AsyncDB registers its handler on construction
+type WoundingLockManager interface {
+	LockManager
+	SetWoundHandler(handler func(tid TransactId))
+}
+
+func WithDeadlockPolicy(policy DeadlockPolicy) func(*LockManagerImpl) {
+	return func(lm *LockManagerImpl) {
+		lm.policy = policy
+	}
+}
+
+func (lm *LockManagerImpl) SetWoundHandler(handler func(tid TransactId)) {
+	lm.onWound = handler
+}
+
+// resolveConflict applies the deadlock policy to a transaction that is about to wait on the channel for the conflicting
+// transactions. An error means the requester has to abort. Otherwise, it waits, and the returned victims have to be
+// wounded by the caller once it does not hold any lock table mutexes. cancel stops the wait if the requester is wounded
+func (lm *LockManagerImpl) resolveConflict(xact *Transaction, wait chan error, cancel func(), conflicts []*Transaction) ([]TransactId, error) {
+	switch lm.policy {
+	case NoWait:
+		return nil, ErrLockConflict
+	case WoundWait:
+		if _, err := lm.waits.wait(xact, wait, cancel, conflicts, false); err != nil {
+			return nil, err
+		}
+		victims := make([]TransactId, 0)
+		for _, other := range conflicts {
+			if xact.isOlderThan(other) && !slices.Contains(victims, other.tId) {
+				victims = append(victims, other.tId)
+			}
+		}
+		return victims, nil
+	case DeadlockDetection:
+		victim, err := lm.waits.wait(xact, wait, cancel, conflicts, true)
+		if err != nil {
+			return nil, err
+		}
+		if victim != nil {
+			return []TransactId{victim.tId}, nil
+		}
+		return nil, nil
+	default:
+		if !isOlderThanAll(xact, conflicts) {
+			return nil, ErrLockConflict
+		}
+		return nil, nil
+	}
+}
+
+// stopWaiting removes the wait from the waits-for graph once it is granted or failed
+func (lm *LockManagerImpl) stopWaiting(tid TransactId, wait chan error) {
+	if lm.policy == WoundWait || lm.policy == DeadlockDetection {
+		lm.waits.stop(tid, wait)
+	}
+}
+
+// wound aborts the victims: their waits fail with ErrLockConflict, further lock requests are refused,
+// and the wound handler is notified to release the locks they hold
+func (lm *LockManagerImpl) wound(victims []TransactId) {
+	for _, tid := range victims {
+		// A transaction that released its locks is not waiting, and remembering it would leak
+		if lm.transactReleased.contains(tid) {
+			continue
+		}
+		for _, cancel := range lm.waits.wound(tid) {
+			cancel()
+		}
+		if lm.onWound != nil {
+			lm.onWound(tid)
+		}
+	}
+}
+
+func (lm *LockManagerImpl) isWounded(tid TransactId) bool {
+	if lm.policy != WoundWait && lm.policy != DeadlockDetection {
+		return false
+	}
+	return lm.waits.isWounded(tid)
+}
+
+type waitEdge struct {
+	holders []*Transaction
+	cancel  func()
+}
+
+// waitsForGraph keeps the transactions every waiting transaction waits for. A transaction can wait
+// for several locks at once, as operations of a transaction run concurrently, so edges are kept per wait
+type waitsForGraph struct {
+	waits   map[TransactId]map[chan error]*waitEdge
+	wounded map[TransactId]bool
+	m       *sync.Mutex
+}
+
+func newWaitsForGraph() *waitsForGraph {
+	return &waitsForGraph{
+		waits:   make(map[TransactId]map[chan error]*waitEdge),
+		wounded: make(map[TransactId]bool),
+		m:       &sync.Mutex{},
+	}
+}
+
+// wait records that xact waits for the holders. With detect, it returns the youngest transaction of a cycle
+// the wait closes. If that is xact itself, the wait is not recorded and ErrLockConflict is returned
+func (g *waitsForGraph) wait(xact *Transaction, wait chan error, cancel func(), holders []*Transaction, detect bool) (*Transaction, error) {
+	g.m.Lock()
+	defer g.m.Unlock()
+	if g.wounded[xact.tId] {
+		return nil, ErrLockConflict
+	}
+	if _, ok := g.waits[xact.tId]; !ok {
+		g.waits[xact.tId] = make(map[chan error]*waitEdge)
+	}
+	g.waits[xact.tId][wait] = &waitEdge{holders: holders, cancel: cancel}
+	if !detect {
+		return nil, nil
+	}
+	cycle := g.findCycle(xact)
+	if cycle == nil {
+		return nil, nil
+	}
+	victim := xact
+	for _, other := range cycle {
+		if victim.isOlderThan(other) {
+			victim = other
+		}
+	}
+	if victim.tId == xact.tId {
+		g.removeWait(xact.tId, wait)
+		return nil, ErrLockConflict
+	}
+	return victim, nil
+}
+
+// findCycle returns the transactions on a path from xact back to itself, or nil if there is none
+func (g *waitsForGraph) findCycle(xact *Transaction) []*Transaction {
+	visited := make(map[TransactId]bool)
+	path := make([]*Transaction, 0)
+	var visit func(tid TransactId) bool
+	visit = func(tid TransactId) bool {
+		for _, edge := range g.waits[tid] {
+			for _, holder := range edge.holders {
+				if holder.tId == xact.tId {
+					return true
+				}
+				if visited[holder.tId] {
+					continue
+				}
+				visited[holder.tId] = true
+				path = append(path, holder)
+				if visit(holder.tId) {
+					return true
+				}
+				path = path[:len(path)-1]
+			}
+		}
+		return false
+	}
+	if visit(xact.tId) {
+		return path
+	}
+	return nil
+}
+
+func (g *waitsForGraph) stop(tid TransactId, wait chan error) {
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.removeWait(tid, wait)
+}
+
+func (g *waitsForGraph) removeWait(tid TransactId, wait chan error) {
+	delete(g.waits[tid], wait)
+	if len(g.waits[tid]) == 0 {
+		delete(g.waits, tid)
+	}
+}
+
+// wound marks the transaction as aborted and returns the functions cancelling its current waits.
+// They are called by the caller, as they lock the lock tables
+func (g *waitsForGraph) wound(tid TransactId) []func() {
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.wounded[tid] = true
+	cancels := make([]func(), 0, len(g.waits[tid]))
+	for _, edge := range g.waits[tid] {
+		cancels = append(cancels, edge.cancel)
+	}
+	delete(g.waits, tid)
+	return cancels
+}
+
+func (g *waitsForGraph) isWounded(tid TransactId) bool {
+	g.m.Lock()
+	defer g.m.Unlock()
+	return g.wounded[tid]
+}
+
+// remove forgets the transaction once its locks are released
+func (g *waitsForGraph) remove(tid TransactId) {
+	g.m.Lock()
+	defer g.m.Unlock()
+	delete(g.waits, tid)
+	delete(g.wounded, tid)
+}
@@ -0,0 +1,209 @@
+package asyncdb
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"slices"
+)
+
+// Table lock modes. Key and range locks take an intention lock on their table first (IS for reads, IX for writes),
+// so a transaction locking the whole table with S or X only checks the table locks of the others
+const (
+	IntentionShared = 1 + iota
+	IntentionExclusive
+	Shared
+	// SharedIntentionExclusive is held by a transaction that locked the table with S and then wrote keys
+	SharedIntentionExclusive
+	Exclusive
+)
+
+// defaultEscalationThreshold is the number of locks a transaction can hold in a table before they are escalated
+const defaultEscalationThreshold = 1000
+
+// tableModesCompatible is the compatibility matrix of the table lock modes
+var tableModesCompatible = map[int][]int{
+	IntentionShared:          {IntentionShared, IntentionExclusive, Shared, SharedIntentionExclusive},
+	IntentionExclusive:       {IntentionShared, IntentionExclusive},
+	Shared:                   {IntentionShared, Shared},
+	SharedIntentionExclusive: {IntentionShared},
+	Exclusive:                {},
+}
+
+// TableLock is the table lock mode held by a transaction
+type TableLock struct {
+	xact *Transaction
+	Mode int
+}
+
+// WithEscalationThreshold sets the number of locks a transaction can hold in a table before they are replaced
+// by a single table lock. Escalation is skipped while other transactions hold conflicting locks in the table.
+// Zero disables escalation
+func WithEscalationThreshold(threshold int) func(*LockManagerImpl) {
+	return func(lm *LockManagerImpl) {
+		lm.escalationThreshold = threshold
+	}
+}
+
+// combineModes returns the weakest mode that grants both modes
+func combineModes(a int, b int) int {
+	switch {
+	case a == 0 || a == b:
+		return b
+	case b == 0:
+		return a
+	case a == Exclusive || b == Exclusive:
+		return Exclusive
+	case a == SharedIntentionExclusive || b == SharedIntentionExclusive:
+		return SharedIntentionExclusive
+	case (a == Shared && b == IntentionExclusive) || (a == IntentionExclusive && b == Shared):
+		return SharedIntentionExclusive
+	default:
+		return max(a, b)
+	}
+}
+
+// coversLock checks if a table mode grants the lock type on every key of the table
+func coversLock(mode int, lockType int) bool {
+	if lockType == ReadLock {
+		return mode == Shared || mode == SharedIntentionExclusive || mode == Exclusive
+	}
+	return mode == Exclusive
+}
+
+// tableMode returns the table lock mode held by the transaction. Requires table.m
+func (table *LockTable) tableMode(tid TransactId) int {
+	if l, ok := table.TableLocks[tid]; ok {
+		return l.Mode
+	}
+	return 0
+}
+
+// conflictingTableLocks returns the other transactions holding table locks incompatible with the mode. Requires table.m
+func (lm *LockManagerImpl) conflictingTableLocks(table *LockTable, tid TransactId, mode int) []*Transaction {
+	holders := make([]*Transaction, 0)
+	for other, l := range table.TableLocks {
+		if other == tid || slices.Contains(tableModesCompatible[mode], l.Mode) {
+			continue
+		}
+		if lm.transactReleased.contains(other) {
+			continue
+		}
+		holders = append(holders, l.xact)
+	}
+	return holders
+}
+
+// lockIntention takes the intention lock for a key or range lock of the lock type. If the table lock of
+// the transaction already grants the lock, no intention is needed and covered is true
+func (lm *LockManagerImpl) lockIntention(ctx context.Context, xact *Transaction, tableId TableId, lockType int) (covered bool, err error) {
+	lm.addTableIfNotExists(tableId)
+	table, _ := lm.lockMap.Get(tableId)
+	table.m.Lock()
+	held := table.tableMode(xact.tId)
+	intention := IntentionShared
+	if lockType == WriteLock {
+		intention = IntentionExclusive
+	}
+	mode := combineModes(held, intention)
+	table.m.Unlock()
+	if coversLock(held, lockType) {
+		return true, nil
+	}
+	if mode == held {
+		return false, nil
+	}
+	if !lm.addLockInfoIfNotExists(xact.tId, tableId) {
+		return false, ErrLocksReleased
+	}
+	if lm.isWounded(xact.tId) {
+		return false, ErrLockConflict
+	}
+	err = lm.waitForRanges(ctx, table, xact, func() []*Transaction {
+		return lm.conflictingTableLocks(table, xact.tId, combineModes(table.tableMode(xact.tId), intention))
+	})
+	if err != nil {
+		return false, err
+	}
+	if lm.transactReleased.contains(xact.tId) {
+		table.m.Unlock()
+		return false, ErrLocksReleased
+	}
+	table.TableLocks[xact.tId] = &TableLock{xact: xact, Mode: combineModes(table.tableMode(xact.tId), intention)}
+	table.m.Unlock()
+	// The intention is recorded once granted, so that the next key locks can skip taking it
+	if !lm.addLockInfoIfNotExists(xact.tId, tableId, LockInfo{key: nil, lockType: lockType}) {
+		return false, ErrLocksReleased
+	}
+	return false, nil
+}
+
+// escalateIfNeeded replaces the key locks of the transaction in the table by a table lock, S if it only reads
+// and X if it writes, once it holds more locks than the threshold. If other transactions hold conflicting
+// table locks, the escalation is skipped, and tried again on the next lock
+func (lm *LockManagerImpl) escalateIfNeeded(xact *Transaction, tableId TableId) {
+	if lm.escalationThreshold <= 0 {
+		return
+	}
+	transactLocks, ok := lm.transactMap.Get(xact.tId)
+	if !ok {
+		return
+	}
+	if infos, _ := transactLocks.Get(tableId); len(infos) <= lm.escalationThreshold {
+		return
+	}
+	table, _ := lm.lockMap.Get(tableId)
+	table.m.Lock()
+	held := table.tableMode(xact.tId)
+	mode := Shared
+	if held != IntentionShared {
+		mode = Exclusive
+	}
+	mode = combineModes(held, mode)
+	if len(lm.conflictingTableLocks(table, xact.tId, mode)) > 0 {
+		table.m.Unlock()
+		return
+	}
+	if !coversLock(held, ReadLock) {
+		table.coarse.Add(1)
+	}
+	table.TableLocks[xact.tId] = &TableLock{xact: xact, Mode: mode}
+	table.m.Unlock()
+	// The locks are collected after the table lock is set, so a key lock granted concurrently is either
+	// collected here or released by its requester, which finds it covered
+	transactLocks.Lock()
+	infos, _ := transactLocks.GetUnsafe(tableId)
+	kept := make([]LockInfo, 0)
+	released := make([]interface{}, 0)
+	for _, info := range infos {
+		if info.key != nil && coversLock(mode, info.lockType) {
+			released = append(released, info.key)
+		} else if !slices.Contains(kept, info) {
+			kept = append(kept, info)
+		}
+	}
+	transactLocks.PutUnsafe(tableId, kept)
+	transactLocks.Unlock()
+	victims := make([]TransactId, 0)
+	for _, key := range released {
+		victims = append(victims, lm.releaseKey(table, xact.tId, key, mode == Exclusive)...)
+	}
+	lm.wound(victims)
+}
+
+// releaseKey releases the read lock of the transaction on the key, and the write lock if write is set.
+// Returns the transactions to wound
+func (lm *LockManagerImpl) releaseKey(table *LockTable, tid TransactId, key interface{}, write bool) []TransactId {
+	ol, ok := table.Locks.Get(key)
+	if !ok {
+		return nil
+	}
+	ol.m.Lock()
+	if write && ol.WLock.tId == tid {
+		ol.WLock = &Transaction{TransactId(uuid.Nil), 0}
+	}
+	ol.RLock = slices.DeleteFunc(ol.RLock, func(r *Transaction) bool { return r.tId == tid })
+	victims := lm.processQueue(ol, TransactId(uuid.Nil))
+	ol.m.Unlock()
+	lm.reclaimKey(table, key)
+	return victims
+}
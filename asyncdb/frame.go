@@ -0,0 +1,77 @@
+package asyncdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Records of the write-ahead log and of log tables are written as frames: the payload length and its CRC32
+// checksum, followed by the gob encoded payload
+
+// frameHeaderSize is the size of the frame header
+const frameHeaderSize = 8
+
+// frameMaxSize protects readers from allocating garbage lengths of a torn header
+const frameMaxSize = 1 << 30
+
+// encodeFrame encodes the value with encoding/gob and frames it
+func encodeFrame(v any) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(v); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, frameHeaderSize+payload.Len())
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(payload.Len()))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	copy(buf[frameHeaderSize:], payload.Bytes())
+	return buf, nil
+}
+
+// framePayload returns the payload of a whole frame, and whether its checksum matches
+func framePayload(frame []byte) ([]byte, bool) {
+	payload := frame[frameHeaderSize:]
+	return payload, crc32.ChecksumIEEE(payload) == binary.LittleEndian.Uint32(frame[4:8])
+}
+
+// readFrames calls f with the payload, offset and size of every frame of the reader, and returns the size
+// of the valid prefix. A frame torn by a crash can only be the last one, so reading stops at a partial
+// header or payload, or a checksum mismatch, and anything after it is garbage. Errors of f are returned as is
+func readFrames(r io.Reader, f func(payload []byte, offset int64, size int64) error) (int64, error) {
+	reader := bufio.NewReader(r)
+	var offset int64
+	header := make([]byte, frameHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			// io.EOF - clean end of the log, io.ErrUnexpectedEOF - torn header
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return offset, nil
+			}
+			return 0, fmt.Errorf("failed to read frame: %w", err)
+		}
+		size := binary.LittleEndian.Uint32(header[0:4])
+		if size > frameMaxSize {
+			return offset, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return offset, nil
+			}
+			return 0, fmt.Errorf("failed to read frame: %w", err)
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+			return offset, nil
+		}
+		frameSize := int64(frameHeaderSize) + int64(size)
+		if err := f(payload, offset, frameSize); err != nil {
+			return 0, err
+		}
+		offset += frameSize
+	}
+}
@@ -1,10 +1,17 @@
 package asyncdb
 
-import "fmt"
+import (
+	"cmp"
+	"fmt"
+)
 
 type InMemoryTable[K comparable, V any] struct {
-	name string
-	data *ThreadSafeMap[K, V]
+	name   string
+	data   *ThreadSafeMap[K, V]
+	staged *ThreadSafeMap[TransactId, []LogEntry]
+	// index keeps the keys ordered for scans. It is built by the first scan and is guarded by the data lock
+	index   *skipList[K]
+	compare func(a, b K) int
 }
 
 func NewInMemoryTable[K comparable, V any](name string) (*InMemoryTable[K, V], error) {
@@ -12,11 +19,35 @@ func NewInMemoryTable[K comparable, V any](name string) (*InMemoryTable[K, V], e
 		return nil, ErrEmptyTableName
 	}
 	return &InMemoryTable[K, V]{
-		name: name,
-		data: NewThreadSafeMap[K, V](),
+		name:    name,
+		data:    NewThreadSafeMap[K, V](),
+		staged:  NewThreadSafeMap[TransactId, []LogEntry](),
+		compare: defaultKeyOrder[K](),
 	}, nil
 }
 
+// defaultKeyOrder uses cmp.Compare for ordered key types, and CompareKeys for everything else
+func defaultKeyOrder[K comparable]() func(a, b K) int {
+	switch any(*new(K)).(type) {
+	case int:
+		return func(a, b K) int { return cmp.Compare(any(a).(int), any(b).(int)) }
+	case int64:
+		return func(a, b K) int { return cmp.Compare(any(a).(int64), any(b).(int64)) }
+	case string:
+		return func(a, b K) int { return cmp.Compare(any(a).(string), any(b).(string)) }
+	default:
+		return func(a, b K) int { return CompareKeys(a, b) }
+	}
+}
+
+// SetKeyOrder replaces the order of keys used by Scan
+func (t *InMemoryTable[K, V]) SetKeyOrder(compare func(a, b K) int) {
+	t.data.Lock()
+	defer t.data.Unlock()
+	t.compare = compare
+	t.index = nil
+}
+
 func (t *InMemoryTable[K, V]) Name() string {
 	return t.name
 }
@@ -42,7 +73,9 @@ func (t *InMemoryTable[K, V]) Put(key interface{}, value interface{}) error {
 	if !valueOk {
 		return fmt.Errorf("%w: expected value type - %T, got - %T", ErrTypeMismatch, *new(V), value)
 	}
-	t.data.Put(keyTyped, valueTyped)
+	t.data.Lock()
+	t.putUnsafe(keyTyped, valueTyped)
+	t.data.Unlock()
 	return nil
 }
 
@@ -51,10 +84,58 @@ func (t *InMemoryTable[K, V]) Delete(key interface{}) error {
 	if !ok {
 		return fmt.Errorf("%w: %T", ErrTypeMismatch, key)
 	}
-	t.data.Delete(keyTyped)
+	t.data.Lock()
+	t.deleteUnsafe(keyTyped)
+	t.data.Unlock()
 	return nil
 }
 
+func (t *InMemoryTable[K, V]) putUnsafe(key K, value V) {
+	t.data.PutUnsafe(key, value)
+	if t.index != nil {
+		t.index.Insert(key)
+	}
+}
+
+func (t *InMemoryTable[K, V]) deleteUnsafe(key K) {
+	t.data.DeleteUnsafe(key)
+	if t.index != nil {
+		t.index.Delete(key)
+	}
+}
+
+func (t *InMemoryTable[K, V]) CompareKeys(a interface{}, b interface{}) int {
+	aTyped, aOk := a.(K)
+	bTyped, bOk := b.(K)
+	if !aOk || !bOk {
+		return CompareKeys(a, b)
+	}
+	return t.compare(aTyped, bTyped)
+}
+
+func (t *InMemoryTable[K, V]) Scan(from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	fromTyped, err := scanBound[K](from)
+	if err != nil {
+		return nil, err
+	}
+	toTyped, err := scanBound[K](to)
+	if err != nil {
+		return nil, err
+	}
+	t.data.Lock()
+	defer t.data.Unlock()
+	if t.index == nil {
+		t.index = newSkipList[K](t.compare)
+		for key := range t.data.m {
+			t.index.Insert(key)
+		}
+	}
+	return t.index.scan(fromTyped, toTyped, limit, func(key K) (interface{}, error) {
+		value, _ := t.data.GetUnsafe(key)
+		return value, nil
+	})
+}
+
 func (t *InMemoryTable[K, V]) ValidateTypes(key interface{}, value interface{}) error {
 	_, keyOk := key.(K)
 	if !keyOk {
@@ -69,7 +150,72 @@ func (t *InMemoryTable[K, V]) ValidateTypes(key interface{}, value interface{})
 	return nil
 }
 
+// Prepare validates the entries and stages them until CommitPrepared or AbortPrepared
+func (t *InMemoryTable[K, V]) Prepare(tid TransactId, entries []LogEntry) error {
+	t.data.Lock()
+	defer t.data.Unlock()
+	exists := make(map[K]bool)
+	for _, entry := range entries {
+		if err := t.ValidateTypes(entry.Key, entry.Value); err != nil {
+			return err
+		}
+		key := entry.Key.(K)
+		switch entry.Op {
+		case LPut:
+			if _, ok := entry.Value.(V); !ok {
+				return fmt.Errorf("%w: expected value type - %T, got - %T", ErrTypeMismatch, *new(V), entry.Value)
+			}
+			exists[key] = true
+		case LDelete:
+			found, ok := exists[key]
+			if !ok {
+				_, found = t.data.GetUnsafe(key)
+			}
+			if !found {
+				return fmt.Errorf("%w - %v", ErrKeyNotFound, entry.Key)
+			}
+			exists[key] = false
+		}
+	}
+	t.staged.Put(tid, entries)
+	return nil
+}
+
+func (t *InMemoryTable[K, V]) CommitPrepared(tid TransactId) error {
+	entries, ok := t.staged.Get(tid)
+	if !ok {
+		return ErrXactNotPrepared
+	}
+	t.data.Lock()
+	for _, entry := range entries {
+		switch entry.Op {
+		case LPut:
+			t.putUnsafe(entry.Key.(K), entry.Value.(V))
+		case LDelete:
+			t.deleteUnsafe(entry.Key.(K))
+		}
+	}
+	t.data.Unlock()
+	t.staged.Delete(tid)
+	return nil
+}
+
+func (t *InMemoryTable[K, V]) AbortPrepared(tid TransactId) error {
+	if _, ok := t.staged.Get(tid); !ok {
+		return ErrXactNotPrepared
+	}
+	t.staged.Delete(tid)
+	return nil
+}
+
+func (t *InMemoryTable[K, V]) PreparedTransactions() ([]TransactId, error) {
+	return t.staged.Keys(), nil
+}
+
 func LoadTable[K comparable, V any](name string, data map[K]V, table *InMemoryTable[K, V]) {
+	table.data.Lock()
+	defer table.data.Unlock()
 	table.name = name
 	table.data.m = data
+	table.index = nil
 }
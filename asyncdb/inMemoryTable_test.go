@@ -1,6 +1,7 @@
 package asyncdb
 
 import (
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -105,3 +106,121 @@ func TestInMemoryTable_Get(t *testing.T) {
 		})
 	}
 }
+
+func TestInMemoryTable_Prepare(t *testing.T) {
+	cases := []struct {
+		name      string
+		entries   []LogEntry
+		errorWant string
+	}{
+		{
+			name:    "Put and Delete",
+			entries: []LogEntry{{Op: LPut, Key: 2, Value: 3}, {Op: LDelete, Key: 1}},
+		},
+		{
+			name:      "Delete non-existent key",
+			entries:   []LogEntry{{Op: LDelete, Key: 2}},
+			errorWant: "key not found - 2",
+		},
+		{
+			name:      "Type Mismatch on Value",
+			entries:   []LogEntry{{Op: LPut, Key: 2, Value: "3"}},
+			errorWant: "type mismatch: expected value type - int, got - string",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			table, _ := NewInMemoryTable[int, int]("test")
+			_ = table.Put(1, 2)
+			err := table.Prepare(TransactId(uuid.New()), c.entries)
+			if c.errorWant != "" {
+				assert.EqualError(t, err, c.errorWant)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestInMemoryTable_CommitPrepared_Should_Apply_Staged_Entries(t *testing.T) {
+	table, _ := NewInMemoryTable[int, int]("test")
+	_ = table.Put(1, 2)
+	tid := TransactId(uuid.New())
+	_ = table.Prepare(tid, []LogEntry{{Op: LPut, Key: 2, Value: 3}, {Op: LDelete, Key: 1}})
+	// Staged entries are not visible before commit
+	_, err := table.Get(2)
+	assert.EqualError(t, err, "key not found - 2")
+	assert.Nil(t, table.CommitPrepared(tid))
+	val, err := table.Get(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, val)
+	_, err = table.Get(1)
+	assert.EqualError(t, err, "key not found - 1")
+}
+
+func TestInMemoryTable_AbortPrepared_Should_Discard_Staged_Entries(t *testing.T) {
+	table, _ := NewInMemoryTable[int, int]("test")
+	tid := TransactId(uuid.New())
+	_ = table.Prepare(tid, []LogEntry{{Op: LPut, Key: 1, Value: 2}})
+	assert.Nil(t, table.AbortPrepared(tid))
+	_, err := table.Get(1)
+	assert.EqualError(t, err, "key not found - 1")
+	assert.EqualError(t, table.CommitPrepared(tid), "transaction not prepared")
+}
+
+func TestInMemoryTable_Scan(t *testing.T) {
+	cases := []struct {
+		name      string
+		from      interface{}
+		to        interface{}
+		limit     int
+		want      []int
+		errorWant string
+	}{
+		{name: "Whole table", from: nil, to: nil, limit: 0, want: []int{1, 2, 3, 4, 5}},
+		{name: "Closed range", from: 2, to: 4, limit: 0, want: []int{2, 3, 4}},
+		{name: "Open upper bound with limit", from: 3, to: nil, limit: 2, want: []int{3, 4}},
+		{name: "Empty range", from: 6, to: nil, limit: 0, want: []int{}},
+		{name: "Type Mismatch on Bound", from: "1", to: nil, limit: 0, errorWant: "type mismatch: expected key type - int, got - string"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			table, _ := NewInMemoryTable[int, int]("test")
+			for _, k := range []int{5, 3, 1, 4, 2} {
+				_ = table.Put(k, k*10)
+			}
+			rows, err := table.Scan(c.from, c.to, c.limit)
+			if c.errorWant != "" {
+				assert.EqualError(t, err, c.errorWant)
+				return
+			}
+			assert.Nil(t, err)
+			keys := make([]int, 0)
+			for _, row := range rows {
+				keys = append(keys, row.Key.(int))
+				assert.Equal(t, row.Key.(int)*10, row.Value)
+			}
+			assert.Equal(t, c.want, keys)
+		})
+	}
+}
+
+func TestInMemoryTable_Scan_Should_See_Writes_After_Index_Is_Built(t *testing.T) {
+	table, _ := NewInMemoryTable[int, int]("test")
+	_ = table.Put(1, 1)
+	_, _ = table.Scan(nil, nil, 0)
+	_ = table.Put(2, 2)
+	_ = table.Delete(1)
+	rows, _ := table.Scan(nil, nil, 0)
+	assert.Equal(t, []KeyValue{{Key: 2, Value: 2}}, rows)
+}
+
+func TestInMemoryTable_SetKeyOrder(t *testing.T) {
+	table, _ := NewInMemoryTable[int, int]("test")
+	for _, k := range []int{1, 2, 3} {
+		_ = table.Put(k, k)
+	}
+	table.SetKeyOrder(func(a, b int) int { return b - a })
+	rows, _ := table.Scan(nil, nil, 0)
+	assert.Equal(t, []KeyValue{{Key: 3, Value: 3}, {Key: 2, Value: 2}, {Key: 1, Value: 1}}, rows)
+}
@@ -0,0 +1,156 @@
+package asyncdb
+
+import (
+	"encoding/binary"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// defaultReleasedRetention is how long the released marker of a transaction is kept at least
+const defaultReleasedRetention = time.Minute
+
+// releasedMarks marks the transactions whose locks were released
+type releasedMarks interface {
+	add(tid TransactId)
+	contains(tid TransactId) bool
+	len() int
+}
+
+// releasedSet marks the transactions whose locks were released, so that their late lock requests are refused.
+// Markers are kept in two generations, and the older generation is dropped once the newer one is older than
+// the retention, so a marker lives between one and two retention periods
+type releasedSet struct {
+	current   map[TransactId]bool
+	previous  map[TransactId]bool
+	rotatedAt time.Time
+	retention time.Duration
+	m         *sync.RWMutex
+}
+
+func newReleasedSet(retention time.Duration) *releasedSet {
+	return &releasedSet{
+		current:   make(map[TransactId]bool),
+		previous:  make(map[TransactId]bool),
+		rotatedAt: time.Now(),
+		retention: retention,
+		m:         &sync.RWMutex{},
+	}
+}
+
+func (s *releasedSet) add(tid TransactId) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if now := time.Now(); now.Sub(s.rotatedAt) >= s.retention {
+		s.previous = s.current
+		s.current = make(map[TransactId]bool)
+		s.rotatedAt = now
+	}
+	s.current[tid] = true
+}
+
+func (s *releasedSet) contains(tid TransactId) bool {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.current[tid] || s.previous[tid]
+}
+
+func (s *releasedSet) len() int {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return len(s.current) + len(s.previous)
+}
+
+// stripedReleasedSet spreads the markers over released sets chosen by transaction id, so that transactions
+// of different stripes do not contend. The shards of ShardedLockManager share one
+type stripedReleasedSet []*releasedSet
+
+func newStripedReleasedSet(stripes int, retention time.Duration) stripedReleasedSet {
+	s := make(stripedReleasedSet, stripes)
+	for i := range s {
+		s[i] = newReleasedSet(retention)
+	}
+	return s
+}
+
+// xactStripe returns the stripe of the transaction out of n
+func xactStripe(tid TransactId, n int) int {
+	return int(binary.LittleEndian.Uint64(tid[:8]) % uint64(n))
+}
+
+func (s stripedReleasedSet) add(tid TransactId) {
+	s[xactStripe(tid, len(s))].add(tid)
+}
+
+func (s stripedReleasedSet) contains(tid TransactId) bool {
+	return s[xactStripe(tid, len(s))].contains(tid)
+}
+
+func (s stripedReleasedSet) len() int {
+	n := 0
+	for _, stripe := range s {
+		n += stripe.len()
+	}
+	return n
+}
+
+// WithReleasedRetention sets how long a transaction is remembered after its locks were released. Its lock
+// requests arriving later than that would take locks that are never released, so the retention must exceed
+// the time an operation can take to reach the lock manager. AsyncDB waits for the operations
+// of a transaction right after releasing its locks
+func WithReleasedRetention(retention time.Duration) func(*LockManagerImpl) {
+	return func(lm *LockManagerImpl) {
+		lm.releasedRetention = retention
+	}
+}
+
+// unused checks if the key has no holders and no waiters. Requires ol.m
+func (ol *ObjectLock) unused() bool {
+	return ol.WLock.tId == TransactId(uuid.Nil) && len(ol.RLock) == 0 && len(ol.Queue) == 0
+}
+
+// lockObject returns the lock object of the key with ol.m locked, creating it if needed
+func (lm *LockManagerImpl) lockObject(table *LockTable, key interface{}) *ObjectLock {
+	for {
+		table.Locks.Lock()
+		ol, ok := table.Locks.GetUnsafe(key)
+		if !ok {
+			ol = &ObjectLock{
+				WLock: &Transaction{TransactId(uuid.Nil), 0},
+				RLock: make([]*Transaction, 0),
+				Queue: make([]*LockWaiter, 0),
+				m:     &sync.Mutex{},
+			}
+			table.Locks.PutUnsafe(key, ol)
+		}
+		table.Locks.Unlock()
+		ol.m.Lock()
+		// The object may have been reclaimed after it was looked up
+		if !ol.deleted {
+			return ol
+		}
+		ol.m.Unlock()
+	}
+}
+
+// reclaimKey deletes the lock object of the key if it is unused
+func (lm *LockManagerImpl) reclaimKey(table *LockTable, key interface{}) {
+	table.Locks.Lock()
+	defer table.Locks.Unlock()
+	ol, ok := table.Locks.GetUnsafe(key)
+	if !ok {
+		return
+	}
+	ol.m.Lock()
+	reclaimIfUnused(table, key, ol)
+	ol.m.Unlock()
+}
+
+// reclaimIfUnused deletes the lock object of the key if it is unused. Requesters holding the object
+// see that it was deleted, and look the key up again. Requires table.Locks and ol.m
+func reclaimIfUnused(table *LockTable, key interface{}, ol *ObjectLock) {
+	if ol.unused() {
+		ol.deleted = true
+		table.Locks.DeleteUnsafe(key)
+	}
+}
@@ -1,11 +1,13 @@
 package asyncdb
 
 import (
+	"context"
 	"errors"
 	"github.com/google/uuid"
-	"math"
 	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -23,7 +25,42 @@ type TableId uint64
 
 type LockManager interface {
 	Lock(lockType int, tid TransactId, ts int64, tableId TableId, key interface{}) error
+	// LockContext is Lock that stops waiting for the lock when the context is done, and returns the context error
+	LockContext(ctx context.Context, lockType int, tid TransactId, ts int64, tableId TableId, key interface{}) error
+	// LockRange locks every key in the range, including the keys that do not exist yet
+	LockRange(lockType int, tid TransactId, ts int64, tableId TableId, keyRange KeyRange) error
+	// LockRangeContext is LockRange that stops waiting when the context is done, and returns the context error
+	LockRangeContext(ctx context.Context, lockType int, tid TransactId, ts int64, tableId TableId, keyRange KeyRange) error
 	ReleaseLocks(tid TransactId) error
+	// ReleaseReadLock releases the read lock of the transaction on the key before the transaction ends.
+	// A write lock of the transaction on the key is kept
+	ReleaseReadLock(tid TransactId, tableId TableId, key interface{}) error
+	// ReleaseReadRange releases a read lock taken by LockRange with the same bounds before the transaction ends
+	ReleaseReadRange(tid TransactId, tableId TableId, keyRange KeyRange) error
+	// Stats reports the current holders and waiters of keys, and the conflict and wait counters
+	Stats() LockStats
+}
+
+// KeyRange is an interval of keys with inclusive bounds. A nil bound leaves that side of the range open
+type KeyRange struct {
+	From    interface{}
+	To      interface{}
+	Compare func(a, b interface{}) int
+}
+
+func (r KeyRange) contains(key interface{}) bool {
+	return (r.From == nil || r.Compare(key, r.From) >= 0) && (r.To == nil || r.Compare(key, r.To) <= 0)
+}
+
+func (r KeyRange) overlaps(other KeyRange) bool {
+	// Two intervals do not overlap only if one of them ends before the other one starts
+	if r.To != nil && other.From != nil && r.Compare(r.To, other.From) < 0 {
+		return false
+	}
+	if other.To != nil && r.From != nil && r.Compare(other.To, r.From) < 0 {
+		return false
+	}
+	return true
 }
 
 type Transaction struct {
@@ -46,6 +83,8 @@ type ObjectLock struct {
 	RLock []*Transaction
 	Queue []*LockWaiter
 	m     *sync.Mutex
+	// deleted is set when the object is reclaimed, guarded by m
+	deleted bool
 }
 
 //type LockTable struct {
@@ -53,11 +92,33 @@ type ObjectLock struct {
 //	m     *sync.Mutex
 //}
 
+type RangeLock struct {
+	xact     *Transaction
+	LockType int
+	Range    KeyRange
+}
+
+// rangeWaiter is notified every time locks are released in the table, and then checks its conflicts again
+type rangeWaiter struct {
+	tId  TransactId
+	Chan chan error
+}
+
+// LockTable keeps the key locks of a table, and the range locks and table locks together with their waiters,
+// which are guarded by m
 type LockTable struct {
-	Locks *ThreadSafeMap[interface{}, *ObjectLock]
-	m     *sync.Mutex
+	Locks        *ThreadSafeMap[interface{}, *ObjectLock]
+	Ranges       []*RangeLock
+	TableLocks   map[TransactId]*TableLock
+	RangeWaiters []*rangeWaiter
+	m            *sync.Mutex
+	// coarse counts the range locks, held or requested, and the table locks granting every key. Key locks
+	// skip the range and table lock checks while it is zero
+	coarse atomic.Int64
 }
 
+// LockInfo is a lock held by a transaction. Range locks are recorded with a nil key,
+// as they are found through the table
 type LockInfo struct {
 	key      interface{}
 	lockType int
@@ -72,7 +133,17 @@ type LockInfo struct {
 type LockManagerImpl struct {
 	lockMap          *ThreadSafeMap[TableId, *LockTable]
 	transactMap      *ThreadSafeMap[TransactId, *ThreadSafeMap[TableId, []LockInfo]]
-	transactReleased *ThreadSafeMap[TransactId, bool]
+	transactReleased releasedMarks
+	// releasedRetention is how long transactReleased remembers a transaction
+	releasedRetention time.Duration
+	policy            DeadlockPolicy
+	// waits is maintained only by the policies that abort transactions other than the requester
+	waits   *waitsForGraph
+	onWound func(tid TransactId)
+	// timeout limits lock waits, zero means no limit
+	timeout             time.Duration
+	metrics             *lockMetrics
+	escalationThreshold int
 }
 
 //func NewLockManager() *LockManagerImpl {
@@ -84,142 +155,488 @@ type LockManagerImpl struct {
 //	return lm
 //}
 
-func NewLockManager() *LockManagerImpl {
+func NewLockManager(options ...func(*LockManagerImpl)) *LockManagerImpl {
 	lm := &LockManagerImpl{
-		lockMap:          NewThreadSafeMap[TableId, *LockTable](),
-		transactMap:      NewThreadSafeMap[TransactId, *ThreadSafeMap[TableId, []LockInfo]](),
-		transactReleased: NewThreadSafeMap[TransactId, bool](),
+		lockMap:             NewThreadSafeMap[TableId, *LockTable](),
+		transactMap:         NewThreadSafeMap[TransactId, *ThreadSafeMap[TableId, []LockInfo]](),
+		releasedRetention:   defaultReleasedRetention,
+		policy:              WaitDie,
+		waits:               newWaitsForGraph(),
+		metrics:             newLockMetrics(),
+		escalationThreshold: defaultEscalationThreshold,
+	}
+	for _, option := range options {
+		option(lm)
 	}
+	lm.transactReleased = newReleasedSet(lm.releasedRetention)
 	return lm
 }
 
-func (lm *LockManagerImpl) addLockInfoIfNotExists(tid TransactId, tableId TableId, info LockInfo) {
-	lm.transactMap.Lock()
-	if _, ok := lm.transactMap.GetUnsafe(tid); !ok {
-		lm.transactMap.PutUnsafe(tid, NewThreadSafeMap[TableId, []LockInfo]())
+// addLockInfoIfNotExists records the locks in the locks of the transaction, unless its locks were released.
+// Without locks, only the table is recorded, so that ReleaseLocks visits it.
+// ReleaseLocks marks the transaction before taking its locks, so a lock recorded here is always released
+func (lm *LockManagerImpl) addLockInfoIfNotExists(tid TransactId, tableId TableId, infos ...LockInfo) bool {
+	// The transaction usually has locks already, and ReleaseLocks is excluded by the read lock as well
+	lm.transactMap.lock.RLock()
+	transactLocks, ok := lm.transactMap.GetUnsafe(tid)
+	if ok {
+		defer lm.transactMap.lock.RUnlock()
+	} else {
+		lm.transactMap.lock.RUnlock()
+		lm.transactMap.Lock()
+		defer lm.transactMap.Unlock()
+		if transactLocks, ok = lm.transactMap.GetUnsafe(tid); !ok {
+			transactLocks = NewThreadSafeMap[TableId, []LockInfo]()
+		}
+	}
+	if lm.transactReleased.contains(tid) {
+		return false
+	}
+	if !ok {
+		lm.transactMap.PutUnsafe(tid, transactLocks)
 	}
-	defer lm.transactMap.Unlock()
 	//if !slices.Contains(lm.transactMap.m[tid][tableId], info) {
 	//	lm.transactMap[tid][tableId] = append(lm.transactMap[tid][tableId], info)
 	//}
-	lm.transactMap.m[tid].Lock()
-	infos, _ := lm.transactMap.m[tid].GetUnsafe(tableId)
-	if !slices.Contains(infos, info) {
-		infos = append(infos, info)
-		lm.transactMap.m[tid].PutUnsafe(tableId, infos)
+	transactLocks.Lock()
+	held, ok := transactLocks.GetUnsafe(tableId)
+	if !ok {
+		held = make([]LockInfo, 0, len(infos))
+	}
+	for _, info := range infos {
+		if !slices.Contains(held, info) {
+			held = append(held, info)
+		}
 	}
-	lm.transactMap.m[tid].Unlock()
+	transactLocks.PutUnsafe(tableId, held)
+	transactLocks.Unlock()
+	return true
+}
+
+// holdsIntention checks if the transaction holds an intention lock on the table for the lock type
+func (lm *LockManagerImpl) holdsIntention(tid TransactId, tableId TableId, lockType int) bool {
+	transactLocks, ok := lm.transactMap.Get(tid)
+	if !ok {
+		return false
+	}
+	infos, _ := transactLocks.Get(tableId)
+	return slices.Contains(infos, LockInfo{key: nil, lockType: WriteLock}) ||
+		(lockType == ReadLock && slices.Contains(infos, LockInfo{key: nil, lockType: ReadLock}))
 }
 
 func (lm *LockManagerImpl) addTableIfNotExists(tableId TableId) {
+	if _, ok := lm.lockMap.Get(tableId); ok {
+		return
+	}
 	lm.lockMap.Lock()
 	defer lm.lockMap.Unlock()
 	if _, ok := lm.lockMap.GetUnsafe(tableId); ok {
 		return
 	}
 	lm.lockMap.PutUnsafe(tableId, &LockTable{
-		Locks: NewThreadSafeMap[interface{}, *ObjectLock](),
-		m:     &sync.Mutex{},
+		Locks:        NewThreadSafeMap[interface{}, *ObjectLock](),
+		Ranges:       make([]*RangeLock, 0),
+		TableLocks:   make(map[TransactId]*TableLock),
+		RangeWaiters: make([]*rangeWaiter, 0),
+		m:            &sync.Mutex{},
 	})
 }
 
-func (lm *LockManagerImpl) addTableKeyIfNotExists(tableId TableId, key interface{}) {
-	// We assume that the table already exists
+func (lm *LockManagerImpl) Lock(lockType int, tid TransactId, ts int64, tableId TableId, key interface{}) error {
+	return lm.LockContext(context.Background(), lockType, tid, ts, tableId, key)
+}
+
+func (lm *LockManagerImpl) LockContext(ctx context.Context, lockType int, tid TransactId, ts int64, tableId TableId, key interface{}) error {
+	if lockType != ReadLock && lockType != WriteLock {
+		return ErrInvalidLockType
+	}
+	ctx, cancel := lm.waitContext(ctx)
+	defer cancel()
+	xact := &Transaction{tId: tid, ts: ts}
+	lm.addTableIfNotExists(tableId)
 	table, _ := lm.lockMap.Get(tableId)
-	locks := table.Locks
-	locks.Lock()
-	defer locks.Unlock()
-	if _, ok := locks.GetUnsafe(key); ok {
-		return
+	// With its intention held and no range or table locks around, only the key has to be locked
+	if table.coarse.Load() == 0 && lm.holdsIntention(tid, tableId, lockType) {
+		if err := lm.lockKey(ctx, lockType, tid, ts, tableId, key); err != nil {
+			return err
+		}
+		// Range and table locks are counted before they check the key locks, so they either see
+		// the key lock or are seen here, and then they are checked below
+		if table.coarse.Load() == 0 {
+			lm.escalateIfNeeded(xact, tableId)
+			return nil
+		}
 	}
-	locks.PutUnsafe(key, &ObjectLock{
-		WLock: &Transaction{TransactId(uuid.Nil), 0},
-		RLock: make([]*Transaction, 0),
-		Queue: make([]*LockWaiter, 0),
-		m:     &sync.Mutex{},
+	if covered, err := lm.lockIntention(ctx, xact, tableId, lockType); err != nil || covered {
+		return err
+	}
+	if err := lm.lockKey(ctx, lockType, tid, ts, tableId, key); err != nil {
+		return err
+	}
+	// The key lock is registered before checking range locks, so a concurrent range lock
+	// either sees the key lock or is seen by this check
+	err := lm.waitForRanges(ctx, table, xact, func() []*Transaction {
+		return lm.conflictingRanges(table, tid, lockType, func(r KeyRange) bool { return r.contains(key) })
 	})
+	if err != nil {
+		return err
+	}
+	mode := table.tableMode(tid)
+	table.m.Unlock()
+	// Another operation of the transaction may have escalated its locks in the meantime
+	if coversLock(mode, lockType) {
+		lm.wound(lm.releaseKey(table, tid, key, mode == Exclusive))
+		return nil
+	}
+	lm.escalateIfNeeded(xact, tableId)
+	return nil
 }
 
-func (lm *LockManagerImpl) Lock(lockType int, tid TransactId, ts int64, tableId TableId, key interface{}) error {
-	// Todo: Check if the transaction is already holding the lock
-	lm.addTableIfNotExists(tableId)
-	lm.addTableKeyIfNotExists(tableId, key)
-	lm.addLockInfoIfNotExists(tid, tableId, LockInfo{key: key, lockType: lockType})
-	if _, ok := lm.transactReleased.Get(tid); ok {
-		return ErrLocksReleased
+func (lm *LockManagerImpl) LockRange(lockType int, tid TransactId, ts int64, tableId TableId, keyRange KeyRange) error {
+	return lm.LockRangeContext(context.Background(), lockType, tid, ts, tableId, keyRange)
+}
+
+func (lm *LockManagerImpl) LockRangeContext(ctx context.Context, lockType int, tid TransactId, ts int64, tableId TableId, keyRange KeyRange) error {
+	if lockType != ReadLock && lockType != WriteLock {
+		return ErrInvalidLockType
 	}
+	ctx, cancel := lm.waitContext(ctx)
+	defer cancel()
 	xact := &Transaction{tId: tid, ts: ts}
+	lm.addTableIfNotExists(tableId)
 	table, _ := lm.lockMap.Get(tableId)
-	//ol := lm.lockMap[tableId].Locks[key]
-	ol, _ := table.Locks.Get(key)
-	ol.m.Lock()
-	wl := ol.WLock
-	rl := ol.RLock
-	readTids := make(map[TransactId]bool)
-	youngerReadExists := false
-	for _, r := range rl {
-		readTids[r.tId] = true
-		youngerReadExists = youngerReadExists || xact.isOlderThan(r)
-	}
-	res := make(chan error)
-	if lockType == ReadLock {
-		if _, ok := readTids[tid]; ok {
-			ol.m.Unlock()
-			return nil
+	// The range is counted until it is released, or until the request fails
+	table.coarse.Add(1)
+	granted := false
+	defer func() {
+		if !granted {
+			table.coarse.Add(-1)
 		}
-		if wl.tId == TransactId(uuid.Nil) {
-			ol.RLock = append(rl, xact)
-			ol.m.Unlock()
+	}()
+	if covered, err := lm.lockIntention(ctx, xact, tableId, lockType); err != nil || covered {
+		return err
+	}
+	if !lm.addLockInfoIfNotExists(tid, tableId, LockInfo{key: nil, lockType: lockType}) {
+		return ErrLocksReleased
+	}
+	if lm.isWounded(tid) {
+		return ErrLockConflict
+	}
+	err := lm.waitForRanges(ctx, table, xact, func() []*Transaction {
+		conflicts := lm.conflictingRanges(table, tid, lockType, func(r KeyRange) bool { return r.overlaps(keyRange) })
+		return append(conflicts, lm.conflictingKeys(table, tid, lockType, keyRange)...)
+	})
+	if err != nil {
+		return err
+	}
+	// ReleaseLocks may have already cleared the table
+	if lm.transactReleased.contains(tid) {
+		table.m.Unlock()
+		return ErrLocksReleased
+	}
+	table.Ranges = append(table.Ranges, &RangeLock{xact: xact, LockType: lockType, Range: keyRange})
+	granted = true
+	table.m.Unlock()
+	return nil
+}
+
+// waitForRanges applies the deadlock policy to the holders returned by conflicts until there are none.
+// When it returns nil, table.m is locked, so that the caller can register its own lock atomically with the check
+func (lm *LockManagerImpl) waitForRanges(ctx context.Context, table *LockTable, xact *Transaction, conflicts func() []*Transaction) error {
+	// Waiters check their conflicts every time they are woken up, but it is counted as a single wait
+	var endWait func()
+	defer func() {
+		if endWait != nil {
+			endWait()
+		}
+	}()
+	for {
+		table.m.Lock()
+		holders := conflicts()
+		if len(holders) == 0 {
 			return nil
 		}
-		if xact.isOlderThan(wl) {
-			ol.Queue = append(ol.Queue, &LockWaiter{
-				xact:     xact,
-				LockType: ReadLock,
-				Chan:     res,
-			})
-			ol.m.Unlock()
-			return <-res
+		if endWait == nil {
+			lm.metrics.conflicts.Add(1)
+		}
+		waiter := &rangeWaiter{tId: xact.tId, Chan: make(chan error, 1)}
+		cancel := func() { lm.cancelRangeWaiter(table, waiter) }
+		victims, err := lm.resolveConflict(xact, waiter.Chan, cancel, holders)
+		if err != nil {
+			table.m.Unlock()
+			return err
+		}
+		table.RangeWaiters = append(table.RangeWaiters, waiter)
+		table.m.Unlock()
+		if endWait == nil {
+			endWait = lm.metrics.startWait()
+		}
+		lm.wound(victims)
+		err = lm.await(ctx, waiter.Chan, cancel)
+		lm.stopWaiting(xact.tId, waiter.Chan)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (lm *LockManagerImpl) cancelRangeWaiter(table *LockTable, waiter *rangeWaiter) {
+	table.m.Lock()
+	defer table.m.Unlock()
+	if i := slices.Index(table.RangeWaiters, waiter); i >= 0 {
+		table.RangeWaiters = slices.Delete(table.RangeWaiters, i, i+1)
+		waiter.Chan <- ErrLockConflict
+	}
+}
+
+func lockTypesConflict(a int, b int) bool {
+	return a == WriteLock || b == WriteLock
+}
+
+// conflictingRanges returns holders of range locks matching the filter that conflict with the lock type.
+// Requires table.m
+func (lm *LockManagerImpl) conflictingRanges(table *LockTable, tid TransactId, lockType int, filter func(r KeyRange) bool) []*Transaction {
+	holders := make([]*Transaction, 0)
+	for _, r := range table.Ranges {
+		if r.xact.tId == tid || !lockTypesConflict(r.LockType, lockType) || !filter(r.Range) {
+			continue
+		}
+		if lm.transactReleased.contains(r.xact.tId) {
+			continue
+		}
+		holders = append(holders, r.xact)
+	}
+	return holders
+}
+
+// conflictingKeys returns holders of key locks inside the range that conflict with the lock type. Requires table.m
+func (lm *LockManagerImpl) conflictingKeys(table *LockTable, tid TransactId, lockType int, keyRange KeyRange) []*Transaction {
+	holders := make([]*Transaction, 0)
+	isHeld := func(xact *Transaction) bool {
+		if xact.tId == tid || xact.tId == TransactId(uuid.Nil) {
+			return false
+		}
+		return !lm.transactReleased.contains(xact.tId)
+	}
+	table.Locks.Lock()
+	defer table.Locks.Unlock()
+	for key, ol := range table.Locks.m {
+		if !keyRange.contains(key) {
+			continue
+		}
+		ol.m.Lock()
+		if isHeld(ol.WLock) {
+			holders = append(holders, ol.WLock)
+		}
+		if lockType == WriteLock {
+			for _, r := range ol.RLock {
+				if isHeld(r) {
+					holders = append(holders, r)
+				}
+			}
 		}
 		ol.m.Unlock()
+	}
+	return holders
+}
+
+// wakeRangeWaiters makes range waiters check their conflicts again after locks of tid were released.
+// Waiters of tid itself are stopped
+func (lm *LockManagerImpl) wakeRangeWaiters(table *LockTable, tid TransactId) {
+	table.m.Lock()
+	defer table.m.Unlock()
+	ranges := len(table.Ranges)
+	table.Ranges = slices.DeleteFunc(table.Ranges, func(r *RangeLock) bool { return r.xact.tId == tid })
+	table.coarse.Add(int64(len(table.Ranges) - ranges))
+	if coversLock(table.tableMode(tid), ReadLock) {
+		table.coarse.Add(-1)
+	}
+	delete(table.TableLocks, tid)
+	lm.notifyRangeWaiters(table, tid)
+}
+
+// notifyRangeWaiters wakes up every range waiter of the table, waiters of tid get ErrLocksReleased. Requires table.m
+func (lm *LockManagerImpl) notifyRangeWaiters(table *LockTable, tid TransactId) {
+	for _, waiter := range table.RangeWaiters {
+		if waiter.tId == tid {
+			waiter.Chan <- ErrLocksReleased
+		} else {
+			waiter.Chan <- nil
+		}
+	}
+	if len(table.RangeWaiters) > 0 {
+		table.RangeWaiters = make([]*rangeWaiter, 0)
+	}
+}
+
+func (lm *LockManagerImpl) lockKey(ctx context.Context, lockType int, tid TransactId, ts int64, tableId TableId, key interface{}) error {
+	if lockType != ReadLock && lockType != WriteLock {
+		return ErrInvalidLockType
+	}
+	lm.addTableIfNotExists(tableId)
+	if !lm.addLockInfoIfNotExists(tid, tableId, LockInfo{key: key, lockType: lockType}) {
+		return ErrLocksReleased
+	}
+	if lm.isWounded(tid) {
 		return ErrLockConflict
-	} else if lockType == WriteLock {
-		if _, ok := lm.transactReleased.Get(wl.tId); ok || wl.tId == TransactId(uuid.Nil) {
-			if len(rl) == 0 || (len(rl) == 1 && rl[0].tId == tid) {
-				ol.WLock = xact
-				ol.m.Unlock()
-				return nil
-			}
-			if youngerReadExists {
-				ol.Queue = append(ol.Queue, &LockWaiter{
-					xact:     xact,
-					LockType: WriteLock,
-					Chan:     res,
-				})
-				ol.m.Unlock()
-				return <-res
+	}
+	xact := &Transaction{tId: tid, ts: ts}
+	table, _ := lm.lockMap.Get(tableId)
+	ol := lm.lockObject(table, key)
+	// ReleaseLocks may have already released the key
+	if lm.transactReleased.contains(tid) {
+		ol.m.Unlock()
+		lm.reclaimKey(table, key)
+		return ErrLocksReleased
+	}
+	if lm.holdsLock(ol, tid, lockType) {
+		ol.m.Unlock()
+		return nil
+	}
+	// Waiters are checked as well, so that a stream of readers can not starve a writer waiting in the queue
+	conflicts := append(lm.conflictingHolders(ol, tid, lockType), conflictingWaiters(ol.Queue, tid, lockType)...)
+	if len(conflicts) == 0 {
+		lm.grantLock(ol, xact, lockType)
+		ol.m.Unlock()
+		return nil
+	}
+	lm.metrics.conflicts.Add(1)
+	waiter := &LockWaiter{
+		xact:     xact,
+		LockType: lockType,
+		Chan:     make(chan error, 1),
+	}
+	cancel := func() { lm.cancelWaiter(ol, waiter) }
+	victims, err := lm.resolveConflict(xact, waiter.Chan, cancel, conflicts)
+	if err != nil {
+		ol.m.Unlock()
+		return err
+	}
+	ol.Queue = append(ol.Queue, waiter)
+	ol.m.Unlock()
+	endWait := lm.metrics.startWait()
+	lm.wound(victims)
+	err = lm.await(ctx, waiter.Chan, cancel)
+	endWait()
+	lm.stopWaiting(tid, waiter.Chan)
+	if err != nil {
+		lm.reclaimKey(table, key)
+	}
+	return err
+}
+
+// cancelWaiter fails the wait of a wounded transaction, which may let the waiters behind it take the lock
+func (lm *LockManagerImpl) cancelWaiter(ol *ObjectLock, waiter *LockWaiter) {
+	ol.m.Lock()
+	i := slices.Index(ol.Queue, waiter)
+	if i < 0 {
+		ol.m.Unlock()
+		return
+	}
+	ol.Queue = slices.Delete(ol.Queue, i, i+1)
+	waiter.Chan <- ErrLockConflict
+	victims := lm.processQueue(ol, TransactId(uuid.Nil))
+	ol.m.Unlock()
+	lm.wound(victims)
+}
+
+// holdsLock checks if the transaction already holds a lock at least as strong as the lock type. Requires ol.m
+func (lm *LockManagerImpl) holdsLock(ol *ObjectLock, tid TransactId, lockType int) bool {
+	if ol.WLock.tId == tid {
+		return true
+	}
+	return lockType == ReadLock && slices.ContainsFunc(ol.RLock, func(r *Transaction) bool { return r.tId == tid })
+}
+
+// conflictingHolders returns the other transactions holding the key in a mode that conflicts with the lock type.
+// A transaction upgrading its read lock conflicts only with the other readers. Requires ol.m
+func (lm *LockManagerImpl) conflictingHolders(ol *ObjectLock, tid TransactId, lockType int) []*Transaction {
+	holders := make([]*Transaction, 0)
+	isHeld := func(xact *Transaction) bool {
+		if xact.tId == tid || xact.tId == TransactId(uuid.Nil) {
+			return false
+		}
+		return !lm.transactReleased.contains(xact.tId)
+	}
+	if isHeld(ol.WLock) {
+		holders = append(holders, ol.WLock)
+	}
+	if lockType == WriteLock {
+		for _, r := range ol.RLock {
+			if isHeld(r) {
+				holders = append(holders, r)
 			}
-			ol.m.Unlock()
-			return ErrLockConflict
 		}
-		if wl.tId == tid {
-			ol.m.Unlock()
-			return nil
+	}
+	return holders
+}
+
+// conflictingWaiters returns the transactions in the queue waiting for a lock that conflicts with the lock type
+func conflictingWaiters(queue []*LockWaiter, tid TransactId, lockType int) []*Transaction {
+	waiters := make([]*Transaction, 0)
+	for _, waiter := range queue {
+		if waiter.xact.tId != tid && lockTypesConflict(waiter.LockType, lockType) {
+			waiters = append(waiters, waiter.xact)
 		}
-		if xact.isOlderThan(wl) {
-			ol.Queue = append(ol.Queue, &LockWaiter{
-				xact:     xact,
-				LockType: WriteLock,
-				Chan:     res,
-			})
-			ol.m.Unlock()
-			return <-res
+	}
+	return waiters
+}
+
+// grantLock gives the lock to the transaction. A write lock replaces the read lock of the same transaction. Requires ol.m
+func (lm *LockManagerImpl) grantLock(ol *ObjectLock, xact *Transaction, lockType int) {
+	if lm.holdsLock(ol, xact.tId, lockType) {
+		return
+	}
+	if lockType == ReadLock {
+		ol.RLock = append(ol.RLock, xact)
+		return
+	}
+	ol.RLock = slices.DeleteFunc(ol.RLock, func(r *Transaction) bool { return r.tId == xact.tId })
+	ol.WLock = xact
+}
+
+// isOlderThanAll is the wait-die rule: a transaction may wait only for younger transactions
+func isOlderThanAll(xact *Transaction, others []*Transaction) bool {
+	for _, other := range others {
+		if !xact.isOlderThan(other) {
+			return false
 		}
-		ol.m.Unlock()
-		return ErrLockConflict
-	} else {
-		return ErrInvalidLockType
 	}
+	return true
+}
+
+// processQueue goes through the waiters in arrival order after tid released the key. A waiter is granted the lock
+// if it conflicts neither with the holders nor with the waiters before it, otherwise the deadlock policy
+// is applied to its new conflicts. Returns the transactions to wound. Requires ol.m
+func (lm *LockManagerImpl) processQueue(ol *ObjectLock, tid TransactId) []TransactId {
+	if len(ol.Queue) == 0 {
+		return nil
+	}
+	queue := make([]*LockWaiter, 0, len(ol.Queue))
+	victims := make([]TransactId, 0)
+	for _, waiter := range ol.Queue {
+		if waiter.xact.tId == tid {
+			waiter.Chan <- ErrLocksReleased
+			continue
+		}
+		conflicts := append(lm.conflictingHolders(ol, waiter.xact.tId, waiter.LockType),
+			conflictingWaiters(queue, waiter.xact.tId, waiter.LockType)...)
+		if len(conflicts) == 0 {
+			lm.grantLock(ol, waiter.xact, waiter.LockType)
+			lm.stopWaiting(waiter.xact.tId, waiter.Chan)
+			waiter.Chan <- nil
+			continue
+		}
+		wounded, err := lm.resolveConflict(waiter.xact, waiter.Chan, func() { lm.cancelWaiter(ol, waiter) }, conflicts)
+		if err != nil {
+			waiter.Chan <- err
+			continue
+		}
+		victims = append(victims, wounded...)
+		queue = append(queue, waiter)
+	}
+	ol.Queue = queue
+	return victims
 }
 
 func (lm *LockManagerImpl) ReleaseLocks(tid TransactId) error {
@@ -230,15 +647,35 @@ func (lm *LockManagerImpl) ReleaseLocks(tid TransactId) error {
 	//transactLocks := lm.transactMap[tid]
 	//delete(lm.transactMap, tid)
 	//lm.m.Unlock()
-	lm.transactReleased.Put(tid, true)
+	lm.markReleased(tid)
+	lm.releaseHeld(tid)
+	return nil
+}
+
+// markReleased refuses further lock requests of the transaction, and forgets its waits
+func (lm *LockManagerImpl) markReleased(tid TransactId) {
+	lm.transactReleased.add(tid)
+	lm.forgetWaits(tid)
+}
+
+// forgetWaits removes the transaction from the waits-for graph
+func (lm *LockManagerImpl) forgetWaits(tid TransactId) {
+	if lm.policy == WoundWait || lm.policy == DeadlockDetection {
+		lm.waits.remove(tid)
+	}
+}
+
+// releaseHeld releases the locks held by a transaction marked as released
+func (lm *LockManagerImpl) releaseHeld(tid TransactId) {
 	lm.transactMap.Lock()
 	transactLocks, ok := lm.transactMap.GetUnsafe(tid)
 	lm.transactMap.DeleteUnsafe(tid)
 	lm.transactMap.Unlock()
 	if !ok {
-		return nil
+		return
 	}
 	transactLocks.Lock()
+	victims := make([]TransactId, 0)
 	for tableId, locks := range transactLocks.m {
 		table, ok := lm.lockMap.Get(tableId)
 		if !ok {
@@ -257,66 +694,54 @@ func (lm *LockManagerImpl) ReleaseLocks(tid TransactId) error {
 					0,
 				}
 			}
-			// Todo: Not the right way to delete from a slice
-			for i, r := range ol.RLock {
-				if r.tId == tid {
-					// Optimized way to remove element from slice
-					ol.RLock[i] = ol.RLock[len(ol.RLock)-1]
-					ol.RLock = ol.RLock[:len(ol.RLock)-1]
-				}
-			}
-			newQueue := make([]*LockWaiter, 0)
-			for i, waiter := range ol.Queue {
-				// TODO: Here I check both if the waiter tid has been released, or if waiter tid is the released tid
-				if _, ok := lm.transactReleased.Get(tid); ok || waiter.xact.tId == tid {
-					waiter.Chan <- ErrLocksReleased
-				} else {
-					newQueue = append(newQueue, ol.Queue[i])
-				}
-			}
-			ol.Queue = newQueue
-
-			// Check the queue for the lock
-			if len(ol.Queue) > 0 {
-				waiter := ol.Queue[0]
-				if waiter.LockType == ReadLock {
-					wlock := ol.WLock
-					if wlock.tId == TransactId(uuid.Nil) {
-						ol.RLock = append(ol.RLock, waiter.xact)
-						ol.Queue = ol.Queue[1:]
-						waiter.Chan <- nil
-					} else if wlock.isOlderThan(waiter.xact) {
-						ol.Queue = ol.Queue[1:]
-						waiter.Chan <- ErrLockConflict
-					}
-				} else {
-					wlock := ol.WLock
-					rlock := ol.RLock
-					var minReadTs int64 = math.MaxInt64
-					for _, r := range rlock {
-						minReadTs = min(minReadTs, r.ts)
-					}
-					if _, ok := lm.transactReleased.Get(wlock.tId); ok || wlock.tId == TransactId(uuid.Nil) {
-						if len(rlock) == 0 || (len(rlock) == 1 && rlock[0].tId == waiter.xact.tId) {
-							ol.WLock = waiter.xact
-							ol.Queue = ol.Queue[1:]
-							waiter.Chan <- nil
-						} else if waiter.xact.ts < minReadTs {
-							ol.Queue = ol.Queue[1:]
-							waiter.Chan <- ErrLockConflict
-						}
-					} else {
-						if wlock.isOlderThan(waiter.xact) {
-							ol.Queue = ol.Queue[1:]
-							waiter.Chan <- ErrLockConflict
-						}
-					}
-				}
-			}
+			ol.RLock = slices.DeleteFunc(ol.RLock, func(r *Transaction) bool { return r.tId == tid })
+			victims = append(victims, lm.processQueue(ol, tid)...)
+			reclaimIfUnused(table, lock.key, ol)
 			ol.m.Unlock()
 		}
 		table.Locks.Unlock()
+		lm.wakeRangeWaiters(table, tid)
 	}
 	transactLocks.Unlock()
+	lm.wound(victims)
+}
+
+func (lm *LockManagerImpl) ReleaseReadLock(tid TransactId, tableId TableId, key interface{}) error {
+	table, ok := lm.lockMap.Get(tableId)
+	if !ok {
+		return nil
+	}
+	ol, ok := table.Locks.Get(key)
+	if !ok {
+		return nil
+	}
+	ol.m.Lock()
+	ol.RLock = slices.DeleteFunc(ol.RLock, func(r *Transaction) bool { return r.tId == tid })
+	victims := lm.processQueue(ol, TransactId(uuid.Nil))
+	ol.m.Unlock()
+	lm.reclaimKey(table, key)
+	table.m.Lock()
+	lm.notifyRangeWaiters(table, TransactId(uuid.Nil))
+	table.m.Unlock()
+	lm.wound(victims)
+	return nil
+}
+
+func (lm *LockManagerImpl) ReleaseReadRange(tid TransactId, tableId TableId, keyRange KeyRange) error {
+	table, ok := lm.lockMap.Get(tableId)
+	if !ok {
+		return nil
+	}
+	table.m.Lock()
+	defer table.m.Unlock()
+	i := slices.IndexFunc(table.Ranges, func(r *RangeLock) bool {
+		return r.xact.tId == tid && r.LockType == ReadLock && r.Range.From == keyRange.From && r.Range.To == keyRange.To
+	})
+	if i < 0 {
+		return nil
+	}
+	table.Ranges = slices.Delete(table.Ranges, i, i+1)
+	table.coarse.Add(-1)
+	lm.notifyRangeWaiters(table, TransactId(uuid.Nil))
 	return nil
 }
@@ -1,9 +1,15 @@
 package asyncdb
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -112,46 +118,702 @@ func TestLockManagerImpl_Data_Consistency(t *testing.T) {
 	assert.Equal(t, iterCount*routineCount-aborts, counter)
 }
 
-// This test is meant to test upgradable locks, and will be enabled once I get around to implementing that
-
-//func TestLockManagerImpl_Data_Consistency_With_Upgradable_Lock(t *testing.T) {
-//	// GIVEN routineCount concurrent goroutines that execute iterCount transactions
-//	// that GET a counter, increment a counter, then PUT it back
-//	// WHEN each routineCount completes
-//	// THEN the counter should be equal to routineCount * iterCount
-//	routineCount := 8
-//	iterCount := 1000
-//	lm := NewLockManager()
-//	counter := 0
-//	wg := sync.WaitGroup{}
-//	wg.Add(routineCount)
-//	xact := func(tid TransactId, ts int64) error {
-//		if err := lm.Lock(ReadLock, tid, ts, TableId(1), 1); err != nil {
-//			return err
-//		}
-//		val := counter
-//		if err := lm.Lock(WriteLock, tid, ts, TableId(1), 1); err != nil {
-//			return err
-//		}
-//		counter = val + 1
-//		return lm.ReleaseLocks(tid)
-//	}
-//	f := func() {
-//		defer wg.Done()
-//		for i := range iterCount {
-//			tid := TransactId(uuid.New())
-//			err := xact(tid, int64(i))
-//			for err != nil {
-//				lm.ReleaseLocks(tid)
-//				err = xact(tid, int64(i))
-//			}
-//		}
-//	}
-//	for i := 0; i < routineCount; i++ {
-//		go f()
-//	}
-//	assert.Eventually(t, func() bool {
-//		wg.Wait()
-//		return assert.Equal(t, iterCount*routineCount, counter)
-//	}, 1*time.Second, 10*time.Millisecond, "Deadlock/Live-lock detected")
-//}
+func intRange(from interface{}, to interface{}) KeyRange {
+	return KeyRange{From: from, To: to, Compare: CompareKeys}
+}
+
+func TestLockManagerImpl_LockRange_Should_Prevent_Insert_Into_Range(t *testing.T) {
+	lm := NewLockManager()
+	_ = lm.LockRange(ReadLock, TransactId(uuid.New()), 1, TableId(1), intRange(10, 20))
+	// A younger transaction inserting a key that does not exist yet dies
+	err := lm.Lock(WriteLock, TransactId(uuid.New()), 2, TableId(1), 15)
+	assert.EqualError(t, err, lockConflictErr)
+	// Keys outside the range are not affected
+	err = lm.Lock(WriteLock, TransactId(uuid.New()), 2, TableId(1), 21)
+	assert.Nil(t, err)
+}
+
+func TestLockManagerImpl_LockRange_Open_Bounds_Should_Cover_All_Keys(t *testing.T) {
+	lm := NewLockManager()
+	_ = lm.LockRange(WriteLock, TransactId(uuid.New()), 1, TableId(1), intRange(nil, nil))
+	err := lm.Lock(ReadLock, TransactId(uuid.New()), 2, TableId(1), -100)
+	assert.EqualError(t, err, lockConflictErr)
+}
+
+func TestLockManagerImpl_LockRange_Should_Conflict_With_Key_Lock_In_Range(t *testing.T) {
+	lm := NewLockManager()
+	_ = lm.Lock(WriteLock, TransactId(uuid.New()), 1, TableId(1), 15)
+	err := lm.LockRange(ReadLock, TransactId(uuid.New()), 2, TableId(1), intRange(10, 20))
+	assert.EqualError(t, err, lockConflictErr)
+	err = lm.LockRange(ReadLock, TransactId(uuid.New()), 2, TableId(1), intRange(16, 20))
+	assert.Nil(t, err)
+}
+
+func TestLockManagerImpl_LockRange_Should_Conflict_With_Key_Locked_Under_Held_Intention(t *testing.T) {
+	lm := NewLockManager()
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	// The first lock takes the intention, the second one only locks the key
+	assert.Nil(t, lm.Lock(WriteLock, older, 1, TableId(1), 1))
+	assert.Nil(t, lm.Lock(WriteLock, older, 1, TableId(1), 15))
+	assert.EqualError(t, lm.LockRange(ReadLock, younger, 2, TableId(1), intRange(10, 20)), lockConflictErr)
+	assert.Nil(t, lm.LockRange(ReadLock, younger, 2, TableId(1), intRange(16, 20)))
+	table, _ := lm.lockMap.Get(TableId(1))
+	assert.Equal(t, int64(1), table.coarse.Load())
+	// While the range is held, key locks under a held intention check it again
+	youngest := TransactId(uuid.New())
+	assert.Nil(t, lm.Lock(WriteLock, youngest, 3, TableId(1), 30))
+	assert.EqualError(t, lm.Lock(WriteLock, youngest, 3, TableId(1), 17), lockConflictErr)
+	_ = lm.ReleaseLocks(younger)
+	assert.Zero(t, table.coarse.Load())
+	assert.Nil(t, lm.Lock(WriteLock, youngest, 3, TableId(1), 17))
+}
+
+func TestLockManagerImpl_LockRange_Shared_Ranges_Should_Not_Conflict(t *testing.T) {
+	lm := NewLockManager()
+	_ = lm.LockRange(ReadLock, TransactId(uuid.New()), 1, TableId(1), intRange(10, 20))
+	err := lm.LockRange(ReadLock, TransactId(uuid.New()), 2, TableId(1), intRange(15, 25))
+	assert.Nil(t, err)
+	err = lm.LockRange(WriteLock, TransactId(uuid.New()), 3, TableId(1), intRange(20, 30))
+	assert.EqualError(t, err, lockConflictErr)
+}
+
+func TestLockManagerImpl_LockRange_Older_Inserter_Should_Wait_For_Release(t *testing.T) {
+	lm := NewLockManager()
+	reader := TransactId(uuid.New())
+	_ = lm.LockRange(ReadLock, reader, 2, TableId(1), intRange(10, 20))
+	waiterErr := make(chan error, 1)
+	go func() {
+		waiterErr <- lm.Lock(WriteLock, TransactId(uuid.New()), 1, TableId(1), 15)
+	}()
+	awaitWaits(lm, 1)
+	select {
+	case <-waiterErr:
+		t.Fatal("insert into a locked range should wait")
+	default:
+	}
+	_ = lm.ReleaseLocks(reader)
+	assert.Nil(t, <-waiterErr)
+}
+
+func TestLockManagerImpl_Phantom_Prevention(t *testing.T) {
+	// GIVEN routineCount goroutines that each insert a key into a range only if the range is empty
+	// WHEN all of them complete
+	// THEN exactly one key should be inserted
+	routineCount := 8
+	lm := NewLockManager()
+	inserted := make(map[int]bool)
+	wg := sync.WaitGroup{}
+	wg.Add(routineCount)
+	for i := range routineCount {
+		go func() {
+			defer wg.Done()
+			ts := int64(i)
+			for {
+				tid := TransactId(uuid.New())
+				if err := lm.LockRange(ReadLock, tid, ts, TableId(1), intRange(0, 100)); err != nil {
+					_ = lm.ReleaseLocks(tid)
+					continue
+				}
+				empty := len(inserted) == 0
+				if !empty {
+					_ = lm.ReleaseLocks(tid)
+					return
+				}
+				if err := lm.Lock(WriteLock, tid, ts, TableId(1), i); err != nil {
+					_ = lm.ReleaseLocks(tid)
+					continue
+				}
+				inserted[i] = true
+				_ = lm.ReleaseLocks(tid)
+				return
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, inserted, 1)
+}
+
+func TestLockManagerImpl_Data_Consistency_With_Upgradable_Lock(t *testing.T) {
+	// GIVEN routineCount concurrent goroutines that execute iterCount transactions
+	// that GET a counter, increment a counter, then PUT it back
+	// WHEN each routineCount completes
+	// THEN the counter should be equal to routineCount * iterCount
+	routineCount := 8
+	iterCount := 1000
+	lm := NewLockManager()
+	counter := 0
+	wg := sync.WaitGroup{}
+	wg.Add(routineCount)
+	xact := func(tid TransactId, ts int64) error {
+		if err := lm.Lock(ReadLock, tid, ts, TableId(1), 1); err != nil {
+			return err
+		}
+		val := counter
+		if err := lm.Lock(WriteLock, tid, ts, TableId(1), 1); err != nil {
+			return err
+		}
+		counter = val + 1
+		return lm.ReleaseLocks(tid)
+	}
+	f := func(r int) {
+		defer wg.Done()
+		for i := range iterCount {
+			// Timestamps are unique, as transactions with equal timestamps would keep killing each other
+			ts := int64(i*routineCount + r)
+			tid := TransactId(uuid.New())
+			err := xact(tid, ts)
+			for err != nil {
+				// Released transactions can not lock again, so the retry restarts with a new id and the same timestamp
+				_ = lm.ReleaseLocks(tid)
+				tid = TransactId(uuid.New())
+				err = xact(tid, ts)
+			}
+		}
+	}
+	for i := 0; i < routineCount; i++ {
+		go f(i)
+	}
+	wg.Wait()
+	assert.Equal(t, iterCount*routineCount, counter)
+}
+
+func TestLockManagerImpl_Readers_Should_Not_Block_Each_Other(t *testing.T) {
+	// GIVEN a younger transaction holding a read lock
+	// WHEN routineCount older transactions read the same key
+	// THEN none of them should wait
+	routineCount := 8
+	lm := NewLockManager()
+	_ = lm.Lock(ReadLock, TransactId(uuid.New()), int64(routineCount), TableId(1), 1)
+	done := make(chan error, routineCount)
+	for i := range routineCount {
+		go func() {
+			done <- lm.Lock(ReadLock, TransactId(uuid.New()), int64(i), TableId(1), 1)
+		}()
+	}
+	// A reader that waits is counted before it blocks, so the test fails instead of hanging
+	for granted := 0; granted < routineCount; {
+		select {
+		case err := <-done:
+			assert.Nil(t, err)
+			granted++
+		default:
+			if lm.Stats().Waits > 0 {
+				t.Fatal("readers should not wait for each other")
+			}
+			runtime.Gosched()
+		}
+	}
+	assert.Zero(t, lm.Stats().Waits)
+}
+
+func TestLockManagerImpl_Upgrade_Should_Wait_For_Younger_Readers(t *testing.T) {
+	lm := NewLockManager()
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	_ = lm.Lock(ReadLock, older, 1, TableId(1), 1)
+	_ = lm.Lock(ReadLock, younger, 2, TableId(1), 1)
+	waiterErr := make(chan error, 1)
+	go func() {
+		waiterErr <- lm.Lock(WriteLock, older, 1, TableId(1), 1)
+	}()
+	awaitWaits(lm, 1)
+	select {
+	case <-waiterErr:
+		t.Fatal("upgrade should wait for the other reader")
+	default:
+	}
+	// The younger reader can not upgrade, as it would wait for the older one
+	err := lm.Lock(WriteLock, younger, 2, TableId(1), 1)
+	assert.EqualError(t, err, lockConflictErr)
+	_ = lm.ReleaseLocks(younger)
+	assert.Nil(t, <-waiterErr)
+}
+
+func TestLockManagerImpl_Reader_Should_Not_Overtake_Waiting_Writer(t *testing.T) {
+	lm := NewLockManager()
+	reader := TransactId(uuid.New())
+	_ = lm.Lock(ReadLock, reader, 3, TableId(1), 1)
+	go func() {
+		_ = lm.Lock(WriteLock, TransactId(uuid.New()), 2, TableId(1), 1)
+	}()
+	awaitWaits(lm, 1)
+	// The new reader is compatible with the holder, but is younger than the waiting writer
+	err := lm.Lock(ReadLock, TransactId(uuid.New()), 4, TableId(1), 1)
+	assert.EqualError(t, err, lockConflictErr)
+	_ = lm.ReleaseLocks(reader)
+}
+
+func TestLockManagerImpl_ReleaseLocks_Should_Grant_All_Compatible_Waiters(t *testing.T) {
+	lm := NewLockManager()
+	writer := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, writer, 3, TableId(1), 1)
+	waiterErr := make(chan error, 2)
+	for i := range 2 {
+		go func() {
+			waiterErr <- lm.Lock(ReadLock, TransactId(uuid.New()), int64(i+1), TableId(1), 1)
+		}()
+	}
+	awaitWaits(lm, 2)
+	_ = lm.ReleaseLocks(writer)
+	for range 2 {
+		assert.Nil(t, <-waiterErr)
+	}
+}
+
+func TestLockManagerImpl_ReleaseReadLock_Should_Grant_Waiting_Writer(t *testing.T) {
+	lm := NewLockManager()
+	reader := TransactId(uuid.New())
+	writer := TransactId(uuid.New())
+	_ = lm.Lock(ReadLock, reader, 2, TableId(1), 1)
+	waiterErr := make(chan error, 1)
+	go func() {
+		waiterErr <- lm.Lock(WriteLock, writer, 1, TableId(1), 1)
+	}()
+	awaitWaits(lm, 1)
+	assert.Nil(t, lm.ReleaseReadLock(reader, TableId(1), 1))
+	assert.Nil(t, <-waiterErr)
+}
+
+func TestLockManagerImpl_ReleaseReadRange_Should_Allow_Insert_Into_Range(t *testing.T) {
+	lm := NewLockManager()
+	reader := TransactId(uuid.New())
+	writer := TransactId(uuid.New())
+	keyRange := intRange(10, 20)
+	_ = lm.LockRange(ReadLock, reader, 1, TableId(1), keyRange)
+	assert.EqualError(t, lm.Lock(WriteLock, writer, 2, TableId(1), 15), lockConflictErr)
+	_ = lm.ReleaseLocks(writer)
+	assert.Nil(t, lm.ReleaseReadRange(reader, TableId(1), keyRange))
+	assert.Nil(t, lm.Lock(WriteLock, TransactId(uuid.New()), 2, TableId(1), 15))
+}
+
+func TestLockManagerImpl_LockContext_Should_Stop_Waiting_When_Context_Done(t *testing.T) {
+	lm := NewLockManager()
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, younger, 2, TableId(1), 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := lm.LockContext(ctx, WriteLock, older, 1, TableId(1), 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	table, _ := lm.lockMap.Get(TableId(1))
+	ol, _ := table.Locks.Get(1)
+	assert.Empty(t, ol.Queue)
+	_ = lm.ReleaseLocks(younger)
+	assert.Nil(t, lm.Lock(WriteLock, older, 1, TableId(1), 1))
+}
+
+func TestLockManagerImpl_LockRangeContext_Should_Stop_Waiting_When_Context_Done(t *testing.T) {
+	lm := NewLockManager()
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, younger, 2, TableId(1), 15)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		awaitWaits(lm, 1)
+		cancel()
+	}()
+	err := lm.LockRangeContext(ctx, ReadLock, older, 1, TableId(1), intRange(10, 20))
+	assert.ErrorIs(t, err, context.Canceled)
+	table, _ := lm.lockMap.Get(TableId(1))
+	assert.Empty(t, table.RangeWaiters)
+	assert.Empty(t, table.Ranges)
+}
+
+func TestLockManagerImpl_Lock_Should_Fail_When_Wait_Times_Out(t *testing.T) {
+	lm := NewLockManager(WithLockTimeout(10 * time.Millisecond))
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, younger, 2, TableId(1), 1)
+	assert.ErrorIs(t, lm.Lock(ReadLock, older, 1, TableId(1), 1), ErrLockTimeout)
+	assert.ErrorIs(t, lm.LockRange(ReadLock, older, 1, TableId(1), intRange(nil, nil)), ErrLockTimeout)
+	stats := lm.Stats()
+	assert.Equal(t, int64(2), stats.Timeouts)
+	assert.Equal(t, 0, stats.Keys[0].QueueLength)
+	table, _ := lm.lockMap.Get(TableId(1))
+	assert.Empty(t, table.RangeWaiters)
+}
+
+// awaitWaits returns once n lock requests started waiting. Waits are counted after the waiter is queued,
+// so the test can act on a waiting transaction without sleeping
+func awaitWaits(lm *LockManagerImpl, n int64) {
+	for lm.Stats().Waits < n {
+		runtime.Gosched()
+	}
+}
+
+func TestLockManagerImpl_Stats_Should_Report_Holders_And_Waits(t *testing.T) {
+	lm := NewLockManager()
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, younger, 2, TableId(1), 1)
+	_ = lm.Lock(ReadLock, younger, 2, TableId(1), 2)
+	_ = lm.Lock(ReadLock, older, 1, TableId(1), 2)
+	// The younger transaction dies on the conflict, the older one waits
+	assert.EqualError(t, lm.Lock(WriteLock, younger, 2, TableId(1), 2), lockConflictErr)
+	waiterErr := make(chan error, 1)
+	go func() {
+		waiterErr <- lm.Lock(WriteLock, older, 1, TableId(1), 1)
+	}()
+	awaitWaits(lm, 1)
+	stats := lm.Stats()
+	assert.Equal(t, int64(2), stats.Conflicts)
+	assert.Len(t, stats.Keys, 2)
+	assert.Equal(t, KeyLockStats{TableId: TableId(1), Key: 1, Writer: younger, Readers: []TransactId{}, QueueLength: 1}, stats.Keys[0])
+	assert.ElementsMatch(t, []TransactId{younger, older}, stats.Keys[1].Readers)
+	_ = lm.ReleaseLocks(younger)
+	assert.Nil(t, <-waiterErr)
+	waits := int64(0)
+	for _, bucket := range lm.Stats().WaitTimes {
+		waits += bucket.Count
+	}
+	assert.Equal(t, int64(1), waits)
+}
+
+func TestLockManagerImpl_NoWait_Should_Fail_On_Any_Conflict(t *testing.T) {
+	lm := NewLockManager(WithDeadlockPolicy(NoWait))
+	_ = lm.Lock(WriteLock, TransactId(uuid.New()), 2, TableId(1), 1)
+	err := lm.Lock(ReadLock, TransactId(uuid.New()), 1, TableId(1), 1)
+	assert.EqualError(t, err, lockConflictErr)
+}
+
+func TestLockManagerImpl_WoundWait_Older_Should_Wound_Younger_Holder(t *testing.T) {
+	lm := NewLockManager(WithDeadlockPolicy(WoundWait))
+	wounded := make(chan TransactId, 1)
+	lm.SetWoundHandler(func(tid TransactId) { wounded <- tid })
+	younger := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, younger, 2, TableId(1), 1)
+	waiterErr := make(chan error, 1)
+	go func() {
+		waiterErr <- lm.Lock(WriteLock, TransactId(uuid.New()), 1, TableId(1), 1)
+	}()
+	assert.Equal(t, younger, <-wounded)
+	// The wounded transaction can not take new locks, and the older one waits until it releases
+	err := lm.Lock(WriteLock, younger, 2, TableId(1), 2)
+	assert.EqualError(t, err, lockConflictErr)
+	_ = lm.ReleaseLocks(younger)
+	assert.Nil(t, <-waiterErr)
+}
+
+func TestLockManagerImpl_WoundWait_Younger_Should_Wait(t *testing.T) {
+	lm := NewLockManager(WithDeadlockPolicy(WoundWait))
+	older := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, older, 1, TableId(1), 1)
+	waiterErr := make(chan error, 1)
+	go func() {
+		waiterErr <- lm.Lock(WriteLock, TransactId(uuid.New()), 2, TableId(1), 1)
+	}()
+	awaitWaits(lm, 1)
+	_ = lm.ReleaseLocks(older)
+	assert.Nil(t, <-waiterErr)
+}
+
+func TestLockManagerImpl_WoundWait_Should_Cancel_Wait_Of_Wounded_Transaction(t *testing.T) {
+	lm := NewLockManager(WithDeadlockPolicy(WoundWait))
+	younger := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, younger, 3, TableId(1), 1)
+	_ = lm.Lock(WriteLock, TransactId(uuid.New()), 2, TableId(1), 2)
+	youngerErr := make(chan error, 1)
+	go func() {
+		youngerErr <- lm.Lock(WriteLock, younger, 3, TableId(1), 2)
+	}()
+	awaitWaits(lm, 1)
+	go func() {
+		_ = lm.Lock(WriteLock, TransactId(uuid.New()), 1, TableId(1), 1)
+	}()
+	assert.EqualError(t, <-youngerErr, lockConflictErr)
+	_ = lm.ReleaseLocks(younger)
+}
+
+func TestLockManagerImpl_DeadlockDetection_Should_Abort_Youngest_Requester(t *testing.T) {
+	lm := NewLockManager(WithDeadlockPolicy(DeadlockDetection))
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, older, 1, TableId(1), 1)
+	_ = lm.Lock(WriteLock, younger, 2, TableId(1), 2)
+	waiterErr := make(chan error, 1)
+	go func() {
+		waiterErr <- lm.Lock(WriteLock, older, 1, TableId(1), 2)
+	}()
+	awaitWaits(lm, 1)
+	// The younger transaction closes the cycle and is chosen as the victim
+	err := lm.Lock(WriteLock, younger, 2, TableId(1), 1)
+	assert.EqualError(t, err, lockConflictErr)
+	_ = lm.ReleaseLocks(younger)
+	assert.Nil(t, <-waiterErr)
+}
+
+func TestLockManagerImpl_DeadlockDetection_Should_Abort_Youngest_Waiter(t *testing.T) {
+	lm := NewLockManager(WithDeadlockPolicy(DeadlockDetection))
+	wounded := make(chan TransactId, 1)
+	lm.SetWoundHandler(func(tid TransactId) { wounded <- tid })
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, younger, 2, TableId(1), 1)
+	_ = lm.Lock(WriteLock, older, 1, TableId(1), 2)
+	youngerErr := make(chan error, 1)
+	go func() {
+		youngerErr <- lm.Lock(WriteLock, younger, 2, TableId(1), 2)
+	}()
+	awaitWaits(lm, 1)
+	olderErr := make(chan error, 1)
+	go func() {
+		olderErr <- lm.Lock(WriteLock, older, 1, TableId(1), 1)
+	}()
+	assert.EqualError(t, <-youngerErr, lockConflictErr)
+	assert.Equal(t, younger, <-wounded)
+	_ = lm.ReleaseLocks(younger)
+	assert.Nil(t, <-olderErr)
+}
+
+func TestLockManagerImpl_Data_Consistency_With_Deadlock_Policies(t *testing.T) {
+	// GIVEN routineCount concurrent goroutines that execute iterCount transactions
+	// that lock two keys in different orders, then increment a counter
+	// WHEN each routineCount completes under every deadlock policy
+	// THEN the counter should be equal to routineCount * iterCount
+	routineCount := 8
+	iterCount := 200
+	policies := map[string]DeadlockPolicy{
+		"WaitDie":           WaitDie,
+		"WoundWait":         WoundWait,
+		"NoWait":            NoWait,
+		"DeadlockDetection": DeadlockDetection,
+	}
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			lm := NewLockManager(WithDeadlockPolicy(policy))
+			counter := 0
+			wg := sync.WaitGroup{}
+			wg.Add(routineCount)
+			xact := func(tid TransactId, ts int64, first int, second int) error {
+				if err := lm.Lock(ReadLock, tid, ts, TableId(1), first); err != nil {
+					return err
+				}
+				if err := lm.Lock(WriteLock, tid, ts, TableId(1), second); err != nil {
+					return err
+				}
+				if err := lm.Lock(WriteLock, tid, ts, TableId(1), first); err != nil {
+					return err
+				}
+				counter++
+				return lm.ReleaseLocks(tid)
+			}
+			f := func(r int) {
+				defer wg.Done()
+				for i := range iterCount {
+					ts := int64(i*routineCount + r)
+					tid := TransactId(uuid.New())
+					err := xact(tid, ts, r%2, 1-r%2)
+					for err != nil {
+						_ = lm.ReleaseLocks(tid)
+						tid = TransactId(uuid.New())
+						err = xact(tid, ts, r%2, 1-r%2)
+					}
+				}
+			}
+			for i := 0; i < routineCount; i++ {
+				go f(i)
+			}
+			wg.Wait()
+			assert.Equal(t, iterCount*routineCount, counter)
+		})
+	}
+}
+
+func TestLockManagerImpl_Should_Escalate_Write_Locks_To_Table_Lock(t *testing.T) {
+	lm := NewLockManager(WithEscalationThreshold(3))
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	for key := 1; key <= 4; key++ {
+		assert.Nil(t, lm.Lock(WriteLock, older, 1, TableId(1), key))
+	}
+	table, _ := lm.lockMap.Get(TableId(1))
+	assert.Equal(t, Exclusive, table.TableLocks[older].Mode)
+	assert.Empty(t, lm.Stats().Keys)
+	// The table lock covers keys never locked before
+	assert.Nil(t, lm.Lock(WriteLock, older, 1, TableId(1), 5))
+	assert.Empty(t, lm.Stats().Keys)
+	assert.EqualError(t, lm.Lock(ReadLock, younger, 2, TableId(1), 10), lockConflictErr)
+	_ = lm.ReleaseLocks(older)
+	assert.Empty(t, table.TableLocks)
+	assert.Nil(t, lm.Lock(ReadLock, TransactId(uuid.New()), 3, TableId(1), 10))
+}
+
+func TestLockManagerImpl_Should_Escalate_Read_Locks_To_Shared_Table_Lock(t *testing.T) {
+	lm := NewLockManager(WithEscalationThreshold(3))
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	for key := 1; key <= 4; key++ {
+		assert.Nil(t, lm.Lock(ReadLock, older, 1, TableId(1), key))
+	}
+	table, _ := lm.lockMap.Get(TableId(1))
+	assert.Equal(t, Shared, table.TableLocks[older].Mode)
+	assert.Nil(t, lm.Lock(ReadLock, younger, 2, TableId(1), 1))
+	assert.EqualError(t, lm.Lock(WriteLock, younger, 2, TableId(1), 10), lockConflictErr)
+	// Writing a key after the escalation takes an intention on top of the table lock
+	assert.Nil(t, lm.Lock(WriteLock, older, 1, TableId(1), 10))
+	assert.Equal(t, SharedIntentionExclusive, table.TableLocks[older].Mode)
+}
+
+func TestLockManagerImpl_Escalation_Should_Be_Skipped_On_Conflicting_Intention(t *testing.T) {
+	lm := NewLockManager(WithEscalationThreshold(3))
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	assert.Nil(t, lm.Lock(ReadLock, younger, 2, TableId(1), 100))
+	for key := 1; key <= 4; key++ {
+		assert.Nil(t, lm.Lock(WriteLock, older, 1, TableId(1), key))
+	}
+	table, _ := lm.lockMap.Get(TableId(1))
+	assert.Equal(t, IntentionExclusive, table.TableLocks[older].Mode)
+	assert.Len(t, lm.Stats().Keys, 5)
+	assert.Nil(t, lm.Lock(ReadLock, younger, 2, TableId(1), 50))
+}
+
+func TestLockManagerImpl_LockRange_Should_Wait_For_Table_Lock(t *testing.T) {
+	lm := NewLockManager(WithEscalationThreshold(1))
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, younger, 2, TableId(1), 1)
+	_ = lm.Lock(WriteLock, younger, 2, TableId(1), 2)
+	table, _ := lm.lockMap.Get(TableId(1))
+	assert.Equal(t, Exclusive, table.TableLocks[younger].Mode)
+	rangeErr := make(chan error, 1)
+	go func() {
+		rangeErr <- lm.LockRange(ReadLock, older, 1, TableId(1), intRange(10, 20))
+	}()
+	awaitWaits(lm, 1)
+	select {
+	case <-rangeErr:
+		t.Fatal("range lock should wait for the table lock")
+	default:
+	}
+	_ = lm.ReleaseLocks(younger)
+	assert.Nil(t, <-rangeErr)
+}
+
+func TestLockManagerImpl_ReleaseLocks_Should_Reclaim_Unused_Keys(t *testing.T) {
+	lm := NewLockManager()
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, older, 1, TableId(1), 1)
+	_ = lm.Lock(ReadLock, older, 1, TableId(1), 2)
+	_ = lm.Lock(ReadLock, younger, 2, TableId(1), 2)
+	_ = lm.ReleaseLocks(older)
+	table, _ := lm.lockMap.Get(TableId(1))
+	assert.Equal(t, []interface{}{2}, table.Locks.Keys())
+	_ = lm.ReleaseLocks(younger)
+	assert.Empty(t, table.Locks.Keys())
+	assert.Empty(t, lm.transactMap.Keys())
+}
+
+func TestLockManagerImpl_Failed_Requests_Should_Not_Leave_Keys(t *testing.T) {
+	lm := NewLockManager(WithLockTimeout(10 * time.Millisecond))
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	_ = lm.Lock(WriteLock, younger, 2, TableId(1), 1)
+	assert.ErrorIs(t, lm.Lock(ReadLock, older, 1, TableId(1), 1), ErrLockTimeout)
+	_ = lm.ReleaseLocks(younger)
+	_ = lm.ReleaseLocks(older)
+	// Requests arriving after the release are refused, and take nothing
+	assert.ErrorIs(t, lm.Lock(WriteLock, older, 1, TableId(1), 2), ErrLocksReleased)
+	table, _ := lm.lockMap.Get(TableId(1))
+	assert.Empty(t, table.Locks.Keys())
+	assert.Empty(t, lm.transactMap.Keys())
+}
+
+func TestLockManagerImpl_Released_Markers_Should_Expire(t *testing.T) {
+	lm := NewLockManager(WithReleasedRetention(time.Millisecond))
+	first := TransactId(uuid.New())
+	_ = lm.ReleaseLocks(first)
+	assert.True(t, lm.transactReleased.contains(first))
+	time.Sleep(2 * time.Millisecond)
+	_ = lm.ReleaseLocks(TransactId(uuid.New()))
+	assert.True(t, lm.transactReleased.contains(first))
+	time.Sleep(2 * time.Millisecond)
+	_ = lm.ReleaseLocks(TransactId(uuid.New()))
+	assert.False(t, lm.transactReleased.contains(first))
+	assert.Equal(t, 2, lm.transactReleased.len())
+}
+
+// soakXacts is the number of transactions of the soak test. Run millions with ASYNCDB_SOAK_XACTS=2000000
+func soakXacts(t *testing.T) int {
+	value, ok := os.LookupEnv("ASYNCDB_SOAK_XACTS")
+	if !ok {
+		return 50000
+	}
+	xacts, err := strconv.Atoi(value)
+	if err != nil {
+		t.Fatalf("invalid ASYNCDB_SOAK_XACTS: %v", err)
+	}
+	return xacts
+}
+
+func TestLockManagerImpl_Soak_Should_Not_Grow(t *testing.T) {
+	// GIVEN many short transactions locking random keys concurrently
+	// WHEN they run and end
+	// THEN the lock objects and released markers should stay bounded, and none should be left
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+	retention := 10 * time.Millisecond
+	lm := NewLockManager(WithReleasedRetention(retention))
+	routineCount := 8
+	locksPerXact := 4
+	xactCount := soakXacts(t) / routineCount
+	var ts atomic.Int64
+	wg := sync.WaitGroup{}
+	wg.Add(routineCount)
+	for range routineCount {
+		go func() {
+			defer wg.Done()
+			for range xactCount {
+				tid := TransactId(uuid.New())
+				xactTs := ts.Add(1)
+				for range locksPerXact {
+					lockType := ReadLock
+					if rand.Intn(2) == 0 {
+						lockType = WriteLock
+					}
+					if lm.Lock(lockType, tid, xactTs, TableId(rand.Intn(2)), rand.Intn(1000)) != nil {
+						break
+					}
+				}
+				_ = lm.ReleaseLocks(tid)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	// Lock objects exist only while a transaction holds or requests them, and markers only for two retentions
+	maxObjects, maxReleased := 0, 0
+	for sampling := true; sampling; {
+		select {
+		case <-done:
+			sampling = false
+		case <-time.After(time.Millisecond):
+		}
+		objects := 0
+		for _, table := range lm.lockMap.Values() {
+			objects += len(table.Locks.Keys())
+		}
+		maxObjects = max(maxObjects, objects)
+		maxReleased = max(maxReleased, lm.transactReleased.len())
+	}
+	assert.LessOrEqual(t, maxObjects, routineCount*locksPerXact)
+	assert.Less(t, maxReleased, int(ts.Load())/2, "released markers should expire while transactions run")
+
+	for _, table := range lm.lockMap.Values() {
+		assert.Empty(t, table.Locks.Keys())
+		assert.Empty(t, table.TableLocks)
+		assert.Empty(t, table.RangeWaiters)
+	}
+	assert.Empty(t, lm.transactMap.Keys())
+	for range 2 {
+		time.Sleep(2 * retention)
+		_ = lm.ReleaseLocks(TransactId(uuid.New()))
+	}
+	assert.Equal(t, 2, lm.transactReleased.len())
+}
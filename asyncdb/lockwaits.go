@@ -0,0 +1,150 @@
+package asyncdb
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"math"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+var ErrLockTimeout = errors.New("lock wait timeout")
+
+// waitTimeBounds are the upper bounds of the wait time histogram buckets, the last bucket is unbounded
+var waitTimeBounds = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+	time.Duration(math.MaxInt64),
+}
+
+// LockStats is a snapshot of the state of the lock manager, and of the counters since it was created
+type LockStats struct {
+	// Keys are the keys with holders or waiters, those with the longest queues first
+	Keys []KeyLockStats
+	// Conflicts counts the lock requests that conflicted with other transactions, Waits counts those that waited
+	Conflicts int64
+	Waits     int64
+	Timeouts  int64
+	// WaitTimes is the histogram of the durations of finished waits
+	WaitTimes []WaitTimeBucket
+}
+
+type KeyLockStats struct {
+	TableId TableId
+	Key     interface{}
+	// Writer is uuid.Nil if the key is not write locked
+	Writer      TransactId
+	Readers     []TransactId
+	QueueLength int
+}
+
+// WaitTimeBucket counts the waits that took longer than the bound of the previous bucket, and up to UpperBound
+type WaitTimeBucket struct {
+	UpperBound time.Duration
+	Count      int64
+}
+
+type lockMetrics struct {
+	conflicts atomic.Int64
+	waits     atomic.Int64
+	timeouts  atomic.Int64
+	waitTimes []atomic.Int64
+}
+
+func newLockMetrics() *lockMetrics {
+	return &lockMetrics{waitTimes: make([]atomic.Int64, len(waitTimeBounds))}
+}
+
+// startWait counts a wait, and returns the function recording its duration
+func (m *lockMetrics) startWait() func() {
+	m.waits.Add(1)
+	start := time.Now()
+	return func() {
+		m.observeWait(time.Since(start))
+	}
+}
+
+func (m *lockMetrics) observeWait(d time.Duration) {
+	i, _ := slices.BinarySearch(waitTimeBounds, d)
+	m.waitTimes[i].Add(1)
+}
+
+// WithLockTimeout fails lock requests that wait longer than the timeout with ErrLockTimeout. The requester
+// has to abort, like on ErrLockConflict. Zero, the default, means waiting until the lock is granted
+func WithLockTimeout(timeout time.Duration) func(*LockManagerImpl) {
+	return func(lm *LockManagerImpl) {
+		lm.timeout = timeout
+	}
+}
+
+// waitContext limits the waits of a lock request by the lock timeout
+func (lm *LockManagerImpl) waitContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if lm.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, lm.timeout, ErrLockTimeout)
+}
+
+// await waits for the result of a queued lock request. If the context is done first, the request is cancelled,
+// and ErrLockTimeout or the context error is returned. A lock granted in the meantime is kept until
+// the transaction releases its locks
+func (lm *LockManagerImpl) await(ctx context.Context, wait chan error, cancel func()) error {
+	select {
+	case err := <-wait:
+		return err
+	case <-ctx.Done():
+		cancel()
+		<-wait
+		err := context.Cause(ctx)
+		if errors.Is(err, ErrLockTimeout) {
+			lm.metrics.timeouts.Add(1)
+		}
+		return err
+	}
+}
+
+func (lm *LockManagerImpl) Stats() LockStats {
+	stats := LockStats{
+		Keys:      make([]KeyLockStats, 0),
+		Conflicts: lm.metrics.conflicts.Load(),
+		Waits:     lm.metrics.waits.Load(),
+		Timeouts:  lm.metrics.timeouts.Load(),
+		WaitTimes: make([]WaitTimeBucket, len(waitTimeBounds)),
+	}
+	for i, bound := range waitTimeBounds {
+		stats.WaitTimes[i] = WaitTimeBucket{UpperBound: bound, Count: lm.metrics.waitTimes[i].Load()}
+	}
+	lm.lockMap.lock.RLock()
+	defer lm.lockMap.lock.RUnlock()
+	for tableId, table := range lm.lockMap.m {
+		table.Locks.lock.RLock()
+		for key, ol := range table.Locks.m {
+			ol.m.Lock()
+			keyStats := KeyLockStats{
+				TableId:     tableId,
+				Key:         key,
+				Writer:      ol.WLock.tId,
+				Readers:     make([]TransactId, 0, len(ol.RLock)),
+				QueueLength: len(ol.Queue),
+			}
+			for _, r := range ol.RLock {
+				keyStats.Readers = append(keyStats.Readers, r.tId)
+			}
+			ol.m.Unlock()
+			if keyStats.Writer != TransactId(uuid.Nil) || len(keyStats.Readers) > 0 || keyStats.QueueLength > 0 {
+				stats.Keys = append(stats.Keys, keyStats)
+			}
+		}
+		table.Locks.lock.RUnlock()
+	}
+	slices.SortStableFunc(stats.Keys, func(a, b KeyLockStats) int {
+		return b.QueueLength - a.QueueLength
+	})
+	return stats
+}
@@ -0,0 +1,548 @@
+package asyncdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logPut = 1 + iota
+	logDelete
+	// logCompacted starts a segment written by compaction, which holds every live row of the segments before it
+	logCompacted
+)
+
+var ErrLogTableCorrupted = errors.New("log table corrupted")
+
+// LogTableConfig tunes the segments of a LogTable
+type LogTableConfig struct {
+	// MaxSegmentSize is the size after which writes go to a new segment
+	MaxSegmentSize int64
+	// CompactionInterval is the period of background compactions, zero disables them
+	CompactionInterval time.Duration
+	// SyncWrites makes every write durable before it returns. Otherwise writes survive crashes of the process,
+	// but not of the machine
+	SyncWrites bool
+}
+
+var defaultLogTableConfig = LogTableConfig{
+	MaxSegmentSize:     4 << 20,
+	CompactionInterval: time.Minute,
+}
+
+func WithMaxSegmentSize(size int64) func(*LogTableConfig) {
+	return func(c *LogTableConfig) {
+		c.MaxSegmentSize = size
+	}
+}
+
+func WithCompactionInterval(interval time.Duration) func(*LogTableConfig) {
+	return func(c *LogTableConfig) {
+		c.CompactionInterval = interval
+	}
+}
+
+func WithSyncWrites() func(*LogTableConfig) {
+	return func(c *LogTableConfig) {
+		c.SyncWrites = true
+	}
+}
+
+// logRecord is a write of a LogTable. Records are framed like the records of FileWAL, see encodeFrame
+type logRecord[K comparable, V any] struct {
+	Op    int
+	Key   K
+	Value V
+}
+
+// logLocation is the position of the latest record of a key
+type logLocation struct {
+	segment uint64
+	offset  int64
+	size    int64
+}
+
+// LogTable is a durable table that appends writes to segment files in a directory, and keeps the location
+// of the latest record of every key in memory. Sealed segments are merged by compaction, which drops
+// overwritten and deleted rows. Reopening the table rebuilds the index from the segments.
+// Keys and values are encoded with encoding/gob
+type LogTable[K comparable, V any] struct {
+	name   string
+	dir    string
+	config LogTableConfig
+
+	// m guards the index and the segments. Records are immutable once written, so reads share it
+	m        *sync.RWMutex
+	index    map[K]logLocation
+	segments map[uint64]*os.File
+	// active is the segment receiving writes, and activeSize is where the next record goes
+	active     uint64
+	activeSize int64
+	// compacted is the segment written by the last compaction
+	compacted uint64
+	// ordered keeps the keys ordered for scans. It is built by the first scan
+	ordered *skipList[K]
+	compare func(a, b K) int
+
+	compacting *sync.Mutex
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// NewLogTable opens the table with the name in the directory, creating both if needed
+func NewLogTable[K comparable, V any](name string, dir string, options ...func(*LogTableConfig)) (*LogTable[K, V], error) {
+	if name == "" {
+		return nil, ErrEmptyTableName
+	}
+	config := defaultLogTableConfig
+	for _, option := range options {
+		option(&config)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create table directory: %w", err)
+	}
+	t := &LogTable[K, V]{
+		name:       name,
+		dir:        dir,
+		config:     config,
+		m:          &sync.RWMutex{},
+		index:      make(map[K]logLocation),
+		segments:   make(map[uint64]*os.File),
+		compare:    defaultKeyOrder[K](),
+		compacting: &sync.Mutex{},
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	if err := t.recover(); err != nil {
+		t.closeSegments()
+		return nil, err
+	}
+	go t.compactPeriodically()
+	return t, nil
+}
+
+func (t *LogTable[K, V]) segmentPath(seq uint64) string {
+	return filepath.Join(t.dir, fmt.Sprintf("%s-%06d.seg", t.name, seq))
+}
+
+// segmentSeqs lists the segments of the table in the order they were written
+func (t *LogTable[K, V]) segmentSeqs() ([]uint64, error) {
+	paths, err := filepath.Glob(filepath.Join(t.dir, t.name+"-*.seg"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments: %w", err)
+	}
+	seqs := make([]uint64, 0, len(paths))
+	for _, path := range paths {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), t.name+"-"), ".seg"), 10, 64)
+		if err != nil {
+			// A table whose name starts with this one
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+	return seqs, nil
+}
+
+// recover replays the segments into the index. A record torn by a crash can only be at the end of the last
+// segment, so the segment is truncated there. A compacted segment supersedes the segments before it,
+// which are left behind only if compaction crashed before removing them
+func (t *LogTable[K, V]) recover() error {
+	// An unfinished compaction never replaced a segment
+	tmps, _ := filepath.Glob(filepath.Join(t.dir, t.name+"-*.seg.tmp"))
+	for _, tmp := range tmps {
+		_ = os.Remove(tmp)
+	}
+	seqs, err := t.segmentSeqs()
+	if err != nil {
+		return err
+	}
+	if len(seqs) == 0 {
+		seqs = append(seqs, 1)
+	}
+	for i, seq := range seqs {
+		file, err := os.OpenFile(t.segmentPath(seq), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open segment: %w", err)
+		}
+		t.segments[seq] = file
+		validSize, err := t.replaySegment(seq, file)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to open segment: %w", err)
+		}
+		if validSize < info.Size() {
+			if i < len(seqs)-1 {
+				return fmt.Errorf("%w: segment %d", ErrLogTableCorrupted, seq)
+			}
+			if err = file.Truncate(validSize); err != nil {
+				return fmt.Errorf("failed to truncate segment: %w", err)
+			}
+		}
+		t.active, t.activeSize = seq, validSize
+	}
+	for seq, file := range t.segments {
+		if seq < t.compacted {
+			_ = file.Close()
+			delete(t.segments, seq)
+			_ = os.Remove(t.segmentPath(seq))
+		}
+	}
+	return nil
+}
+
+// replaySegment applies the records of the segment to the index, and returns the size of its valid prefix
+func (t *LogTable[K, V]) replaySegment(seq uint64, file *os.File) (int64, error) {
+	validSize, err := readFrames(io.NewSectionReader(file, 0, 1<<62), func(payload []byte, offset int64, size int64) error {
+		rec, err := decodeLogRecord[K, V](payload)
+		if err != nil {
+			return err
+		}
+		switch rec.Op {
+		case logPut:
+			t.index[rec.Key] = logLocation{segment: seq, offset: offset, size: size}
+		case logDelete:
+			delete(t.index, rec.Key)
+		case logCompacted:
+			clear(t.index)
+			t.compacted = seq
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to replay segment %d: %w", seq, err)
+	}
+	return validSize, nil
+}
+
+func encodeLogRecord[K comparable, V any](rec logRecord[K, V]) ([]byte, error) {
+	buf, err := encodeFrame(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode record: %w", err)
+	}
+	return buf, nil
+}
+
+func decodeLogRecord[K comparable, V any](payload []byte) (logRecord[K, V], error) {
+	var rec logRecord[K, V]
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&rec); err != nil {
+		return rec, fmt.Errorf("%w: %w", ErrLogTableCorrupted, err)
+	}
+	return rec, nil
+}
+
+// readRecord reads the record at the location and checks its checksum. Requires the read lock
+func (t *LogTable[K, V]) readRecord(loc logLocation) (logRecord[K, V], error) {
+	buf := make([]byte, loc.size)
+	if _, err := t.segments[loc.segment].ReadAt(buf, loc.offset); err != nil {
+		return logRecord[K, V]{}, fmt.Errorf("failed to read record: %w", err)
+	}
+	payload, ok := framePayload(buf)
+	if !ok {
+		return logRecord[K, V]{}, fmt.Errorf("%w: checksum mismatch in segment %d", ErrLogTableCorrupted, loc.segment)
+	}
+	return decodeLogRecord[K, V](payload)
+}
+
+// appendRecord writes the record to the active segment, starting a new one if it is full. Requires the lock
+func (t *LogTable[K, V]) appendRecord(rec logRecord[K, V]) (logLocation, error) {
+	buf, err := encodeLogRecord(rec)
+	if err != nil {
+		return logLocation{}, err
+	}
+	if t.activeSize > 0 && t.activeSize+int64(len(buf)) > t.config.MaxSegmentSize {
+		file, err := os.OpenFile(t.segmentPath(t.active+1), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return logLocation{}, fmt.Errorf("failed to create segment: %w", err)
+		}
+		t.active, t.activeSize = t.active+1, 0
+		t.segments[t.active] = file
+	}
+	file := t.segments[t.active]
+	// A failed write is overwritten by the next one, so it never becomes part of the segment
+	if _, err = file.WriteAt(buf, t.activeSize); err != nil {
+		return logLocation{}, fmt.Errorf("failed to write record: %w", err)
+	}
+	if t.config.SyncWrites {
+		if err = file.Sync(); err != nil {
+			return logLocation{}, fmt.Errorf("failed to sync segment: %w", err)
+		}
+	}
+	loc := logLocation{segment: t.active, offset: t.activeSize, size: int64(len(buf))}
+	t.activeSize += loc.size
+	return loc, nil
+}
+
+func (t *LogTable[K, V]) Name() string {
+	return t.name
+}
+
+// durable reports whether writes are synced before they return, otherwise checkpoints keep them in the
+// write-ahead log
+func (t *LogTable[K, V]) durable() bool {
+	return t.config.SyncWrites
+}
+
+func (t *LogTable[K, V]) Get(key interface{}) (value interface{}, err error) {
+	keyTyped, ok := key.(K)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected key type - %T, got - %T", ErrTypeMismatch, *new(K), key)
+	}
+	t.m.RLock()
+	defer t.m.RUnlock()
+	loc, ok := t.index[keyTyped]
+	if !ok {
+		return nil, fmt.Errorf("%w - %v", ErrKeyNotFound, key)
+	}
+	rec, err := t.readRecord(loc)
+	if err != nil {
+		return nil, err
+	}
+	return rec.Value, nil
+}
+
+func (t *LogTable[K, V]) Put(key interface{}, value interface{}) error {
+	if err := t.ValidateTypes(key, value); err != nil {
+		return err
+	}
+	keyTyped := key.(K)
+	t.m.Lock()
+	defer t.m.Unlock()
+	loc, err := t.appendRecord(logRecord[K, V]{Op: logPut, Key: keyTyped, Value: value.(V)})
+	if err != nil {
+		return err
+	}
+	t.index[keyTyped] = loc
+	if t.ordered != nil {
+		t.ordered.Insert(keyTyped)
+	}
+	return nil
+}
+
+func (t *LogTable[K, V]) Delete(key interface{}) error {
+	keyTyped, ok := key.(K)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrTypeMismatch, key)
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	if _, ok = t.index[keyTyped]; !ok {
+		return fmt.Errorf("%w - %v", ErrKeyNotFound, key)
+	}
+	if _, err := t.appendRecord(logRecord[K, V]{Op: logDelete, Key: keyTyped}); err != nil {
+		return err
+	}
+	delete(t.index, keyTyped)
+	if t.ordered != nil {
+		t.ordered.Delete(keyTyped)
+	}
+	return nil
+}
+
+func (t *LogTable[K, V]) ValidateTypes(key interface{}, value interface{}) error {
+	if _, ok := key.(K); !ok {
+		return fmt.Errorf("%w: expected key type - %T, got - %T", ErrTypeMismatch, *new(K), key)
+	}
+	if value != nil {
+		if _, ok := value.(V); !ok {
+			return fmt.Errorf("%w: expected value type - %T, got - %T", ErrTypeMismatch, *new(V), value)
+		}
+	}
+	return nil
+}
+
+func (t *LogTable[K, V]) CompareKeys(a interface{}, b interface{}) int {
+	aTyped, aOk := a.(K)
+	bTyped, bOk := b.(K)
+	if !aOk || !bOk {
+		return CompareKeys(a, b)
+	}
+	return t.compare(aTyped, bTyped)
+}
+
+func (t *LogTable[K, V]) Scan(from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	fromTyped, err := scanBound[K](from)
+	if err != nil {
+		return nil, err
+	}
+	toTyped, err := scanBound[K](to)
+	if err != nil {
+		return nil, err
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.ordered == nil {
+		t.ordered = newSkipList[K](t.compare)
+		for key := range t.index {
+			t.ordered.Insert(key)
+		}
+	}
+	return t.ordered.scan(fromTyped, toTyped, limit, func(key K) (interface{}, error) {
+		rec, err := t.readRecord(t.index[key])
+		return rec.Value, err
+	})
+}
+
+func (t *LogTable[K, V]) compactPeriodically() {
+	defer close(t.stopped)
+	if t.config.CompactionInterval <= 0 {
+		<-t.stop
+		return
+	}
+	ticker := time.NewTicker(t.config.CompactionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			// A failed compaction leaves the segments as they were, and is retried by the next one
+			_ = t.Compact()
+		}
+	}
+}
+
+// Compact merges the sealed segments into one holding only their live rows. It runs alongside reads
+// and writes, which are blocked only while the merged segment replaces the old ones
+func (t *LogTable[K, V]) Compact() error {
+	t.compacting.Lock()
+	defer t.compacting.Unlock()
+	t.m.RLock()
+	sealed := make([]uint64, 0, len(t.segments))
+	for seq := range t.segments {
+		if seq < t.active {
+			sealed = append(sealed, seq)
+		}
+	}
+	slices.Sort(sealed)
+	if len(sealed) == 0 || (len(sealed) == 1 && sealed[0] == t.compacted) {
+		t.m.RUnlock()
+		return nil
+	}
+	live := make(map[K]logLocation)
+	for key, loc := range t.index {
+		if loc.segment < t.active {
+			live[key] = loc
+		}
+	}
+	t.m.RUnlock()
+
+	// Sealed segments are not changed by writes, and only compaction removes them
+	target := sealed[len(sealed)-1]
+	moved, err := t.writeCompacted(target, live)
+	if err != nil {
+		return err
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+	if err = os.Rename(t.segmentPath(target)+".tmp", t.segmentPath(target)); err != nil {
+		return fmt.Errorf("failed to replace segment: %w", err)
+	}
+	syncDir(t.dir)
+	file, err := os.OpenFile(t.segmentPath(target), os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open segment: %w", err)
+	}
+	for _, seq := range sealed {
+		_ = t.segments[seq].Close()
+		delete(t.segments, seq)
+		if seq != target {
+			_ = os.Remove(t.segmentPath(seq))
+		}
+	}
+	t.segments[target] = file
+	t.compacted = target
+	for key, loc := range moved {
+		// Keys written since the snapshot keep their newer location
+		if t.index[key] == live[key] {
+			t.index[key] = loc
+		}
+	}
+	return nil
+}
+
+// writeCompacted copies the records at the locations to the replacement of the target segment, starting
+// with the compaction marker, and returns their locations in it
+func (t *LogTable[K, V]) writeCompacted(target uint64, live map[K]logLocation) (map[K]logLocation, error) {
+	path := t.segmentPath(target) + ".tmp"
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment: %w", err)
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	marker, err := encodeLogRecord(logRecord[K, V]{Op: logCompacted})
+	if err != nil {
+		return nil, err
+	}
+	_, _ = writer.Write(marker)
+	offset := int64(len(marker))
+	moved := make(map[K]logLocation, len(live))
+	buf := make([]byte, 0)
+	t.m.RLock()
+	for key, loc := range live {
+		buf = slices.Grow(buf[:0], int(loc.size))[:loc.size]
+		if _, err = t.segments[loc.segment].ReadAt(buf, loc.offset); err != nil {
+			break
+		}
+		if _, err = writer.Write(buf); err != nil {
+			break
+		}
+		moved[key] = logLocation{segment: target, offset: offset, size: loc.size}
+		offset += loc.size
+	}
+	t.m.RUnlock()
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("failed to write compacted segment: %w", err)
+	}
+	return moved, nil
+}
+
+// syncDir makes renames and removals in the directory durable
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+}
+
+func (t *LogTable[K, V]) closeSegments() {
+	for _, file := range t.segments {
+		_ = file.Close()
+	}
+}
+
+// Close stops the compaction and closes the segments
+func (t *LogTable[K, V]) Close() error {
+	close(t.stop)
+	<-t.stopped
+	t.m.Lock()
+	defer t.m.Unlock()
+	var err error
+	for _, file := range t.segments {
+		err = errors.Join(err, file.Sync(), file.Close())
+	}
+	return err
+}
@@ -0,0 +1,220 @@
+package asyncdb
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func openLogTestTable(t *testing.T, dir string, options ...func(*LogTableConfig)) *LogTable[int, string] {
+	table, err := NewLogTable[int, string]("test", dir, append([]func(*LogTableConfig){WithCompactionInterval(0)}, options...)...)
+	assert.Nil(t, err)
+	return table
+}
+
+func logTestSegments(t *testing.T, dir string) []string {
+	segments, err := filepath.Glob(filepath.Join(dir, "test-*.seg"))
+	assert.Nil(t, err)
+	return segments
+}
+
+func TestLogTable_Should_Put_Get_And_Delete(t *testing.T) {
+	table := openLogTestTable(t, t.TempDir())
+	defer table.Close()
+	assert.Nil(t, table.Put(1, "one"))
+	assert.Nil(t, table.Put(1, "uno"))
+	value, err := table.Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "uno", value)
+	assert.Nil(t, table.Delete(1))
+	value, err = table.Get(1)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Nil(t, value)
+	assert.ErrorIs(t, table.Delete(1), ErrKeyNotFound)
+	assert.ErrorIs(t, table.Put("1", "one"), ErrTypeMismatch)
+	assert.ErrorIs(t, table.Put(1, 1), ErrTypeMismatch)
+}
+
+func TestLogTable_Reopen_Should_Rebuild_Index(t *testing.T) {
+	dir := t.TempDir()
+	table := openLogTestTable(t, dir, WithMaxSegmentSize(128))
+	for key := 0; key < 20; key++ {
+		assert.Nil(t, table.Put(key, "first"))
+	}
+	for key := 0; key < 20; key += 2 {
+		assert.Nil(t, table.Put(key, "second"))
+	}
+	for key := 0; key < 20; key += 5 {
+		assert.Nil(t, table.Delete(key))
+	}
+	assert.Nil(t, table.Close())
+	assert.Greater(t, len(logTestSegments(t, dir)), 1)
+
+	table = openLogTestTable(t, dir, WithMaxSegmentSize(128))
+	defer table.Close()
+	for key := 0; key < 20; key++ {
+		value, err := table.Get(key)
+		switch {
+		case key%5 == 0:
+			assert.ErrorIs(t, err, ErrKeyNotFound)
+		case key%2 == 0:
+			assert.Equal(t, "second", value)
+		default:
+			assert.Equal(t, "first", value)
+		}
+	}
+}
+
+func TestLogTable_Reopen_Should_Drop_Torn_Record(t *testing.T) {
+	dir := t.TempDir()
+	table := openLogTestTable(t, dir)
+	assert.Nil(t, table.Put(1, "one"))
+	assert.Nil(t, table.Put(2, "two"))
+	assert.Nil(t, table.Close())
+	// Crash in the middle of the last write
+	segment := logTestSegments(t, dir)[0]
+	info, _ := os.Stat(segment)
+	assert.Nil(t, os.Truncate(segment, info.Size()-3))
+
+	table = openLogTestTable(t, dir)
+	value, err := table.Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "one", value)
+	_, err = table.Get(2)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Nil(t, table.Put(3, "three"))
+	assert.Nil(t, table.Close())
+
+	table = openLogTestTable(t, dir)
+	defer table.Close()
+	value, err = table.Get(3)
+	assert.Nil(t, err)
+	assert.Equal(t, "three", value)
+}
+
+func TestLogTable_Reopen_Should_Detect_Corrupted_Sealed_Segment(t *testing.T) {
+	dir := t.TempDir()
+	table := openLogTestTable(t, dir, WithMaxSegmentSize(64))
+	for key := 0; key < 10; key++ {
+		assert.Nil(t, table.Put(key, "value"))
+	}
+	assert.Nil(t, table.Close())
+	segment := logTestSegments(t, dir)[0]
+	data, _ := os.ReadFile(segment)
+	data[len(data)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(segment, data, 0644))
+	_, err := NewLogTable[int, string]("test", dir)
+	assert.ErrorIs(t, err, ErrLogTableCorrupted)
+}
+
+func TestLogTable_Compact_Should_Keep_Live_Rows_Only(t *testing.T) {
+	dir := t.TempDir()
+	table := openLogTestTable(t, dir, WithMaxSegmentSize(256))
+	for round := 0; round < 10; round++ {
+		for key := 0; key < 10; key++ {
+			assert.Nil(t, table.Put(key, "value"))
+		}
+	}
+	assert.Nil(t, table.Delete(0))
+	before := len(logTestSegments(t, dir))
+	assert.Nil(t, table.Compact())
+	// The sealed segments are merged, the active one is kept
+	assert.Len(t, logTestSegments(t, dir), 2)
+	assert.Greater(t, before, 2)
+	assert.Nil(t, table.Put(10, "new"))
+	assert.Nil(t, table.Close())
+
+	table = openLogTestTable(t, dir, WithMaxSegmentSize(256))
+	defer table.Close()
+	_, err := table.Get(0)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	for key := 1; key <= 10; key++ {
+		_, err = table.Get(key)
+		assert.Nil(t, err)
+	}
+}
+
+func TestLogTable_Reopen_Should_Finish_Interrupted_Compaction(t *testing.T) {
+	dir := t.TempDir()
+	table := openLogTestTable(t, dir, WithMaxSegmentSize(64))
+	for key := 0; key < 10; key++ {
+		assert.Nil(t, table.Put(key, "value"))
+	}
+	assert.Nil(t, table.Delete(1))
+	first := logTestSegments(t, dir)[0]
+	stale, _ := os.ReadFile(first)
+	assert.Nil(t, table.Compact())
+	assert.Nil(t, table.Close())
+	// The crash happened before the merged segments were removed, and left a partial compaction
+	assert.Nil(t, os.WriteFile(first, stale, 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "test-000099.seg.tmp"), []byte("partial"), 0644))
+
+	table = openLogTestTable(t, dir, WithMaxSegmentSize(64))
+	defer table.Close()
+	_, err := os.Stat(first)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "test-000099.seg.tmp"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = table.Get(1)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	value, err := table.Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func TestLogTable_Compact_Should_Not_Lose_Concurrent_Writes(t *testing.T) {
+	table := openLogTestTable(t, t.TempDir(), WithMaxSegmentSize(256))
+	defer table.Close()
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			// Every round deletes a key written by the previous one, and writes it again
+			if i >= 50 && i%50 == 0 {
+				assert.Nil(t, table.Delete(i/50%50))
+			}
+			assert.Nil(t, table.Put(i%50, "value"))
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, table.Compact())
+	}
+	wg.Wait()
+	assert.Nil(t, table.Compact())
+	for key := 0; key < 50; key++ {
+		value, err := table.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, "value", value)
+	}
+}
+
+func TestLogTable_Scan_Should_Return_Ordered_Rows(t *testing.T) {
+	table := openLogTestTable(t, t.TempDir())
+	defer table.Close()
+	for _, key := range []int{5, 1, 3, 2, 4} {
+		assert.Nil(t, table.Put(key, "value"))
+	}
+	assert.Nil(t, table.Delete(3))
+	rows, err := table.Scan(2, nil, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyValue{{2, "value"}, {4, "value"}}, rows)
+}
+
+func TestLogTable_Should_Persist_AsyncDB_Commits(t *testing.T) {
+	dir := t.TempDir()
+	table := openLogTestTable(t, dir)
+	db, ctx := newTestDB(t, NewLockManager(), []Table{table})
+	_ = db.BeginTransaction(ctx)
+	assert.Nil(t, (<-db.Put(ctx, "test", 1, "one")).Err)
+	assert.Nil(t, db.CommitTransaction(ctx))
+	assert.Nil(t, table.Close())
+
+	table = openLogTestTable(t, dir)
+	defer table.Close()
+	value, err := table.Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "one", value)
+}
@@ -0,0 +1,303 @@
+package asyncdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// version is a committed state of a key. Deleted versions mark that the key did not exist since ts
+type version struct {
+	ts      int64
+	value   interface{}
+	deleted bool
+	// unapplied versions are not in the table, so they are kept even when no transaction needs older ones
+	unapplied bool
+}
+
+// mvccControl is snapshot isolation over multiple versions of keys. A transaction reads the newest versions
+// committed before it started without taking locks, and write-write conflicts are resolved at commit:
+// the first committer wins, and the others abort with ErrXactAborted.
+// Only the keys written since the oldest running transaction started have versions, the other keys are read
+// from the tables directly
+type mvccControl struct {
+	data *ThreadSafeMap[uint64, Table]
+	// versions of every key are ordered by their commit timestamps, the first version is the state before
+	// the first versioned commit. Readers of the tables hold m, so the tables do not change for keys without versions
+	versions map[uint64]map[interface{}][]version
+	m        *sync.RWMutex
+	// commitMu serializes validation and publishing of commits
+	commitMu *sync.Mutex
+	clock    *transactionClock
+}
+
+// WithMVCC replaces locking with multi-version snapshot isolation. The timestamp of a transaction
+// is its snapshot, and the lock manager is not used
+func WithMVCC() func(*AsyncDB) {
+	return func(db *AsyncDB) {
+		db.cc = newMVCCControl(db.data)
+	}
+}
+
+func newMVCCControl(data *ThreadSafeMap[uint64, Table]) *mvccControl {
+	return &mvccControl{
+		data:     data,
+		versions: make(map[uint64]map[interface{}][]version),
+		m:        &sync.RWMutex{},
+		commitMu: &sync.Mutex{},
+		clock:    newTransactionClock(),
+	}
+}
+
+func (c *mvccControl) begin(txn *TransactInfo) {
+	c.clock.begin(txn)
+}
+
+// visible returns the newest version committed before the snapshot
+func visible(versions []version, ts int64) version {
+	for i := len(versions) - 1; i > 0; i-- {
+		if versions[i].ts <= ts {
+			return versions[i]
+		}
+	}
+	return versions[0]
+}
+
+func (c *mvccControl) read(ctx context.Context, txn *TransactInfo, tableId uint64, table Table, key interface{}) (interface{}, error) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	versions, ok := c.versions[tableId][key]
+	if !ok {
+		return getContext(ctx, table, key)
+	}
+	v := visible(versions, txn.ts)
+	if v.deleted {
+		return nil, fmt.Errorf("%w - %v", ErrKeyNotFound, key)
+	}
+	return v.value, nil
+}
+
+func (c *mvccControl) scan(ctx context.Context, txn *TransactInfo, tableId uint64, table OrderedTable, from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	// Versioned keys may have been deleted from the table, or not inserted yet, so the limit is applied
+	// after merging them
+	rows, err := scanContext(ctx, table, from, to, 0)
+	if err != nil {
+		return nil, err
+	}
+	versioned := c.versions[tableId]
+	res := make([]KeyValue, 0, len(rows))
+	for _, row := range rows {
+		if _, ok := versioned[row.Key]; !ok {
+			res = append(res, row)
+		}
+	}
+	for key, versions := range versioned {
+		if (from != nil && table.CompareKeys(key, from) < 0) || (to != nil && table.CompareKeys(key, to) > 0) {
+			continue
+		}
+		if v := visible(versions, txn.ts); !v.deleted {
+			res = append(res, KeyValue{Key: key, Value: v.value})
+		}
+	}
+	slices.SortFunc(res, func(a, b KeyValue) int {
+		return table.CompareKeys(a.Key, b.Key)
+	})
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (c *mvccControl) write(_ context.Context, _ *TransactInfo, _ uint64, _ interface{}) error {
+	return nil
+}
+
+// commit installs the versions of the transaction before applying its log, so that readers of older
+// snapshots do not see the new values in the tables. The versions become visible once the clock is advanced
+func (c *mvccControl) commit(txn *TransactInfo, tables map[uint64][]LogEntry, apply func() error) error {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+	if c.hasWriteConflict(txn, tables) {
+		return ErrXactAborted
+	}
+	return c.commitVersions(txn, tables, apply)
+}
+
+// commitVersions installs, applies and publishes the versions of a transaction allowed to commit. Requires commitMu
+func (c *mvccControl) commitVersions(txn *TransactInfo, tables map[uint64][]LogEntry, apply func() error) error {
+	commitTs := c.clock.next()
+	if err := c.install(commitTs, tables); err != nil {
+		c.uninstall(commitTs, tables)
+		return err
+	}
+	err := apply()
+	if err != nil && !errors.Is(err, ErrCommitNotApplied) {
+		c.uninstall(commitTs, tables)
+		return err
+	}
+	if err != nil {
+		// Some table did not apply the log, so the versions are the only correct state of the keys.
+		// They are marked before publishing, as running transactions can prune once they see them
+		c.markUnapplied(commitTs, tables)
+	}
+	oldest := c.clock.publish(commitTs, txn.tId)
+	c.prune(tables, oldest)
+	return err
+}
+
+// hasWriteConflict checks if a key written by the transaction was committed by another transaction
+// after the snapshot was taken. Requires commitMu
+func (c *mvccControl) hasWriteConflict(txn *TransactInfo, tables map[uint64][]LogEntry) bool {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	for tableId, entries := range tables {
+		for _, entry := range entries {
+			versions := c.versions[tableId][entry.Key]
+			if len(versions) > 0 && versions[len(versions)-1].ts > txn.ts {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// install adds a version for every key written by the transaction. Keys written for the first time
+// get their current state in the table as the initial version
+func (c *mvccControl) install(commitTs int64, tables map[uint64][]LogEntry) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for tableId, entries := range tables {
+		if _, ok := c.versions[tableId]; !ok {
+			c.versions[tableId] = make(map[interface{}][]version)
+		}
+		keys := c.versions[tableId]
+		for _, entry := range entries {
+			versions, ok := keys[entry.Key]
+			if !ok {
+				initial, err := c.currentVersion(tableId, entry.Key)
+				if err != nil {
+					return err
+				}
+				versions = []version{initial}
+			}
+			v := version{ts: commitTs, value: entry.Value, deleted: entry.Op == LDelete}
+			// Later entries of the same key replace the version of this commit
+			if versions[len(versions)-1].ts == commitTs {
+				versions[len(versions)-1] = v
+			} else {
+				versions = append(versions, v)
+			}
+			keys[entry.Key] = versions
+		}
+	}
+	return nil
+}
+
+func (c *mvccControl) currentVersion(tableId uint64, key interface{}) (version, error) {
+	table, ok := c.data.Get(tableId)
+	if !ok {
+		return version{}, fmt.Errorf("%w - %d", ErrTableNotFound, tableId)
+	}
+	value, err := table.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return version{deleted: true}, nil
+	}
+	if err != nil {
+		return version{}, err
+	}
+	return version{value: value}, nil
+}
+
+func (c *mvccControl) uninstall(commitTs int64, tables map[uint64][]LogEntry) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for tableId, entries := range tables {
+		keys := c.versions[tableId]
+		for _, entry := range entries {
+			versions, ok := keys[entry.Key]
+			if !ok {
+				continue
+			}
+			if versions[len(versions)-1].ts == commitTs {
+				versions = versions[:len(versions)-1]
+			}
+			// The initial version alone is the state of the table
+			if len(versions) <= 1 {
+				delete(keys, entry.Key)
+			} else {
+				keys[entry.Key] = versions
+			}
+		}
+	}
+}
+
+func (c *mvccControl) markUnapplied(commitTs int64, tables map[uint64][]LogEntry) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for tableId, entries := range tables {
+		for _, entry := range entries {
+			versions := c.versions[tableId][entry.Key]
+			if len(versions) > 0 && versions[len(versions)-1].ts == commitTs {
+				versions[len(versions)-1].unapplied = true
+			}
+		}
+	}
+}
+
+// prune drops the versions of the keys written by a commit that no running transaction can see
+func (c *mvccControl) prune(tables map[uint64][]LogEntry, oldest int64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for tableId, entries := range tables {
+		keys := c.versions[tableId]
+		for _, entry := range entries {
+			if versions, ok := keys[entry.Key]; ok {
+				pruneKey(keys, entry.Key, versions, oldest)
+			}
+		}
+	}
+}
+
+// pruneAll drops the versions of every key that no running transaction can see
+func (c *mvccControl) pruneAll(oldest int64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for tableId, keys := range c.versions {
+		for key, versions := range keys {
+			pruneKey(keys, key, versions, oldest)
+		}
+		if len(keys) == 0 {
+			delete(c.versions, tableId)
+		}
+	}
+}
+
+// pruneKey keeps the versions newer than the oldest snapshot, and the one it sees. A key left with a single
+// version is read from the table again if the table has the same state. Requires m
+func pruneKey(keys map[interface{}][]version, key interface{}, versions []version, oldest int64) {
+	first := 0
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].ts <= oldest {
+			first = i
+			break
+		}
+	}
+	versions = versions[first:]
+	if len(versions) == 1 && !versions[0].unapplied {
+		delete(keys, key)
+	} else {
+		keys[key] = versions
+	}
+}
+
+// end prunes the versions against the oldest snapshot left running, so keys written while the transaction
+// was running do not keep their versions after it is gone
+func (c *mvccControl) end(txn *TransactInfo) error {
+	c.clock.end(txn.tId)
+	c.pruneAll(c.clock.oldest())
+	return nil
+}
@@ -0,0 +1,105 @@
+package asyncdb
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMVCC_Get_Should_Read_Snapshot(t *testing.T) {
+	db, reader := newSeededTestDB(t, WithMVCC())
+	writer, _ := db.Connect()
+	_ = db.BeginTransaction(reader)
+	assert.Equal(t, 10, (<-db.Get(reader, "test", 1)).Data)
+
+	// The writer is not blocked by the reader
+	_ = db.BeginTransaction(writer)
+	assert.Nil(t, (<-db.Put(writer, "test", 1, 11)).Err)
+	assert.Nil(t, (<-db.Delete(writer, "test", 2)).Err)
+	assert.Nil(t, db.CommitTransaction(writer))
+
+	res := <-db.Get(reader, "test", 1)
+	assert.Nil(t, res.Err)
+	assert.Equal(t, 10, res.Data)
+	res = <-db.Get(reader, "test", 2)
+	assert.Nil(t, res.Err)
+	assert.Equal(t, 20, res.Data)
+	assert.Nil(t, db.CommitTransaction(reader))
+
+	res = <-db.Get(reader, "test", 1)
+	assert.Equal(t, 11, res.Data)
+	res = <-db.Get(reader, "test", 2)
+	assert.ErrorIs(t, res.Err, ErrKeyNotFound)
+}
+
+func TestMVCC_Scan_Should_Read_Snapshot(t *testing.T) {
+	db, reader := newSeededTestDB(t, WithMVCC())
+	writer, _ := db.Connect()
+	_ = db.BeginTransaction(reader)
+	_ = db.BeginTransaction(writer)
+	<-db.Put(writer, "test", 4, 40)
+	<-db.Delete(writer, "test", 2)
+	assert.Nil(t, db.CommitTransaction(writer))
+
+	rows := make([]KeyValue, 0)
+	for res := range db.Scan(reader, "test", nil, nil, 0) {
+		assert.Nil(t, res.Err)
+		rows = append(rows, res.Data.(KeyValue))
+	}
+	assert.Equal(t, []KeyValue{{1, 10}, {2, 20}, {3, 30}}, rows)
+	assert.Nil(t, db.CommitTransaction(reader))
+
+	rows = rows[:0]
+	for res := range db.Scan(reader, "test", nil, nil, 2) {
+		rows = append(rows, res.Data.(KeyValue))
+	}
+	assert.Equal(t, []KeyValue{{1, 10}, {3, 30}}, rows)
+}
+
+func TestMVCC_First_Committer_Should_Win(t *testing.T) {
+	db, first := newSeededTestDB(t, WithMVCC())
+	second, _ := db.Connect()
+	_ = db.BeginTransaction(first)
+	_ = db.BeginTransaction(second)
+	<-db.Put(first, "test", 1, 11)
+	<-db.Put(second, "test", 1, 12)
+	assert.Nil(t, db.CommitTransaction(first))
+	err := db.CommitTransaction(second)
+	assert.ErrorIs(t, err, ErrXactAborted)
+	assert.ErrorIs(t, err, ErrCommitFailed)
+	assert.Equal(t, 11, (<-db.Get(first, "test", 1)).Data)
+}
+
+func TestMVCC_Should_Drop_Versions_Not_Visible_To_Any_Transaction(t *testing.T) {
+	db, reader := newSeededTestDB(t, WithMVCC())
+	cc := db.cc.(*mvccControl)
+	_ = db.BeginTransaction(reader)
+	<-db.Put(reader, "test", 3, 31)
+	// The implicit transactions keep versions of key 1 for the snapshot of the reader
+	writer, _ := db.Connect()
+	<-db.Put(writer, "test", 1, 11)
+	<-db.Put(writer, "test", 1, 12)
+	assert.Len(t, cc.versions[db.hasher.HashStringUint64("test")][1], 3)
+	assert.Nil(t, db.CommitTransaction(reader))
+	<-db.Put(reader, "test", 1, 13)
+	assert.Empty(t, cc.versions[db.hasher.HashStringUint64("test")])
+	assert.Equal(t, 13, (<-db.Get(reader, "test", 1)).Data)
+}
+
+func TestMVCC_Should_Drop_All_Versions_When_Transactions_End(t *testing.T) {
+	db, reader := newSeededTestDB(t, WithMVCC())
+	cc := db.cc.(*mvccControl)
+	_ = db.BeginTransaction(reader)
+	writer, _ := db.Connect()
+	for i := 1; i <= 3; i++ {
+		<-db.Put(writer, "test", 1, 10+i)
+		<-db.Delete(writer, "test", 2)
+		<-db.Put(writer, "test", 2, 20+i)
+	}
+	assert.NotEmpty(t, cc.versions)
+	// Ending the reader drops the versions kept for it, without waiting for the keys to be written again
+	assert.Nil(t, db.RollbackTransaction(reader))
+	assert.Empty(t, cc.versions)
+	assert.Equal(t, 13, (<-db.Get(reader, "test", 1)).Data)
+	assert.Equal(t, 23, (<-db.Get(reader, "test", 2)).Data)
+	assert.Empty(t, cc.versions)
+}
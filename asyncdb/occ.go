@@ -0,0 +1,176 @@
+package asyncdb
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+type readKey struct {
+	tableId uint64
+	key     interface{}
+}
+
+type readRange struct {
+	tableId  uint64
+	keyRange KeyRange
+}
+
+// readSet is what a transaction has read: the versions of the keys and the scanned ranges.
+// Operations of a transaction run concurrently, so it is guarded by m
+type readSet struct {
+	keys   map[readKey]int64
+	ranges []readRange
+	m      *sync.Mutex
+}
+
+func newReadSet() *readSet {
+	return &readSet{
+		keys:   make(map[readKey]int64),
+		ranges: make([]readRange, 0),
+		m:      &sync.Mutex{},
+	}
+}
+
+// occControl is optimistic concurrency control: transactions read and write without waiting for each other,
+// and at commit a transaction is validated against the transactions committed since it started.
+// If anything it has read was overwritten in the meantime, it aborts with ErrXactAborted
+type occControl struct {
+	// versions maps the keys to the timestamp of the last commit that wrote them. Keys not written since
+	// the oldest running transaction started are dropped, as no read set can have an older version of them
+	versions map[uint64]map[interface{}]int64
+	m        *sync.RWMutex
+	// commitMu serializes validation and write phases of commits
+	commitMu *sync.Mutex
+	clock    *transactionClock
+	reads    *ThreadSafeMap[TransactId, *readSet]
+}
+
+// WithOCC replaces locking with optimistic concurrency control. The lock manager is not used
+func WithOCC() func(*AsyncDB) {
+	return func(db *AsyncDB) {
+		db.cc = newOCCControl()
+	}
+}
+
+func newOCCControl() *occControl {
+	return &occControl{
+		versions: make(map[uint64]map[interface{}]int64),
+		m:        &sync.RWMutex{},
+		commitMu: &sync.Mutex{},
+		clock:    newTransactionClock(),
+		reads:    NewThreadSafeMap[TransactId, *readSet](),
+	}
+}
+
+func (c *occControl) begin(txn *TransactInfo) {
+	c.clock.begin(txn)
+	c.reads.Put(txn.tId, newReadSet())
+}
+
+func (c *occControl) readSet(txn *TransactInfo) (*readSet, error) {
+	reads, ok := c.reads.Get(txn.tId)
+	if !ok {
+		return nil, ErrXactInTerminalState
+	}
+	return reads, nil
+}
+
+// read records the version before reading the table. If a commit writes the key in between,
+// the version changes, and the transaction fails validation
+func (c *occControl) read(ctx context.Context, txn *TransactInfo, tableId uint64, table Table, key interface{}) (interface{}, error) {
+	reads, err := c.readSet(txn)
+	if err != nil {
+		return nil, err
+	}
+	c.m.RLock()
+	v := c.versions[tableId][key]
+	c.m.RUnlock()
+	reads.m.Lock()
+	if _, ok := reads.keys[readKey{tableId, key}]; !ok {
+		reads.keys[readKey{tableId, key}] = v
+	}
+	reads.m.Unlock()
+	return getContext(ctx, table, key)
+}
+
+// scan records the range, so that keys inserted into it or deleted from it are detected at validation
+func (c *occControl) scan(ctx context.Context, txn *TransactInfo, tableId uint64, table OrderedTable, from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	reads, err := c.readSet(txn)
+	if err != nil {
+		return nil, err
+	}
+	reads.m.Lock()
+	reads.ranges = append(reads.ranges, readRange{tableId, KeyRange{From: from, To: to, Compare: table.CompareKeys}})
+	reads.m.Unlock()
+	return scanContext(ctx, table, from, to, limit)
+}
+
+func (c *occControl) write(_ context.Context, _ *TransactInfo, _ uint64, _ interface{}) error {
+	return nil
+}
+
+func (c *occControl) commit(txn *TransactInfo, tables map[uint64][]LogEntry, apply func() error) error {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+	reads, err := c.readSet(txn)
+	if err != nil {
+		return err
+	}
+	if !c.validate(txn, reads) {
+		return ErrXactAborted
+	}
+	commitTs := c.clock.next()
+	err = apply()
+	if err != nil && !errors.Is(err, ErrCommitNotApplied) {
+		return err
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	for tableId, entries := range tables {
+		if _, ok := c.versions[tableId]; !ok {
+			c.versions[tableId] = make(map[interface{}]int64)
+		}
+		for _, entry := range entries {
+			c.versions[tableId][entry.Key] = commitTs
+		}
+	}
+	oldest := c.clock.publish(commitTs, txn.tId)
+	for _, keys := range c.versions {
+		for key, v := range keys {
+			if v <= oldest {
+				delete(keys, key)
+			}
+		}
+	}
+	return err
+}
+
+// validate checks that no key read by the transaction was written after it was read, and that no key
+// in a scanned range was written after the transaction started. Requires commitMu
+func (c *occControl) validate(txn *TransactInfo, reads *readSet) bool {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	reads.m.Lock()
+	defer reads.m.Unlock()
+	for k, v := range reads.keys {
+		// A dropped key was not written since the oldest transaction started, so its version is not newer
+		if c.versions[k.tableId][k.key] > v {
+			return false
+		}
+	}
+	for _, r := range reads.ranges {
+		for key, v := range c.versions[r.tableId] {
+			if v > txn.ts && r.keyRange.contains(key) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *occControl) end(txn *TransactInfo) error {
+	c.clock.end(txn.tId)
+	c.reads.Delete(txn.tId)
+	return nil
+}
@@ -0,0 +1,69 @@
+package asyncdb
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOCC_Commit_Should_Abort_When_Read_Key_Changed(t *testing.T) {
+	db, reader := newSeededTestDB(t, WithOCC())
+	writer, _ := db.Connect()
+	_ = db.BeginTransaction(reader)
+	_ = db.BeginTransaction(writer)
+	assert.Equal(t, 10, (<-db.Get(reader, "test", 1)).Data)
+	assert.Nil(t, (<-db.Put(writer, "test", 1, 11)).Err)
+	assert.Nil(t, db.CommitTransaction(writer))
+	<-db.Put(reader, "test", 2, 21)
+	err := db.CommitTransaction(reader)
+	assert.ErrorIs(t, err, ErrXactAborted)
+	assert.Equal(t, 20, (<-db.Get(reader, "test", 2)).Data)
+}
+
+func TestOCC_Commit_Should_Succeed_When_Reads_Are_Unchanged(t *testing.T) {
+	db, first := newSeededTestDB(t, WithOCC())
+	second, _ := db.Connect()
+	_ = db.BeginTransaction(first)
+	_ = db.BeginTransaction(second)
+	// Neither transaction waits for the other one
+	<-db.Get(first, "test", 1)
+	<-db.Put(first, "test", 2, 21)
+	<-db.Get(second, "test", 3)
+	<-db.Put(second, "test", 2, 22)
+	assert.Nil(t, db.CommitTransaction(first))
+	// Blind writes do not conflict
+	assert.Nil(t, db.CommitTransaction(second))
+	assert.Equal(t, 22, (<-db.Get(first, "test", 2)).Data)
+}
+
+func TestOCC_Commit_Should_Abort_When_Scanned_Range_Changed(t *testing.T) {
+	db, reader := newSeededTestDB(t, WithOCC())
+	writer, _ := db.Connect()
+	_ = db.BeginTransaction(reader)
+	for range db.Scan(reader, "test", 1, 5, 0) {
+	}
+	<-db.Put(writer, "test", 4, 40)
+	<-db.Put(reader, "test", 6, 60)
+	assert.ErrorIs(t, db.CommitTransaction(reader), ErrXactAborted)
+
+	// Writes outside of the range are fine
+	_ = db.BeginTransaction(reader)
+	for range db.Scan(reader, "test", 1, 5, 0) {
+	}
+	<-db.Put(writer, "test", 7, 70)
+	assert.Nil(t, db.CommitTransaction(reader))
+}
+
+func TestOCC_Should_Drop_Versions_Not_Needed_For_Validation(t *testing.T) {
+	db, reader := newSeededTestDB(t, WithOCC())
+	cc := db.cc.(*occControl)
+	hash := db.hasher.HashStringUint64("test")
+	assert.Empty(t, cc.versions[hash])
+	_ = db.BeginTransaction(reader)
+	<-db.Get(reader, "test", 1)
+	writer, _ := db.Connect()
+	<-db.Put(writer, "test", 1, 11)
+	assert.Len(t, cc.versions[hash], 1)
+	assert.ErrorIs(t, db.CommitTransaction(reader), ErrXactAborted)
+	<-db.Put(writer, "test", 2, 21)
+	assert.Empty(t, cc.versions[hash])
+}
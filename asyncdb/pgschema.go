@@ -0,0 +1,392 @@
+package asyncdb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"reflect"
+	"strings"
+)
+
+var ErrUnsupportedColumnType = errors.New("unsupported column type")
+
+// pgColumn is a column of a PgTable. keyIndex and valueIndex locate the column in the key and the value.
+// The index is empty if the key or the value is not a struct and is the column itself, and nil if the column
+// is not a part of it
+type pgColumn struct {
+	name       string
+	sqlType    string
+	keyIndex   []int
+	valueIndex []int
+}
+
+// pgSchema maps keys and values of Go types to the columns of a PgTable. The key columns come first,
+// and form the primary key. Struct fields are mapped to columns named by their db tags, a field of the value
+// with the name of a key column is stored in the key column. A schema with stringify stores keys in a VARCHAR
+// column formatted with %v. Its values are encoded by the codec, or formatted with %v and read back as strings
+// without one
+type pgSchema struct {
+	keyType   reflect.Type
+	valueType reflect.Type
+	columns   []pgColumn
+	keyCount  int
+	stringify bool
+	codec     Codec
+}
+
+func newStringPgSchema() *pgSchema {
+	return &pgSchema{
+		keyType:   reflect.TypeFor[string](),
+		valueType: reflect.TypeFor[string](),
+		columns: []pgColumn{
+			{name: "key", sqlType: "VARCHAR(500)", keyIndex: []int{}},
+			{name: "value", sqlType: "VARCHAR(500)", valueIndex: []int{}},
+		},
+		keyCount:  1,
+		stringify: true,
+	}
+}
+
+func newCodecPgSchema(codec Codec) *pgSchema {
+	s := newStringPgSchema()
+	s.valueType = codec.ValueType()
+	s.codec = codec
+	s.columns[1].sqlType = "BYTEA"
+	if codec.Name() == "json" {
+		s.columns[1].sqlType = "JSONB"
+	}
+	return s
+}
+
+func newPgSchema(keyType reflect.Type, valueType reflect.Type) (*pgSchema, error) {
+	s := &pgSchema{keyType: keyType, valueType: valueType}
+	keyColumns, err := structColumns(keyType, "key")
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range keyColumns {
+		s.columns = append(s.columns, pgColumn{name: c.name, sqlType: c.sqlType, keyIndex: c.valueIndex})
+	}
+	s.keyCount = len(s.columns)
+	valueColumns, err := structColumns(valueType, "value")
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range valueColumns {
+		i := s.columnIndex(c.name)
+		if i < 0 {
+			s.columns = append(s.columns, c)
+			continue
+		}
+		if s.columns[i].sqlType != c.sqlType {
+			return nil, fmt.Errorf("%w: column %s is %s in the key and %s in the value", ErrTypeMismatch, c.name, s.columns[i].sqlType, c.sqlType)
+		}
+		s.columns[i].valueIndex = c.valueIndex
+	}
+	return s, nil
+}
+
+// structColumns returns a column for every exported field of a struct, or a single column with the name
+// for other types. The indexes of the columns are stored in valueIndex
+func structColumns(t reflect.Type, name string) ([]pgColumn, error) {
+	if t.Kind() != reflect.Struct || t == timeType {
+		sqlType, err := pgType(t)
+		if err != nil {
+			return nil, fmt.Errorf("%w - %s", err, name)
+		}
+		return []pgColumn{{name: name, sqlType: sqlType, valueIndex: []int{}}}, nil
+	}
+	columns := make([]pgColumn, 0, t.NumField())
+	for _, field := range reflect.VisibleFields(t) {
+		tag := field.Tag.Get("db")
+		if !field.IsExported() || field.Anonymous || tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		sqlType, err := pgType(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%w - %s.%s", err, t.Name(), field.Name)
+		}
+		columns = append(columns, pgColumn{name: tag, sqlType: sqlType, valueIndex: field.Index})
+	}
+	return columns, nil
+}
+
+// pgType returns the column type of a Go type. Text is compared byte by byte, like CompareKeys does
+func pgType(t reflect.Type) (string, error) {
+	if t == timeType {
+		return "TIMESTAMPTZ", nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "BOOLEAN", nil
+	case reflect.Int16, reflect.Int8, reflect.Uint8:
+		return "SMALLINT", nil
+	case reflect.Int32, reflect.Uint16:
+		return "INTEGER", nil
+	case reflect.Int, reflect.Int64, reflect.Uint32:
+		return "BIGINT", nil
+	case reflect.Float32:
+		return "REAL", nil
+	case reflect.Float64:
+		return "DOUBLE PRECISION", nil
+	case reflect.String:
+		return "TEXT COLLATE \"C\"", nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "BYTEA", nil
+		}
+	}
+	return "", fmt.Errorf("%w: %v", ErrUnsupportedColumnType, t)
+}
+
+// comment returns the comment recording the schema with the table, empty for string tables
+func (s *pgSchema) comment() string {
+	switch {
+	case s.codec != nil:
+		return pgCodecComment + s.codec.Name()
+	case !s.stringify:
+		return fmt.Sprintf("%s%v, %v", pgTypedComment, s.keyType, s.valueType)
+	}
+	return ""
+}
+
+func (s *pgSchema) columnIndex(name string) int {
+	for i, c := range s.columns {
+		if c.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func quoteColumns(columns []pgColumn) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = pgx.Identifier{c.name}.Sanitize()
+	}
+	return names
+}
+
+// placeholders returns the parameters $from+1 to $from+count
+func placeholders(from int, count int) []string {
+	params := make([]string, count)
+	for i := range params {
+		params[i] = fmt.Sprintf("$%d", from+i+1)
+	}
+	return params
+}
+
+func (s *pgSchema) keyColumns() []pgColumn {
+	return s.columns[:s.keyCount]
+}
+
+// valueColumns returns the columns read into the value
+func (s *pgSchema) valueColumns() []pgColumn {
+	columns := make([]pgColumn, 0, len(s.columns))
+	for _, c := range s.columns {
+		if c.valueIndex != nil {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+func (s *pgSchema) createQuery(table string) string {
+	definitions := make([]string, 0, len(s.columns)+1)
+	for i, name := range quoteColumns(s.columns) {
+		definitions = append(definitions, fmt.Sprintf("%s %s", name, s.columns[i].sqlType))
+	}
+	definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(quoteColumns(s.keyColumns()), ", ")))
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(definitions, ", "))
+}
+
+// keyCondition matches the key passed in the first parameters
+func (s *pgSchema) keyCondition() string {
+	conditions := make([]string, s.keyCount)
+	for i, name := range quoteColumns(s.keyColumns()) {
+		conditions[i] = fmt.Sprintf("%s = $%d", name, i+1)
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+func (s *pgSchema) getQuery(table string) string {
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(quoteColumns(s.valueColumns()), ", "), table, s.keyCondition())
+}
+
+func (s *pgSchema) putQuery(table string) string {
+	names := quoteColumns(s.columns)
+	updates := make([]string, 0, len(names))
+	for _, name := range names[s.keyCount:] {
+		updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", name, name))
+	}
+	onConflict := "DO NOTHING"
+	if len(updates) > 0 {
+		onConflict = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) %s", table, strings.Join(names, ", "),
+		strings.Join(placeholders(0, len(names)), ", "), strings.Join(names[:s.keyCount], ", "), onConflict)
+}
+
+func (s *pgSchema) deleteQuery(table string) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", table, s.keyCondition())
+}
+
+// keyExpression is compared with the bounds of scans. Composite keys are compared as rows, field by field
+func (s *pgSchema) keyExpression() string {
+	if s.stringify {
+		return "key COLLATE \"C\""
+	}
+	names := quoteColumns(s.keyColumns())
+	if len(names) == 1 {
+		return names[0]
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+// keyParameters returns the parameters of a bound of a scan, starting after the first from parameters
+func (s *pgSchema) keyParameters(from int) string {
+	params := placeholders(from, s.keyCount)
+	if len(params) == 1 {
+		return params[0]
+	}
+	return "(" + strings.Join(params, ", ") + ")"
+}
+
+func (s *pgSchema) scanQuery(table string, from bool, to bool, limit bool) string {
+	conditions := make([]string, 0, 2)
+	params := 0
+	if from {
+		conditions = append(conditions, fmt.Sprintf("%s >= %s", s.keyExpression(), s.keyParameters(params)))
+		params += s.keyCount
+	}
+	if to {
+		conditions = append(conditions, fmt.Sprintf("%s <= %s", s.keyExpression(), s.keyParameters(params)))
+		params += s.keyCount
+	}
+	columns := append(quoteColumns(s.keyColumns()), quoteColumns(s.valueColumns())...)
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), table)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	order := s.keyExpression()
+	if !s.stringify {
+		order = strings.Join(quoteColumns(s.keyColumns()), ", ")
+	}
+	query += " ORDER BY " + order
+	if limit {
+		query += fmt.Sprintf(" LIMIT $%d", params+1)
+	}
+	return query
+}
+
+func (s *pgSchema) validate(key interface{}, value interface{}) error {
+	if s.stringify {
+		if s.codec != nil && value != nil && !reflect.TypeOf(value).AssignableTo(s.valueType) {
+			return fmt.Errorf("%w: expected value type - %v, got - %T", ErrTypeMismatch, s.valueType, value)
+		}
+		return nil
+	}
+	if reflect.TypeOf(key) != s.keyType {
+		return fmt.Errorf("%w: expected key type - %v, got - %T", ErrTypeMismatch, s.keyType, key)
+	}
+	if value != nil && reflect.TypeOf(value) != s.valueType {
+		return fmt.Errorf("%w: expected value type - %v, got - %T", ErrTypeMismatch, s.valueType, value)
+	}
+	return nil
+}
+
+// keyArgs returns the values of the key columns
+func (s *pgSchema) keyArgs(key interface{}) ([]interface{}, error) {
+	if s.stringify {
+		return []interface{}{fmt.Sprintf("%v", key)}, nil
+	}
+	if err := s.validate(key, nil); err != nil {
+		return nil, err
+	}
+	k := reflect.ValueOf(key)
+	args := make([]interface{}, s.keyCount)
+	for i, c := range s.keyColumns() {
+		args[i] = fieldByIndex(k, c.keyIndex).Interface()
+	}
+	return args, nil
+}
+
+// rowArgs returns the values of all columns, in the order of putQuery
+func (s *pgSchema) rowArgs(key interface{}, value interface{}) ([]interface{}, error) {
+	if err := s.validate(key, value); err != nil {
+		return nil, err
+	}
+	// validate accepts nil values to check keys alone, but only string tables can store them
+	if value == nil && !(s.stringify && s.codec == nil) {
+		return nil, fmt.Errorf("%w: expected value type - %v, got - nil", ErrTypeMismatch, s.valueType)
+	}
+	if s.codec != nil {
+		data, err := s.codec.Encode(value)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{fmt.Sprintf("%v", key), data}, nil
+	}
+	if s.stringify {
+		return []interface{}{fmt.Sprintf("%v", key), fmt.Sprintf("%v", value)}, nil
+	}
+	k, v := reflect.ValueOf(key), reflect.ValueOf(value)
+	args := make([]interface{}, len(s.columns))
+	for i, c := range s.columns {
+		if c.keyIndex != nil {
+			args[i] = fieldByIndex(k, c.keyIndex).Interface()
+		} else {
+			args[i] = fieldByIndex(v, c.valueIndex).Interface()
+		}
+	}
+	return args, nil
+}
+
+// valueDest returns the scan destinations of the value columns, and the function returning the value
+// once they are scanned
+func (s *pgSchema) valueDest() ([]interface{}, func() (interface{}, error)) {
+	if s.codec != nil {
+		var data []byte
+		return []interface{}{&data}, func() (interface{}, error) { return s.codec.Decode(data) }
+	}
+	dest, decode := columnDest(s.valueType, s.valueColumns(), func(c pgColumn) []int { return c.valueIndex })
+	return dest, func() (interface{}, error) { return decode(), nil }
+}
+
+func (s *pgSchema) keyDest() ([]interface{}, func() interface{}) {
+	return columnDest(s.keyType, s.keyColumns(), func(c pgColumn) []int { return c.keyIndex })
+}
+
+func columnDest(t reflect.Type, columns []pgColumn, index func(c pgColumn) []int) ([]interface{}, func() interface{}) {
+	v := reflect.New(t).Elem()
+	dest := make([]interface{}, len(columns))
+	for i, c := range columns {
+		dest[i] = fieldByIndex(v, index(c)).Addr().Interface()
+	}
+	return dest, func() interface{} { return v.Interface() }
+}
+
+// compareKeys orders keys like the ORDER BY of scanQuery
+func (s *pgSchema) compareKeys(a interface{}, b interface{}) int {
+	if s.stringify {
+		return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for _, c := range s.keyColumns() {
+		if res := compareValues(fieldByIndex(va, c.keyIndex), fieldByIndex(vb, c.keyIndex)); res != 0 {
+			return res
+		}
+	}
+	return 0
+}
+
+// fieldByIndex returns the field of a struct, or the value itself for an empty index
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	if len(index) == 0 {
+		return v
+	}
+	return v.FieldByIndex(index)
+}
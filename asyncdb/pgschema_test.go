@@ -0,0 +1,195 @@
+package asyncdb
+
+import (
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type pgTestKey struct {
+	WarehouseId int    `db:"W_ID"`
+	DistrictId  int    `db:"D_ID"`
+	Region      string `db:"REGION"`
+}
+
+type pgTestRow struct {
+	WarehouseId int       `db:"W_ID"`
+	DistrictId  int       `db:"D_ID"`
+	Name        string    `db:"NAME"`
+	Balance     float64   `db:"BALANCE"`
+	Since       time.Time `db:"SINCE"`
+	Data        []byte
+	Ignored     string `db:"-"`
+	unexported  int
+}
+
+func newPgTestSchema(t *testing.T) *pgSchema {
+	schema, err := newPgSchema(reflect.TypeFor[pgTestKey](), reflect.TypeFor[pgTestRow]())
+	assert.Nil(t, err)
+	return schema
+}
+
+func TestPgSchema_Should_Map_Fields_To_Columns(t *testing.T) {
+	schema := newPgTestSchema(t)
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS test ("W_ID" BIGINT, "D_ID" BIGINT, "REGION" TEXT COLLATE "C", `+
+		`"NAME" TEXT COLLATE "C", "BALANCE" DOUBLE PRECISION, "SINCE" TIMESTAMPTZ, "Data" BYTEA, `+
+		`PRIMARY KEY ("W_ID", "D_ID", "REGION"))`, schema.createQuery("test"))
+	assert.Equal(t, `SELECT "W_ID", "D_ID", "NAME", "BALANCE", "SINCE", "Data" FROM test WHERE "W_ID" = $1 AND "D_ID" = $2 AND "REGION" = $3`,
+		schema.getQuery("test"))
+	assert.Equal(t, `INSERT INTO test ("W_ID", "D_ID", "REGION", "NAME", "BALANCE", "SINCE", "Data") VALUES ($1, $2, $3, $4, $5, $6, $7) `+
+		`ON CONFLICT ("W_ID", "D_ID", "REGION") DO UPDATE SET "NAME" = EXCLUDED."NAME", "BALANCE" = EXCLUDED."BALANCE", `+
+		`"SINCE" = EXCLUDED."SINCE", "Data" = EXCLUDED."Data"`, schema.putQuery("test"))
+	assert.Equal(t, `SELECT "W_ID", "D_ID", "REGION", "W_ID", "D_ID", "NAME", "BALANCE", "SINCE", "Data" FROM test `+
+		`WHERE ("W_ID", "D_ID", "REGION") >= ($1, $2, $3) AND ("W_ID", "D_ID", "REGION") <= ($4, $5, $6) ORDER BY "W_ID", "D_ID", "REGION" LIMIT $7`,
+		schema.scanQuery("test", true, true, true))
+}
+
+func TestPgSchema_Should_Map_Scalars_To_Key_And_Value(t *testing.T) {
+	schema, err := newPgSchema(reflect.TypeFor[int](), reflect.TypeFor[string]())
+	assert.Nil(t, err)
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS test ("key" BIGINT, "value" TEXT COLLATE "C", PRIMARY KEY ("key"))`, schema.createQuery("test"))
+	assert.Equal(t, `SELECT "key", "value" FROM test WHERE "key" <= $1 ORDER BY "key"`, schema.scanQuery("test", false, true, false))
+	args, err := schema.rowArgs(1, "one")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{1, "one"}, args)
+}
+
+func TestPgSchema_Should_Take_Shared_Columns_From_Key(t *testing.T) {
+	schema := newPgTestSchema(t)
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := pgTestKey{WarehouseId: 1, DistrictId: 2, Region: "north"}
+	args, err := schema.rowArgs(key, pgTestRow{WarehouseId: 5, Name: "name", Balance: 1.5, Since: since, Data: []byte{1}})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{1, 2, "north", "name", 1.5, since, []byte{1}}, args)
+	args, err = schema.keyArgs(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{1, 2, "north"}, args)
+}
+
+func TestPgSchema_Should_Decode_Rows(t *testing.T) {
+	schema := newPgTestSchema(t)
+	dest, decode := schema.valueDest()
+	assert.Len(t, dest, 6)
+	*dest[0].(*int) = 1
+	*dest[2].(*string) = "name"
+	*dest[5].(*[]byte) = []byte{1}
+	value, err := decode()
+	assert.Nil(t, err)
+	assert.Equal(t, pgTestRow{WarehouseId: 1, Name: "name", Data: []byte{1}}, value)
+	keyDest, decodeKey := schema.keyDest()
+	*keyDest[2].(*string) = "north"
+	assert.Equal(t, pgTestKey{Region: "north"}, decodeKey())
+}
+
+func TestPgSchema_Codec_Should_Encode_Values(t *testing.T) {
+	for _, codec := range []Codec{NewJSONCodec[pgTestRow](), NewGobCodec[pgTestRow]()} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			schema := newCodecPgSchema(codec)
+			row := pgTestRow{WarehouseId: 1, Name: "name", Since: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Data: []byte{1}}
+			args, err := schema.rowArgs(1, row)
+			assert.Nil(t, err)
+			assert.Equal(t, "1", args[0])
+			dest, decode := schema.valueDest()
+			*dest[0].(*[]byte) = args[1].([]byte)
+			value, err := decode()
+			assert.Nil(t, err)
+			assert.Equal(t, row, value)
+			assert.ErrorIs(t, schema.validate(1, "value"), ErrTypeMismatch)
+			assert.Nil(t, schema.validate(1, nil))
+			_, err = schema.rowArgs(1, nil)
+			assert.ErrorIs(t, err, ErrTypeMismatch)
+		})
+	}
+	assert.Contains(t, newCodecPgSchema(NewJSONCodec[int]()).createQuery("test"), `"value" JSONB`)
+	assert.Contains(t, newCodecPgSchema(NewGobCodec[int]()).createQuery("test"), `"value" BYTEA`)
+}
+
+func TestPgSchema_Should_Compare_Keys_By_Columns(t *testing.T) {
+	schema := newPgTestSchema(t)
+	assert.Equal(t, -1, schema.compareKeys(pgTestKey{1, 2, "b"}, pgTestKey{1, 10, "a"}))
+	assert.Equal(t, 1, schema.compareKeys(pgTestKey{1, 2, "b"}, pgTestKey{1, 2, "a"}))
+	assert.Equal(t, 0, schema.compareKeys(pgTestKey{1, 2, "a"}, pgTestKey{1, 2, "a"}))
+	// Tables created with GetTable compare the stored strings
+	assert.Equal(t, -1, newStringPgSchema().compareKeys(10, 9))
+}
+
+func TestPgSchema_Should_Validate_Types(t *testing.T) {
+	schema := newPgTestSchema(t)
+	assert.Nil(t, schema.validate(pgTestKey{}, pgTestRow{}))
+	assert.Nil(t, schema.validate(pgTestKey{}, nil))
+	assert.ErrorIs(t, schema.validate(1, pgTestRow{}), ErrTypeMismatch)
+	assert.ErrorIs(t, schema.validate(pgTestKey{}, "value"), ErrTypeMismatch)
+	_, err := schema.keyArgs("key")
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+	assert.Nil(t, newStringPgSchema().validate(1, struct{}{}))
+}
+
+func TestPgSchema_Should_Reject_Unsupported_Types(t *testing.T) {
+	_, err := newPgSchema(reflect.TypeFor[int](), reflect.TypeFor[struct{ Tags []string }]())
+	assert.ErrorIs(t, err, ErrUnsupportedColumnType)
+	_, err = newPgSchema(reflect.TypeFor[map[string]int](), reflect.TypeFor[int]())
+	assert.ErrorIs(t, err, ErrUnsupportedColumnType)
+	// A column shared by the key and the value must have the same type
+	_, err = newPgSchema(reflect.TypeFor[pgTestKey](), reflect.TypeFor[struct {
+		Region int `db:"REGION"`
+	}]())
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+}
+
+func TestPgTable_Should_Batch_Entries_In_Order(t *testing.T) {
+	table := PgTable{name: "test", schema: newStringPgSchema()}
+	batch, err := table.batchEntries([]LogEntry{{Op: LPut, Key: 1, Value: 10}, {Op: LDelete, Key: 2}, {Op: LPut, Key: 2, Value: 20}})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, batch.Len())
+	assert.Equal(t, table.schema.putQuery("test"), batch.QueuedQueries[0].SQL)
+	assert.Equal(t, []interface{}{"1", "10"}, batch.QueuedQueries[0].Arguments)
+	assert.Equal(t, table.schema.deleteQuery("test"), batch.QueuedQueries[1].SQL)
+	assert.Equal(t, []interface{}{"2"}, batch.QueuedQueries[1].Arguments)
+	_, err = table.batchEntries([]LogEntry{{Op: 0, Key: 1}})
+	assert.ErrorIs(t, err, ErrInvalidLogEntry)
+}
+
+func TestPgSchema_Should_Record_Types_In_Comment(t *testing.T) {
+	schema := newPgTestSchema(t)
+	comment := schema.comment()
+	assert.Equal(t, "asyncdb typed: asyncdb.pgTestKey, asyncdb.pgTestRow", comment)
+	assert.Equal(t, "", newStringPgSchema().comment())
+	assert.Nil(t, checkSchemaComment("test", &comment, comment))
+	assert.ErrorIs(t, checkSchemaComment("test", nil, comment), ErrSchemaMismatch)
+	assert.ErrorIs(t, checkSchemaComment("test", &comment, ""), ErrSchemaMismatch)
+	// Comments not written by the factory do not change the schema
+	other := "orders of the shop"
+	assert.Nil(t, checkSchemaComment("test", &other, ""))
+}
+
+func TestPgTableFactory_Should_Open_Existing_Typed_Tables_With_Their_Schema(t *testing.T) {
+	factory := &PgTableFactory{schemas: NewThreadSafeMap[string, *pgSchema]()}
+	schema := newPgTestSchema(t)
+	comment := schema.comment()
+	_, err := factory.existingSchema("typed", &comment)
+	assert.ErrorIs(t, err, ErrSchemaUnknown)
+	factory.schemas.Put("typed", schema)
+	existing, err := factory.existingSchema("typed", &comment)
+	assert.Nil(t, err)
+	assert.Same(t, schema, existing)
+	existing, err = factory.existingSchema("strings", nil)
+	assert.Nil(t, err)
+	assert.True(t, existing.stringify)
+}
+
+func TestPgTableFactory_Should_Open_Existing_Codec_Tables_With_Their_Codec(t *testing.T) {
+	factory := &PgTableFactory{schemas: NewThreadSafeMap[string, *pgSchema]()}
+	schema := newCodecPgSchema(NewJSONCodec[pgTestRow]())
+	comment := schema.comment()
+	_, err := factory.existingSchema("rows", &comment)
+	assert.ErrorIs(t, err, ErrSchemaUnknown)
+	factory.schemas.Put("rows", schema)
+	existing, err := factory.existingSchema("rows", &comment)
+	assert.Nil(t, err)
+	assert.Same(t, schema, existing)
+	// A table opened with another codec does not match the recorded one
+	factory.schemas.Put("rows", newCodecPgSchema(NewGobCodec[pgTestRow]()))
+	_, err = factory.existingSchema("rows", &comment)
+	assert.ErrorIs(t, err, ErrCodecMismatch)
+}
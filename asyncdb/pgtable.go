@@ -4,32 +4,56 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"os"
+	"reflect"
+	"strings"
 	"time"
 )
 
+var ErrCodecMismatch = errors.New("table was created with a different codec")
+var ErrSchemaMismatch = errors.New("table was created with a different schema")
+var ErrSchemaUnknown = errors.New("table schema is not known to the factory")
+
+// pgCodecComment prefixes the codec name in the comment of tables using a codec
+const pgCodecComment = "asyncdb codec: "
+
+// pgTypedComment prefixes the key and value types in the comment of typed tables
+const pgTypedComment = "asyncdb typed: "
+
 type PgTableFactory struct {
 	pool *pgxpool.Pool
+	// twoPhase is set when the server allows prepared transactions
+	twoPhase bool
+	// schemas holds the schemas of the tables opened by the factory, by the name Postgres stores
+	schemas *ThreadSafeMap[string, *pgSchema]
 }
 
-// TODO: This should also return errors
 func NewPgTableFactory(connectionString string) (*PgTableFactory, error) {
 	config, err := pgxpool.ParseConfig(connectionString)
 	if err != nil {
-		fmt.Errorf("failed to parse connection string: %w", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to parse connection string: %w", err)
 	}
 	// TODO: Adjust MaxConns in Postgres
 	config.MaxConns = 100
 	conn, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection pool: %w", err)
+	}
 	err = conn.Ping(context.Background())
 	if err != nil {
-		fmt.Errorf("failed to connect to database: %w", err)
-		return nil, err
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	// Servers have prepared transactions disabled by default
+	var maxPrepared string
+	err = conn.QueryRow(context.Background(), "SHOW max_prepared_transactions").Scan(&maxPrepared)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to get max_prepared_transactions: %w", err)
 	}
-	return &PgTableFactory{pool: conn}, nil
+	return &PgTableFactory{pool: conn, twoPhase: maxPrepared != "0", schemas: NewThreadSafeMap[string, *pgSchema]()}, nil
 }
 
 func (f *PgTableFactory) Close() {
@@ -38,15 +62,95 @@ func (f *PgTableFactory) Close() {
 	}
 }
 
-func (f *PgTableFactory) GetTable(name string) (Table, error) {
+// GetTable creates a table storing the keys formatted as strings. Values are formatted as strings as well,
+// unless the table is given a codec
+func (f *PgTableFactory) GetTable(name string, options ...func(*PgTable)) (Table, error) {
+	table := &PgTable{schema: newStringPgSchema()}
+	for _, option := range options {
+		option(table)
+	}
+	return f.createTable(name, table.schema)
+}
+
+// WithCodec stores the values of the table encoded by the codec, and restricts them to the type of the codec.
+// The codec name is recorded in the comment of the table, and opening the table with another codec fails
+func WithCodec(codec Codec) func(*PgTable) {
+	return func(p *PgTable) {
+		p.schema = newCodecPgSchema(codec)
+	}
+}
+
+// GetTypedPgTable creates a table with a column for every field of the key and value types, named by the db tags
+// of the fields. The key fields form the primary key, and Get returns values of type V. Keys and values
+// that are not structs are stored in the columns key and value. The types are recorded in the comment
+// of the table, and opening the table with other types fails
+func GetTypedPgTable[K comparable, V any](f *PgTableFactory, name string) (*PgTable, error) {
+	schema, err := newPgSchema(reflect.TypeFor[K](), reflect.TypeFor[V]())
+	if err != nil {
+		return nil, err
+	}
+	return f.createTable(name, schema)
+}
+
+func (f *PgTableFactory) createTable(name string, schema *pgSchema) (*PgTable, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (key VARCHAR(500) PRIMARY KEY, value VARCHAR(500))", name)
-	_, err := f.pool.Exec(ctx, query)
+	tx, err := f.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create table: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	var exists bool
+	if err = tx.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to create table: %w", err)
+	}
+	if _, err = tx.Exec(ctx, schema.createQuery(name)); err != nil {
+		return nil, fmt.Errorf("failed to create table: %w", err)
+	}
+	comment := schema.comment()
+	if !exists && comment != "" {
+		_, err = tx.Exec(ctx, fmt.Sprintf("COMMENT ON TABLE %s IS '%s'", name, strings.ReplaceAll(comment, "'", "''")))
+	} else if exists {
+		var recorded *string
+		err = tx.QueryRow(ctx, "SELECT obj_description($1::regclass, 'pg_class')", name).Scan(&recorded)
+		if err == nil {
+			if err = checkSchemaComment(name, recorded, comment); err != nil {
+				return nil, err
+			}
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create table: %w", err)
 	}
-	return newPgTable(name, f.pool)
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create table: %w", err)
+	}
+	f.schemas.Put(strings.ToLower(name), schema)
+	return newPgTable(name, f.pool, schema, f.twoPhase), nil
+}
+
+// checkSchemaComment compares the comment recorded with an existing table to the comment of the schema
+// opening it. Tables without a comment written by a factory are string tables
+func checkSchemaComment(name string, recorded *string, expected string) error {
+	current := ""
+	if recorded != nil && isSchemaComment(*recorded) {
+		current = *recorded
+	}
+	switch {
+	case current == expected:
+		return nil
+	case strings.HasPrefix(current, pgCodecComment):
+		return fmt.Errorf("%w: %s uses %s", ErrCodecMismatch, name, strings.TrimPrefix(current, pgCodecComment))
+	case strings.HasPrefix(expected, pgCodecComment):
+		return fmt.Errorf("%w: %s does not use a codec", ErrCodecMismatch, name)
+	case current == "":
+		return fmt.Errorf("%w: %s stores strings", ErrSchemaMismatch, name)
+	}
+	return fmt.Errorf("%w: %s stores %s", ErrSchemaMismatch, name, strings.TrimPrefix(current, pgTypedComment))
+}
+
+func isSchemaComment(comment string) bool {
+	return strings.HasPrefix(comment, pgCodecComment) || strings.HasPrefix(comment, pgTypedComment)
 }
 
 func (f *PgTableFactory) DeleteTable(name string) error {
@@ -60,10 +164,13 @@ func (f *PgTableFactory) DeleteTable(name string) error {
 	return nil
 }
 
+// GetExistingTables opens the tables of the database. String tables are opened as they are. Typed tables and
+// tables with a codec have their schema recorded in the comment, and are opened with it only if the factory has
+// opened them before with GetTypedPgTable or WithCodec, otherwise it fails with ErrSchemaUnknown
 func (f *PgTableFactory) GetExistingTables() ([]Table, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	query := "SELECT table_name FROM information_schema.tables WHERE table_schema = 'public'"
+	query := "SELECT table_name, obj_description(quote_ident(table_name)::regclass, 'pg_class') FROM information_schema.tables WHERE table_schema = 'public'"
 	rows, err := f.pool.Query(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get existing tables: %w", err)
@@ -72,22 +179,40 @@ func (f *PgTableFactory) GetExistingTables() ([]Table, error) {
 	var tables []Table
 	for rows.Next() {
 		var tableName string
-		err = rows.Scan(&tableName)
+		var comment *string
+		err = rows.Scan(&tableName, &comment)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan table name: %w", err)
 		}
-		table, err := newPgTable(tableName, f.pool)
+		schema, err := f.existingSchema(tableName, comment)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create table: %w", err)
+			return nil, err
 		}
-		tables = append(tables, table)
+		tables = append(tables, newPgTable(tableName, f.pool, schema, f.twoPhase))
 	}
-	return tables, nil
+	return tables, rows.Err()
+}
+
+// existingSchema returns the schema recorded in the comment of the table
+func (f *PgTableFactory) existingSchema(name string, comment *string) (*pgSchema, error) {
+	if comment == nil || !isSchemaComment(*comment) {
+		return newStringPgSchema(), nil
+	}
+	schema, ok := f.schemas.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has comment %q, open it with GetTypedPgTable or WithCodec", ErrSchemaUnknown, name, *comment)
+	}
+	if err := checkSchemaComment(name, comment, schema.comment()); err != nil {
+		return nil, err
+	}
+	return schema, nil
 }
 
 type PgTable struct {
-	pool *pgxpool.Pool
-	name string
+	pool     *pgxpool.Pool
+	name     string
+	schema   *pgSchema
+	twoPhase bool
 }
 
 func (p PgTable) Name() string {
@@ -95,25 +220,32 @@ func (p PgTable) Name() string {
 }
 
 func (p PgTable) Get(key interface{}) (value interface{}, err error) {
-	query := fmt.Sprintf("SELECT value FROM %s WHERE key = $1", p.name)
-	keyStr := fmt.Sprintf("%v", key)
-	row := p.pool.QueryRow(context.Background(), query, keyStr)
-	var valueFromDb string
-	err = row.Scan(&valueFromDb)
+	return p.GetContext(context.Background(), key)
+}
+
+// GetContext cancels the query when the context is done
+func (p PgTable) GetContext(ctx context.Context, key interface{}) (value interface{}, err error) {
+	args, err := p.schema.keyArgs(key)
+	if err != nil {
+		return nil, err
+	}
+	dest, decode := p.schema.valueDest()
+	err = p.pool.QueryRow(ctx, p.schema.getQuery(p.name), args...).Scan(dest...)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, fmt.Errorf("%w - %v", ErrKeyNotFound, key)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get value from database: %w", err)
 	}
-	return valueFromDb, nil
+	return decode()
 }
 
 func (p PgTable) Put(key interface{}, value interface{}) error {
-	query := fmt.Sprintf("INSERT INTO %s (key, value) VALUES ($1, $2) ON CONFLICT(key) DO UPDATE SET value = $2", p.name)
-	keyStr := fmt.Sprintf("%v", key)
-	valStr := fmt.Sprintf("%v", value)
-	_, err := p.pool.Exec(context.Background(), query, keyStr, valStr)
+	args, err := p.schema.rowArgs(key, value)
+	if err != nil {
+		return err
+	}
+	_, err = p.pool.Exec(context.Background(), p.schema.putQuery(p.name), args...)
 	if err != nil {
 		return fmt.Errorf("failed to insert value into database: %w", err)
 	}
@@ -121,27 +253,267 @@ func (p PgTable) Put(key interface{}, value interface{}) error {
 }
 
 func (p PgTable) Delete(key interface{}) error {
-	getQuery := fmt.Sprintf("SELECT value FROM %s WHERE key = $1", p.name)
-	keyStr := fmt.Sprintf("%v", key)
-	row := p.pool.QueryRow(context.Background(), getQuery, keyStr)
-	err := row.Scan(new(string))
-	if errors.Is(err, pgx.ErrNoRows) {
+	args, err := p.schema.keyArgs(key)
+	if err != nil {
+		return err
+	}
+	tag, err := p.pool.Exec(context.Background(), p.schema.deleteQuery(p.name), args...)
+	if err != nil {
+		return fmt.Errorf("failed to delete value from database: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
 		return fmt.Errorf("%w - %v", ErrKeyNotFound, key)
 	}
+	return nil
+}
+
+// ValidateTypes checks the types of typed tables, and the values of tables with a codec. Other tables
+// convert keys and values to strings using fmt.Sprintf, so any type is valid
+func (p PgTable) ValidateTypes(key interface{}, value interface{}) error {
+	return p.schema.validate(key, value)
+}
+
+// Scan orders rows by the key columns. Tables created with GetTable order by the string representation
+// of the key, the same one used to store it. Text columns use the "C" collation, which makes Postgres
+// compare strings byte by byte, like CompareKeys does
+func (p PgTable) Scan(from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	return p.ScanContext(context.Background(), from, to, limit)
+}
 
-	query := fmt.Sprintf("DELETE FROM %s WHERE key = $1", p.name)
-	_, err = p.pool.Exec(context.Background(), query, keyStr)
+// ScanContext cancels the query when the context is done
+func (p PgTable) ScanContext(ctx context.Context, from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	args := make([]interface{}, 0, 2*p.schema.keyCount+1)
+	for _, bound := range []interface{}{from, to} {
+		if bound == nil {
+			continue
+		}
+		boundArgs, err := p.schema.keyArgs(bound)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, boundArgs...)
+	}
+	if limit > 0 {
+		args = append(args, limit)
+	}
+	rows, err := p.pool.Query(ctx, p.schema.scanQuery(p.name, from != nil, to != nil, limit > 0), args...)
 	if err != nil {
-		return fmt.Errorf("failed to delete value from database: %w", err)
+		return nil, fmt.Errorf("failed to scan table: %w", err)
+	}
+	defer rows.Close()
+	res := make([]KeyValue, 0)
+	for rows.Next() {
+		keyDest, decodeKey := p.schema.keyDest()
+		valueDest, decodeValue := p.schema.valueDest()
+		if err = rows.Scan(append(keyDest, valueDest...)...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		value, err := decodeValue()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, KeyValue{Key: decodeKey(), Value: value})
+	}
+	return res, rows.Err()
+}
+
+func (p PgTable) CompareKeys(a interface{}, b interface{}) int {
+	return p.schema.compareKeys(a, b)
+}
+
+// preparedId is the global identifier of the prepared transaction of this table. Prepared transactions
+// are visible to every session of the database, so the identifier contains the table name to tell them apart
+func (p PgTable) preparedId(tid TransactId) string {
+	return fmt.Sprintf("asyncdb_%s_%s", p.name, uuid.UUID(tid).String())
+}
+
+// durable reports that the server keeps committed writes
+func (p PgTable) durable() bool {
+	return true
+}
+
+// canPrepare reports whether the server allows prepared transactions. Without them, commits write the table
+// directly in one Postgres transaction
+func (p PgTable) canPrepare() bool {
+	return p.twoPhase
+}
+
+// Prepare writes the entries in a Postgres transaction and prepares it with PREPARE TRANSACTION.
+// The server must be configured with max_prepared_transactions greater than zero.
+// Commits only prepare when more than one table of the transaction can prepare
+func (p PgTable) Prepare(tid TransactId, entries []LogEntry) error {
+	ctx := context.Background()
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+	if _, err = conn.Exec(ctx, "BEGIN"); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// The writes are sent in one round-trip
+	if err = p.execEntries(ctx, conn, entries); err != nil {
+		_, rollbackErr := conn.Exec(ctx, "ROLLBACK")
+		return errors.Join(fmt.Errorf("failed to prepare transaction: %w", err), rollbackErr)
+	}
+	if _, err = conn.Exec(ctx, fmt.Sprintf("PREPARE TRANSACTION '%s'", p.preparedId(tid))); err != nil {
+		return fmt.Errorf("failed to prepare transaction: %w", err)
+	}
+	return nil
+}
+
+// pgBatchSender is a connection or a transaction that can send batches
+type pgBatchSender interface {
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+// batchEntries queues the writes of the entries, in order
+func (p PgTable) batchEntries(entries []LogEntry) (*pgx.Batch, error) {
+	batch := &pgx.Batch{}
+	putQuery, deleteQuery := p.schema.putQuery(p.name), p.schema.deleteQuery(p.name)
+	for _, entry := range entries {
+		var args []interface{}
+		var err error
+		switch entry.Op {
+		case LPut:
+			if args, err = p.schema.rowArgs(entry.Key, entry.Value); err == nil {
+				batch.Queue(putQuery, args...)
+			}
+		case LDelete:
+			if args, err = p.schema.keyArgs(entry.Key); err == nil {
+				batch.Queue(deleteQuery, args...)
+			}
+		default:
+			err = fmt.Errorf("%w - %d", ErrInvalidLogEntry, entry.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return batch, nil
+}
+
+// execEntries sends the writes of the entries in one batch. Deletes of missing keys fail with ErrKeyNotFound
+func (p PgTable) execEntries(ctx context.Context, db pgBatchSender, entries []LogEntry) error {
+	batch, err := p.batchEntries(entries)
+	if err != nil {
+		return err
+	}
+	results := db.SendBatch(ctx, batch)
+	for _, entry := range entries {
+		tag, err := results.Exec()
+		if err == nil && entry.Op == LDelete && tag.RowsAffected() == 0 {
+			err = fmt.Errorf("%w - %v", ErrKeyNotFound, entry.Key)
+		}
+		if err != nil {
+			return errors.Join(err, results.Close())
+		}
+	}
+	return results.Close()
+}
+
+// applyEntries applies the entries in one round-trip and one Postgres transaction, so either all of them
+// are applied or none. Commits write the table with it when it does not prepare
+func (p PgTable) applyEntries(entries []LogEntry) error {
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		return p.execEntries(ctx, tx, entries)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write batch: %w", err)
+	}
+	return nil
+}
+
+// GetMany reads the keys in one round-trip
+func (p PgTable) GetMany(keys []interface{}) ([]interface{}, []bool, error) {
+	batch := &pgx.Batch{}
+	query := p.schema.getQuery(p.name)
+	for _, key := range keys {
+		args, err := p.schema.keyArgs(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		batch.Queue(query, args...)
+	}
+	results := p.pool.SendBatch(context.Background(), batch)
+	defer results.Close()
+	values := make([]interface{}, len(keys))
+	found := make([]bool, len(keys))
+	for i := range keys {
+		dest, decode := p.schema.valueDest()
+		err := results.QueryRow().Scan(dest...)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get values from database: %w", err)
+		}
+		if values[i], err = decode(); err != nil {
+			return nil, nil, err
+		}
+		found[i] = true
+	}
+	return values, found, nil
+}
+
+// PutMany writes the rows in one round-trip and one Postgres transaction
+func (p PgTable) PutMany(rows []KeyValue) error {
+	entries := make([]LogEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = LogEntry{Op: LPut, Key: row.Key, Value: row.Value}
+	}
+	return p.applyEntries(entries)
+}
+
+// DeleteMany deletes the keys in one round-trip and one Postgres transaction, none of them are deleted
+// if one does not exist
+func (p PgTable) DeleteMany(keys []interface{}) error {
+	entries := make([]LogEntry, len(keys))
+	for i, key := range keys {
+		entries[i] = LogEntry{Op: LDelete, Key: key}
+	}
+	return p.applyEntries(entries)
+}
+
+func (p PgTable) CommitPrepared(tid TransactId) error {
+	_, err := p.pool.Exec(context.Background(), fmt.Sprintf("COMMIT PREPARED '%s'", p.preparedId(tid)))
+	if err != nil {
+		return fmt.Errorf("failed to commit prepared transaction: %w", err)
 	}
 	return nil
 }
 
-func (p PgTable) ValidateTypes(_ interface{}, _ interface{}) error {
-	// Interfaces will be converted to strings using fmt.Sprintf, so no need to validate types
+func (p PgTable) AbortPrepared(tid TransactId) error {
+	_, err := p.pool.Exec(context.Background(), fmt.Sprintf("ROLLBACK PREPARED '%s'", p.preparedId(tid)))
+	if err != nil {
+		return fmt.Errorf("failed to rollback prepared transaction: %w", err)
+	}
 	return nil
 }
 
-func newPgTable(name string, pool *pgxpool.Pool) (*PgTable, error) {
-	return &PgTable{pool: pool, name: name}, nil
+func (p PgTable) PreparedTransactions() ([]TransactId, error) {
+	prefix := fmt.Sprintf("asyncdb_%s_", p.name)
+	rows, err := p.pool.Query(context.Background(), "SELECT gid FROM pg_prepared_xacts WHERE starts_with(gid, $1)", prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prepared transactions: %w", err)
+	}
+	defer rows.Close()
+	tids := make([]TransactId, 0)
+	for rows.Next() {
+		var gid string
+		if err = rows.Scan(&gid); err != nil {
+			return nil, fmt.Errorf("failed to scan prepared transaction: %w", err)
+		}
+		id, err := uuid.Parse(strings.TrimPrefix(gid, prefix))
+		if err != nil {
+			continue
+		}
+		tids = append(tids, TransactId(id))
+	}
+	return tids, rows.Err()
+}
+
+func newPgTable(name string, pool *pgxpool.Pool, schema *pgSchema, twoPhase bool) *PgTable {
+	return &PgTable{pool: pool, name: name, schema: schema, twoPhase: twoPhase}
 }
@@ -0,0 +1,91 @@
+package asyncdb
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy decides how often and when RunInTransaction runs a failed transaction again
+type RetryPolicy struct {
+	// MaxAttempts limits the attempts including the first one, zero means no limit
+	MaxAttempts int
+	// The backoff before the n-th retry is random between zero and BaseBackoff * 2^(n-1),
+	// but not longer than MaxBackoff. Zero MaxBackoff leaves it unbounded
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 10,
+	BaseBackoff: time.Millisecond,
+	MaxBackoff:  100 * time.Millisecond,
+}
+
+func WithMaxAttempts(attempts int) func(*RetryPolicy) {
+	return func(r *RetryPolicy) {
+		r.MaxAttempts = attempts
+	}
+}
+
+func WithBackoff(base time.Duration, max time.Duration) func(*RetryPolicy) {
+	return func(r *RetryPolicy) {
+		r.BaseBackoff = base
+		r.MaxBackoff = max
+	}
+}
+
+// backoff returns the jittered wait before the retry following the attempt
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	if r.BaseBackoff <= 0 {
+		return 0
+	}
+	ceiling := r.BaseBackoff << min(attempt-1, 32)
+	if ceiling <= 0 || (r.MaxBackoff > 0 && ceiling > r.MaxBackoff) {
+		ceiling = r.MaxBackoff
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// isRetryable checks if the transaction failed because of other transactions, so that running it again can succeed.
+// Operations of a transaction that is being wounded fail with ErrXactInTerminalState while the abort holds the connection
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrLockConflict) || errors.Is(err, ErrLockTimeout) || errors.Is(err, ErrXactAborted) ||
+		errors.Is(err, ErrXactInTerminalState)
+}
+
+// RunInTransaction runs fn in a new transaction of the connection, and commits it if fn succeeds. If fn or
+// the commit fail because of other transactions, the transaction is rolled back, and run again after a backoff.
+// Retries keep the timestamp of the first attempt, so under wait-die the transaction becomes older than
+// its competitors. Other errors of fn roll the transaction back. Returns the number of attempts and the error
+// of the last one
+func (p *AsyncDB) RunInTransaction(ctx *ConnectionContext, opts TxOptions, fn func(ctx *ConnectionContext) error, retry ...func(*RetryPolicy)) (int, error) {
+	policy := defaultRetryPolicy
+	for _, option := range retry {
+		option(&policy)
+	}
+	ts := time.Now().UnixNano()
+	for attempt := 1; ; attempt++ {
+		if err := p.beginTransaction(ctx, opts, ts); err != nil {
+			return attempt, err
+		}
+		err := fn(ctx)
+		// An aborted transaction leaves the connection in a new transaction in Ready mode, which is rolled back as well.
+		// Aborts from other goroutines replace the transaction, so it is read under the mutex
+		ctx.TxnMu.RLock()
+		txn := ctx.Txn
+		ctx.TxnMu.RUnlock()
+		if txn != nil {
+			ts = txn.ts
+			if err == nil {
+				err = p.CommitTransaction(ctx)
+			} else {
+				_ = p.RollbackTransaction(ctx)
+			}
+		}
+		if err == nil || !isRetryable(err) || (policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts) {
+			return attempt, err
+		}
+		time.Sleep(policy.backoff(attempt))
+	}
+}
@@ -0,0 +1,130 @@
+package asyncdb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var errNotRetryable = errors.New("not retryable")
+
+func TestRunInTransaction_Should_Commit(t *testing.T) {
+	db, ctx := newTestDB(t, NewLockManager(), []Table{newTestTable[int, int](t, "test")})
+	attempts, err := db.RunInTransaction(ctx, TxOptions{}, func(ctx *ConnectionContext) error {
+		return (<-db.Put(ctx, "test", 1, 10)).Err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, attempts)
+	assert.Nil(t, ctx.Txn)
+	assert.Equal(t, 10, (<-db.Get(ctx, "test", 1)).Data)
+}
+
+func TestRunInTransaction_Should_Retry_Lock_Conflicts_With_Same_Timestamp(t *testing.T) {
+	db, ctx := newTestDB(t, NewLockManager(), []Table{newTestTable[int, int](t, "test")})
+	holder, _ := db.Connect()
+	_ = db.BeginTransaction(holder)
+	assert.Nil(t, (<-db.Put(holder, "test", 1, 10)).Err)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = db.CommitTransaction(holder)
+	}()
+	timestamps := make([]int64, 0)
+	// The transaction is younger than the holder, so it dies until the holder commits
+	attempts, err := db.RunInTransaction(ctx, TxOptions{}, func(ctx *ConnectionContext) error {
+		timestamps = append(timestamps, ctx.Txn.Timestamp())
+		res := <-db.Get(ctx, "test", 1)
+		if res.Err != nil {
+			return res.Err
+		}
+		return (<-db.Put(ctx, "test", 1, res.Data.(int)+1)).Err
+	}, WithMaxAttempts(0))
+	assert.Nil(t, err)
+	assert.Greater(t, attempts, 1)
+	assert.Len(t, timestamps, attempts)
+	for _, ts := range timestamps {
+		assert.Equal(t, timestamps[0], ts)
+	}
+	assert.Equal(t, 11, (<-db.Get(ctx, "test", 1)).Data)
+}
+
+func TestRunInTransaction_Should_Not_Retry_Other_Errors(t *testing.T) {
+	db, ctx := newTestDB(t, NewLockManager(), []Table{newTestTable[int, int](t, "test")})
+	attempts, err := db.RunInTransaction(ctx, TxOptions{}, func(ctx *ConnectionContext) error {
+		_ = <-db.Put(ctx, "test", 1, 10)
+		return errNotRetryable
+	})
+	assert.ErrorIs(t, err, errNotRetryable)
+	assert.Equal(t, 1, attempts)
+	assert.Nil(t, ctx.Txn)
+	assert.ErrorIs(t, (<-db.Get(ctx, "test", 1)).Err, ErrKeyNotFound)
+}
+
+func TestRunInTransaction_Should_Stop_After_Max_Attempts(t *testing.T) {
+	db, ctx := newTestDB(t, NewLockManager(), []Table{newTestTable[int, int](t, "test")})
+	calls := 0
+	attempts, err := db.RunInTransaction(ctx, TxOptions{}, func(ctx *ConnectionContext) error {
+		calls++
+		return ErrXactAborted
+	}, WithMaxAttempts(3), WithBackoff(0, 0))
+	assert.ErrorIs(t, err, ErrXactAborted)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 3, calls)
+	assert.Nil(t, ctx.Txn)
+}
+
+func TestRunInTransaction_Should_Retry_Operations_Of_Wounded_Transaction(t *testing.T) {
+	db, ctx := newTestDB(t, NewLockManager(), []Table{newTestTable[int, int](t, "test")})
+	calls := 0
+	attempts, err := db.RunInTransaction(ctx, TxOptions{}, func(ctx *ConnectionContext) error {
+		calls++
+		// The first attempt sees the abort of a wound in progress
+		if calls == 1 {
+			return fmt.Errorf("failed to put: %w", ErrXactInTerminalState)
+		}
+		return (<-db.Put(ctx, "test", 1, 10)).Err
+	}, WithBackoff(0, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, 10, (<-db.Get(ctx, "test", 1)).Data)
+}
+
+func TestRunInTransaction_Should_Retry_When_Wounded(t *testing.T) {
+	db, ctx := newTestDB(t, NewLockManager(WithDeadlockPolicy(WoundWait)), []Table{newTestTable[int, int](t, "test")})
+	older, _ := db.Connect()
+	// The older transaction begins first, so it wounds the other one
+	_ = db.BeginTransaction(older)
+	wounded := make(chan struct{})
+	attempts, err := db.RunInTransaction(ctx, TxOptions{}, func(ctx *ConnectionContext) error {
+		if err := (<-db.Put(ctx, "test", 1, 10)).Err; err != nil {
+			return err
+		}
+		select {
+		case <-wounded:
+			return nil
+		default:
+		}
+		// The older transaction wounds this one to take the lock, then commits
+		go func() {
+			assert.Nil(t, (<-db.Put(older, "test", 1, 20)).Err)
+			assert.Nil(t, db.CommitTransaction(older))
+			close(wounded)
+		}()
+		<-wounded
+		return (<-db.Get(ctx, "test", 1)).Err
+	}, WithBackoff(0, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, 10, (<-db.Get(ctx, "test", 1)).Data)
+}
+
+func TestRetryPolicy_Backoff_Should_Be_Bounded(t *testing.T) {
+	policy := RetryPolicy{BaseBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	for attempt := 1; attempt <= 40; attempt++ {
+		backoff := policy.backoff(attempt)
+		assert.GreaterOrEqual(t, backoff, time.Duration(0))
+		assert.LessOrEqual(t, backoff, min(time.Millisecond<<(attempt-1), 5*time.Millisecond))
+	}
+	assert.Zero(t, RetryPolicy{}.backoff(1))
+}
@@ -0,0 +1,235 @@
+package asyncdb
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// ShardedLockManager partitions the key locks by table and key into independent lock managers, so that
+// requests for different keys rarely contend on the same mutexes. Range locks are taken in every shard,
+// in shard order. The shards a transaction locked in are recorded in a stripe chosen by its id, so that
+// releasing its locks only visits those shards. Under DeadlockDetection, the shards share the waits-for graph
+// to find cycles across shards. Under WoundWait, a wound in one shard cancels the waits of the victim
+// in its other shards. Lock escalation is done per shard, and covers only the keys of the shard
+type ShardedLockManager struct {
+	shards []*LockManagerImpl
+	xacts  []*shardedXacts
+	// released is shared by the shards, so a transaction is marked once for all of them
+	released stripedReleasedSet
+	seed     maphash.Seed
+	onWound  func(tid TransactId)
+}
+
+// shardedXacts keeps the shards of the transactions of a stripe, and the transactions that were wounded
+type shardedXacts struct {
+	shards  map[TransactId][]*LockManagerImpl
+	wounded map[TransactId]bool
+	m       *sync.Mutex
+}
+
+// NewShardedLockManager creates shardCount shards with the options of LockManagerImpl.
+// A shardCount below 1 uses a shard per CPU
+func NewShardedLockManager(shardCount int, options ...func(*LockManagerImpl)) *ShardedLockManager {
+	if shardCount < 1 {
+		shardCount = runtime.GOMAXPROCS(0)
+	}
+	lm := &ShardedLockManager{
+		shards: make([]*LockManagerImpl, shardCount),
+		xacts:  make([]*shardedXacts, shardCount),
+		seed:   maphash.MakeSeed(),
+	}
+	for i := range lm.shards {
+		lm.shards[i] = NewLockManager(options...)
+		if lm.shards[i].policy == DeadlockDetection {
+			lm.shards[i].waits = lm.shards[0].waits
+		}
+		lm.shards[i].onWound = lm.wound
+	}
+	lm.released = newStripedReleasedSet(shardCount, lm.shards[0].releasedRetention)
+	for i := range lm.xacts {
+		lm.shards[i].transactReleased = lm.released
+		lm.xacts[i] = &shardedXacts{
+			shards:  make(map[TransactId][]*LockManagerImpl),
+			wounded: make(map[TransactId]bool),
+			m:       &sync.Mutex{},
+		}
+	}
+	return lm
+}
+
+// stripe returns the stripe recording the shards of the transaction
+func (lm *ShardedLockManager) stripe(tid TransactId) *shardedXacts {
+	return lm.xacts[xactStripe(tid, len(lm.xacts))]
+}
+
+// enter records that the transaction locks in the shards, unless its locks were released.
+// A transaction wounded before is wounded in its new shards as well
+func (lm *ShardedLockManager) enter(tid TransactId, shards ...*LockManagerImpl) error {
+	xacts := lm.stripe(tid)
+	xacts.m.Lock()
+	defer xacts.m.Unlock()
+	if lm.released.contains(tid) {
+		return ErrLocksReleased
+	}
+	entered := xacts.shards[tid]
+	for _, shard := range shards {
+		if slices.Contains(entered, shard) {
+			continue
+		}
+		entered = append(entered, shard)
+		if xacts.wounded[tid] {
+			shard.waits.wound(tid)
+		}
+	}
+	xacts.shards[tid] = entered
+	return nil
+}
+
+// entered returns the shards the transaction locked in
+func (lm *ShardedLockManager) entered(tid TransactId) []*LockManagerImpl {
+	xacts := lm.stripe(tid)
+	xacts.m.Lock()
+	defer xacts.m.Unlock()
+	return slices.Clone(xacts.shards[tid])
+}
+
+// wound is the wound handler of the shards. It cancels the waits of the victim in its other shards,
+// and then notifies the owner of the transaction
+func (lm *ShardedLockManager) wound(tid TransactId) {
+	xacts := lm.stripe(tid)
+	xacts.m.Lock()
+	if lm.released.contains(tid) {
+		xacts.m.Unlock()
+		return
+	}
+	xacts.wounded[tid] = true
+	shards := slices.Clone(xacts.shards[tid])
+	xacts.m.Unlock()
+	for _, shard := range shards {
+		for _, cancel := range shard.waits.wound(tid) {
+			cancel()
+		}
+	}
+	if lm.onWound != nil {
+		lm.onWound(tid)
+	}
+}
+
+// shard returns the shard of the key
+func (lm *ShardedLockManager) shard(tableId TableId, key interface{}) *LockManagerImpl {
+	var h maphash.Hash
+	h.SetSeed(lm.seed)
+	buf := make([]byte, 0, 16)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(tableId))
+	switch k := key.(type) {
+	case int:
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(k))
+	case int64:
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(k))
+	case uint64:
+		buf = binary.LittleEndian.AppendUint64(buf, k)
+	case string:
+		_, _ = h.WriteString(k)
+	default:
+		_, _ = fmt.Fprint(&h, key)
+	}
+	_, _ = h.Write(buf)
+	return lm.shards[h.Sum64()%uint64(len(lm.shards))]
+}
+
+func (lm *ShardedLockManager) Lock(lockType int, tid TransactId, ts int64, tableId TableId, key interface{}) error {
+	return lm.LockContext(context.Background(), lockType, tid, ts, tableId, key)
+}
+
+func (lm *ShardedLockManager) LockContext(ctx context.Context, lockType int, tid TransactId, ts int64, tableId TableId, key interface{}) error {
+	shard := lm.shard(tableId, key)
+	if err := lm.enter(tid, shard); err != nil {
+		return err
+	}
+	return shard.LockContext(ctx, lockType, tid, ts, tableId, key)
+}
+
+func (lm *ShardedLockManager) LockRange(lockType int, tid TransactId, ts int64, tableId TableId, keyRange KeyRange) error {
+	return lm.LockRangeContext(context.Background(), lockType, tid, ts, tableId, keyRange)
+}
+
+// LockRangeContext locks the range in every shard, as its keys can be in any of them. The lock timeout
+// applies to all shards together. If a shard fails, the range stays locked in the previous shards
+// until the transaction releases its locks
+func (lm *ShardedLockManager) LockRangeContext(ctx context.Context, lockType int, tid TransactId, ts int64, tableId TableId, keyRange KeyRange) error {
+	if err := lm.enter(tid, lm.shards...); err != nil {
+		return err
+	}
+	ctx, cancel := lm.shards[0].waitContext(ctx)
+	defer cancel()
+	for _, shard := range lm.shards {
+		if err := shard.LockRangeContext(ctx, lockType, tid, ts, tableId, keyRange); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseLocks refuses further lock requests of the transaction, and releases its locks in the shards
+// it locked in. As in LockManagerImpl, it is marked as released before any lock is released
+func (lm *ShardedLockManager) ReleaseLocks(tid TransactId) error {
+	xacts := lm.stripe(tid)
+	xacts.m.Lock()
+	lm.released.add(tid)
+	shards := xacts.shards[tid]
+	delete(xacts.shards, tid)
+	delete(xacts.wounded, tid)
+	xacts.m.Unlock()
+	for _, shard := range shards {
+		shard.forgetWaits(tid)
+	}
+	for _, shard := range shards {
+		shard.releaseHeld(tid)
+	}
+	return nil
+}
+
+func (lm *ShardedLockManager) ReleaseReadLock(tid TransactId, tableId TableId, key interface{}) error {
+	return lm.shard(tableId, key).ReleaseReadLock(tid, tableId, key)
+}
+
+func (lm *ShardedLockManager) ReleaseReadRange(tid TransactId, tableId TableId, keyRange KeyRange) error {
+	for _, shard := range lm.entered(tid) {
+		_ = shard.ReleaseReadRange(tid, tableId, keyRange)
+	}
+	return nil
+}
+
+func (lm *ShardedLockManager) SetWoundHandler(handler func(tid TransactId)) {
+	lm.onWound = handler
+}
+
+// Stats merges the stats of the shards
+func (lm *ShardedLockManager) Stats() LockStats {
+	stats := LockStats{
+		Keys:      make([]KeyLockStats, 0),
+		WaitTimes: make([]WaitTimeBucket, len(waitTimeBounds)),
+	}
+	for i, bound := range waitTimeBounds {
+		stats.WaitTimes[i].UpperBound = bound
+	}
+	for _, shard := range lm.shards {
+		shardStats := shard.Stats()
+		stats.Keys = append(stats.Keys, shardStats.Keys...)
+		stats.Conflicts += shardStats.Conflicts
+		stats.Waits += shardStats.Waits
+		stats.Timeouts += shardStats.Timeouts
+		for i, bucket := range shardStats.WaitTimes {
+			stats.WaitTimes[i].Count += bucket.Count
+		}
+	}
+	slices.SortStableFunc(stats.Keys, func(a, b KeyLockStats) int {
+		return b.QueueLength - a.QueueLength
+	})
+	return stats
+}
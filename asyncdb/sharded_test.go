@@ -0,0 +1,221 @@
+package asyncdb
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// keysInDifferentShards returns two keys of the table that are locked by different shards
+func keysInDifferentShards(lm *ShardedLockManager) (int, int) {
+	for key := 1; ; key++ {
+		if lm.shard(TableId(1), key) != lm.shard(TableId(1), 0) {
+			return 0, key
+		}
+	}
+}
+
+func TestShardedLockManager_Lock_Conflict_Should_Fail_Younger(t *testing.T) {
+	lm := NewShardedLockManager(4)
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	assert.Nil(t, lm.Lock(WriteLock, older, 1, TableId(1), "key"))
+	assert.EqualError(t, lm.Lock(ReadLock, younger, 2, TableId(1), "key"), lockConflictErr)
+	_ = lm.ReleaseLocks(older)
+	assert.Nil(t, lm.Lock(ReadLock, younger, 2, TableId(1), "key"))
+}
+
+func TestShardedLockManager_LockRange_Should_Conflict_With_Keys_In_Every_Shard(t *testing.T) {
+	lm := NewShardedLockManager(4)
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	first, second := keysInDifferentShards(lm)
+	assert.Nil(t, lm.Lock(WriteLock, older, 1, TableId(1), second))
+	assert.EqualError(t, lm.LockRange(ReadLock, younger, 2, TableId(1), intRange(first, second)), lockConflictErr)
+	_ = lm.ReleaseLocks(younger)
+	_ = lm.ReleaseLocks(older)
+	older = TransactId(uuid.New())
+	younger = TransactId(uuid.New())
+	assert.Nil(t, lm.LockRange(ReadLock, older, 3, TableId(1), intRange(first, second)))
+	assert.EqualError(t, lm.Lock(WriteLock, younger, 4, TableId(1), first), lockConflictErr)
+	_ = lm.ReleaseLocks(younger)
+	assert.Nil(t, lm.ReleaseReadRange(older, TableId(1), intRange(first, second)))
+	assert.Nil(t, lm.Lock(WriteLock, TransactId(uuid.New()), 5, TableId(1), first))
+}
+
+func TestShardedLockManager_DeadlockDetection_Should_Find_Cycle_Across_Shards(t *testing.T) {
+	lm := NewShardedLockManager(4, WithDeadlockPolicy(DeadlockDetection))
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	first, second := keysInDifferentShards(lm)
+	_ = lm.Lock(WriteLock, older, 1, TableId(1), first)
+	_ = lm.Lock(WriteLock, younger, 2, TableId(1), second)
+	waiterErr := make(chan error, 1)
+	go func() {
+		waiterErr <- lm.Lock(WriteLock, older, 1, TableId(1), second)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.EqualError(t, lm.Lock(WriteLock, younger, 2, TableId(1), first), lockConflictErr)
+	_ = lm.ReleaseLocks(younger)
+	assert.Nil(t, <-waiterErr)
+}
+
+func TestShardedLockManager_WoundWait_Should_Cancel_Waits_In_Other_Shards(t *testing.T) {
+	lm := NewShardedLockManager(4, WithDeadlockPolicy(WoundWait))
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	first, second := keysInDifferentShards(lm)
+	_ = lm.Lock(WriteLock, older, 1, TableId(1), second)
+	_ = lm.Lock(WriteLock, younger, 2, TableId(1), first)
+	youngerErr := make(chan error, 1)
+	go func() {
+		youngerErr <- lm.Lock(WriteLock, younger, 2, TableId(1), second)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	olderErr := make(chan error, 1)
+	go func() {
+		olderErr <- lm.Lock(WriteLock, older, 1, TableId(1), first)
+	}()
+	// The wound in the shard of the first key cancels the wait in the shard of the second one
+	assert.EqualError(t, <-youngerErr, lockConflictErr)
+	_ = lm.ReleaseLocks(younger)
+	assert.Nil(t, <-olderErr)
+}
+
+func TestShardedLockManager_ReleaseLocks_Should_Refuse_Later_Locks_In_Other_Shards(t *testing.T) {
+	lm := NewShardedLockManager(4)
+	tid := TransactId(uuid.New())
+	first, second := keysInDifferentShards(lm)
+	assert.Nil(t, lm.Lock(WriteLock, tid, 1, TableId(1), first))
+	assert.Len(t, lm.entered(tid), 1)
+	_ = lm.ReleaseLocks(tid)
+	assert.Empty(t, lm.entered(tid))
+	assert.EqualError(t, lm.Lock(WriteLock, tid, 1, TableId(1), second), locksReleasedErr)
+	assert.Empty(t, lm.Stats().Keys)
+}
+
+func TestShardedLockManager_Stats_Should_Merge_Shards(t *testing.T) {
+	lm := NewShardedLockManager(4)
+	older := TransactId(uuid.New())
+	younger := TransactId(uuid.New())
+	first, second := keysInDifferentShards(lm)
+	_ = lm.Lock(WriteLock, older, 1, TableId(1), first)
+	_ = lm.Lock(WriteLock, older, 1, TableId(1), second)
+	_ = lm.Lock(ReadLock, younger, 2, TableId(1), first)
+	_ = lm.Lock(ReadLock, younger, 2, TableId(1), second)
+	stats := lm.Stats()
+	assert.Len(t, stats.Keys, 2)
+	assert.Equal(t, int64(2), stats.Conflicts)
+	_ = lm.ReleaseLocks(older)
+	assert.Empty(t, lm.Stats().Keys)
+}
+
+func TestShardedLockManager_Data_Consistency(t *testing.T) {
+	// GIVEN routineCount concurrent goroutines that execute iterCount transactions
+	// that lock two keys of different shards in different orders, then increment a counter
+	// WHEN each routineCount completes under every deadlock policy
+	// THEN the counter should be equal to routineCount * iterCount
+	routineCount := 8
+	iterCount := 200
+	policies := map[string]DeadlockPolicy{
+		"WaitDie":           WaitDie,
+		"WoundWait":         WoundWait,
+		"NoWait":            NoWait,
+		"DeadlockDetection": DeadlockDetection,
+	}
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			lm := NewShardedLockManager(4, WithDeadlockPolicy(policy))
+			keys := [2]int{}
+			keys[0], keys[1] = keysInDifferentShards(lm)
+			counter := 0
+			wg := sync.WaitGroup{}
+			wg.Add(routineCount)
+			xact := func(tid TransactId, ts int64, first int, second int) error {
+				if err := lm.Lock(ReadLock, tid, ts, TableId(1), first); err != nil {
+					return err
+				}
+				if err := lm.Lock(WriteLock, tid, ts, TableId(1), second); err != nil {
+					return err
+				}
+				if err := lm.Lock(WriteLock, tid, ts, TableId(1), first); err != nil {
+					return err
+				}
+				counter++
+				return lm.ReleaseLocks(tid)
+			}
+			f := func(r int) {
+				defer wg.Done()
+				for i := range iterCount {
+					ts := int64(i*routineCount + r)
+					tid := TransactId(uuid.New())
+					err := xact(tid, ts, keys[r%2], keys[1-r%2])
+					for err != nil {
+						_ = lm.ReleaseLocks(tid)
+						tid = TransactId(uuid.New())
+						err = xact(tid, ts, keys[r%2], keys[1-r%2])
+					}
+				}
+			}
+			for i := 0; i < routineCount; i++ {
+				go f(i)
+			}
+			wg.Wait()
+			assert.Equal(t, routineCount*iterCount, counter)
+		})
+	}
+}
+
+func TestShardedLockManager_With_AsyncDB(t *testing.T) {
+	db, ctx := newTestDB(t, NewShardedLockManager(4), []Table{newTestTable[int, int](t, "test")})
+	_ = db.BeginTransaction(ctx)
+	for key := range 10 {
+		assert.Nil(t, (<-db.Put(ctx, "test", key, key)).Err)
+	}
+	assert.Nil(t, db.CommitTransaction(ctx))
+	_ = db.BeginTransaction(ctx)
+	count := 0
+	for res := range db.Scan(ctx, "test", 2, 5, 0) {
+		assert.Nil(t, res.Err)
+		count++
+	}
+	assert.Equal(t, 4, count)
+	assert.Nil(t, db.CommitTransaction(ctx))
+}
+
+// benchmarkLockManager runs short transactions that write lock keysPerXact random keys and release them.
+// Run with -cpu 1,2,4,8 to compare the scaling of the lock managers, on a machine with as many cores
+func benchmarkLockManager(b *testing.B, lm LockManager, keysPerXact int) {
+	var ts atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(ts.Add(1)))
+		for pb.Next() {
+			tid := TransactId(uuid.New())
+			xactTs := ts.Add(1)
+			for range keysPerXact {
+				if lm.Lock(WriteLock, tid, xactTs, TableId(1), r.Intn(1_000_000)) != nil {
+					break
+				}
+			}
+			_ = lm.ReleaseLocks(tid)
+		}
+	})
+}
+
+func BenchmarkLockManager(b *testing.B) {
+	for _, keysPerXact := range []int{1, 8} {
+		b.Run(fmt.Sprintf("LockManagerImpl/keys=%d", keysPerXact), func(b *testing.B) {
+			benchmarkLockManager(b, NewLockManager(), keysPerXact)
+		})
+		for _, shardCount := range []int{4, 16, 64} {
+			b.Run(fmt.Sprintf("ShardedLockManager/shards=%d/keys=%d", shardCount, keysPerXact), func(b *testing.B) {
+				benchmarkLockManager(b, NewShardedLockManager(shardCount), keysPerXact)
+			})
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package asyncdb
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+const (
+	skipListMaxLevel = 32
+	// skipListP is the probability of a node being promoted to the next level
+	skipListP = 0.25
+)
+
+type skipListNode[K any] struct {
+	key  K
+	next []*skipListNode[K]
+}
+
+// skipList is an ordered set of keys used as an index for range scans. It is not thread-safe
+type skipList[K any] struct {
+	head    *skipListNode[K]
+	level   int
+	length  int
+	compare func(a, b K) int
+}
+
+func newSkipList[K any](compare func(a, b K) int) *skipList[K] {
+	return &skipList[K]{
+		head:    &skipListNode[K]{next: make([]*skipListNode[K], skipListMaxLevel)},
+		level:   1,
+		compare: compare,
+	}
+}
+
+func (s *skipList[K]) randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// findPredecessors returns, for every level, the last node with key less than the given key
+func (s *skipList[K]) findPredecessors(key K) []*skipListNode[K] {
+	update := make([]*skipListNode[K], skipListMaxLevel)
+	node := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && s.compare(node.next[i].key, key) < 0 {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+	return update
+}
+
+// Insert adds the key to the set, returns false if it is already present
+func (s *skipList[K]) Insert(key K) bool {
+	update := s.findPredecessors(key)
+	if next := update[0].next[0]; next != nil && s.compare(next.key, key) == 0 {
+		return false
+	}
+	level := s.randomLevel()
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			update[i] = s.head
+		}
+		s.level = level
+	}
+	node := &skipListNode[K]{key: key, next: make([]*skipListNode[K], level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	s.length++
+	return true
+}
+
+// Delete removes the key from the set, returns false if it is not present
+func (s *skipList[K]) Delete(key K) bool {
+	update := s.findPredecessors(key)
+	node := update[0].next[0]
+	if node == nil || s.compare(node.key, key) != 0 {
+		return false
+	}
+	for i := 0; i < len(node.next); i++ {
+		update[i].next[i] = node.next[i]
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+	s.length--
+	return true
+}
+
+func (s *skipList[K]) Len() int {
+	return s.length
+}
+
+// Ascend calls f for keys in order, starting from the first key not less than from (or the first key if from is nil).
+// Iteration stops when f returns false
+func (s *skipList[K]) Ascend(from *K, f func(key K) bool) {
+	node := s.head.next[0]
+	if from != nil {
+		node = s.findPredecessors(*from)[0].next[0]
+	}
+	for ; node != nil; node = node.next[0] {
+		if !f(node.key) {
+			return
+		}
+	}
+}
+
+// scanBound converts a range bound to the key type, nil stays nil as an open bound
+func scanBound[K any](bound interface{}) (*K, error) {
+	if bound == nil {
+		return nil, nil
+	}
+	keyTyped, ok := bound.(K)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected key type - %T, got - %T", ErrTypeMismatch, *new(K), bound)
+	}
+	return &keyTyped, nil
+}
+
+// scan returns the rows with keys between from and to, both inclusive, in order, reading their values
+// with value. It implements OrderedTable.Scan for tables indexed by a skip list
+func (s *skipList[K]) scan(from *K, to *K, limit int, value func(key K) (interface{}, error)) ([]KeyValue, error) {
+	rows := make([]KeyValue, 0)
+	var err error
+	s.Ascend(from, func(key K) bool {
+		if to != nil && s.compare(key, *to) > 0 {
+			return false
+		}
+		var v interface{}
+		if v, err = value(key); err != nil {
+			return false
+		}
+		rows = append(rows, KeyValue{Key: key, Value: v})
+		return limit <= 0 || len(rows) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
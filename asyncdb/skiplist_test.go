@@ -0,0 +1,45 @@
+package asyncdb
+
+import (
+	"cmp"
+	"github.com/stretchr/testify/assert"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestSkipList_Ascend_Should_Return_Keys_In_Order(t *testing.T) {
+	s := newSkipList[int](cmp.Compare[int])
+	keys := rand.Perm(1000)
+	for _, k := range keys {
+		assert.True(t, s.Insert(k))
+	}
+	assert.False(t, s.Insert(keys[0]))
+	for _, k := range keys[:500] {
+		assert.True(t, s.Delete(k))
+	}
+	assert.False(t, s.Delete(keys[0]))
+	got := make([]int, 0)
+	s.Ascend(nil, func(key int) bool {
+		got = append(got, key)
+		return true
+	})
+	want := slices.Clone(keys[500:])
+	slices.Sort(want)
+	assert.Equal(t, want, got)
+	assert.Equal(t, 500, s.Len())
+}
+
+func TestSkipList_Ascend_Should_Start_From_Key(t *testing.T) {
+	s := newSkipList[int](cmp.Compare[int])
+	for _, k := range []int{1, 3, 5, 7} {
+		s.Insert(k)
+	}
+	from := 4
+	got := make([]int, 0)
+	s.Ascend(&from, func(key int) bool {
+		got = append(got, key)
+		return len(got) < 1
+	})
+	assert.Equal(t, []int{5}, got)
+}
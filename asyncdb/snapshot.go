@@ -0,0 +1,64 @@
+package asyncdb
+
+import (
+	"context"
+	"sync"
+)
+
+// snapshotReads serves the read-only transactions of lockingControl from snapshots, so they take no locks
+// and neither wait for writers nor make them wait. While read-only transactions are running, commits keep
+// the versions of the keys they write, like in WithMVCC
+type snapshotReads struct {
+	mv *mvccControl
+	// applying is held for reading by commits, so that snapshots start and versions are dropped
+	// only when no commit is being applied
+	applying *sync.RWMutex
+}
+
+func newSnapshotReads(data *ThreadSafeMap[uint64, Table]) *snapshotReads {
+	return &snapshotReads{
+		mv:       newMVCCControl(data),
+		applying: &sync.RWMutex{},
+	}
+}
+
+func (s *snapshotReads) begin(txn *TransactInfo) {
+	s.applying.Lock()
+	defer s.applying.Unlock()
+	s.mv.begin(txn)
+}
+
+func (s *snapshotReads) read(ctx context.Context, txn *TransactInfo, tableId uint64, table Table, key interface{}) (interface{}, error) {
+	return s.mv.read(ctx, txn, tableId, table, key)
+}
+
+func (s *snapshotReads) scan(ctx context.Context, txn *TransactInfo, tableId uint64, table OrderedTable, from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	return s.mv.scan(ctx, txn, tableId, table, from, to, limit)
+}
+
+// commit applies the log of a transaction holding its locks. Versions are kept only if there are snapshots
+// that must not see it
+func (s *snapshotReads) commit(txn *TransactInfo, tables map[uint64][]LogEntry, apply func() error) error {
+	s.applying.RLock()
+	defer s.applying.RUnlock()
+	if !s.mv.clock.running() {
+		return apply()
+	}
+	s.mv.commitMu.Lock()
+	defer s.mv.commitMu.Unlock()
+	return s.mv.commitVersions(txn, tables, apply)
+}
+
+// end drops all versions after the last snapshot ends, as the tables have the newest state of every key
+func (s *snapshotReads) end(txn *TransactInfo) error {
+	s.applying.Lock()
+	defer s.applying.Unlock()
+	_ = s.mv.end(txn)
+	if s.mv.clock.running() {
+		return nil
+	}
+	s.mv.m.Lock()
+	defer s.mv.m.Unlock()
+	s.mv.versions = make(map[uint64]map[interface{}][]version)
+	return nil
+}
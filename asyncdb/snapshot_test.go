@@ -0,0 +1,103 @@
+package asyncdb
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadOnly_Should_Read_Snapshot(t *testing.T) {
+	db, reader := newSeededTestDB(t)
+	writer, _ := db.Connect()
+	_ = db.BeginTransaction(reader, TxOptions{ReadOnly: true})
+	assert.Equal(t, 10, (<-db.Get(reader, "test", 1)).Data)
+	_ = db.BeginTransaction(writer)
+	assert.Nil(t, (<-db.Put(writer, "test", 1, 11)).Err)
+	assert.Nil(t, (<-db.Delete(writer, "test", 2)).Err)
+	assert.Nil(t, (<-db.Put(writer, "test", 4, 40)).Err)
+	assert.Nil(t, db.CommitTransaction(writer))
+	assert.Equal(t, 10, (<-db.Get(reader, "test", 1)).Data)
+	assert.Equal(t, 20, (<-db.Get(reader, "test", 2)).Data)
+	keys := make([]interface{}, 0)
+	for res := range db.Scan(reader, "test", nil, nil, 0) {
+		keys = append(keys, res.Data.(KeyValue).Key)
+	}
+	assert.Equal(t, []interface{}{1, 2, 3}, keys)
+	assert.Nil(t, db.CommitTransaction(reader))
+	assert.Equal(t, 11, (<-db.Get(reader, "test", 1)).Data)
+	assert.Empty(t, db.cc.(*lockingControl).snapshots.mv.versions)
+}
+
+func TestReadOnly_Should_Not_Wait_For_Writers(t *testing.T) {
+	db, reader := newSeededTestDB(t)
+	lm := db.lManager.(*LockManagerImpl)
+	writer, _ := db.Connect()
+	_ = db.BeginTransaction(writer)
+	assert.Nil(t, (<-db.Put(writer, "test", 1, 11)).Err)
+	// The reader is younger, so it would die on the write lock
+	time.Sleep(time.Millisecond)
+	_ = db.BeginTransaction(reader, TxOptions{ReadOnly: true})
+	res := <-db.Get(reader, "test", 1)
+	assert.Nil(t, res.Err)
+	assert.Equal(t, 10, res.Data)
+	_, locked := lm.transactMap.Get(reader.Txn.tId)
+	assert.False(t, locked)
+	_, err := db.tManager.GetLog(reader.ID)
+	assert.ErrorIs(t, err, ErrReadOnlyTxn)
+	assert.Nil(t, db.CommitTransaction(writer))
+	assert.Nil(t, db.CommitTransaction(reader))
+}
+
+func TestReadOnly_Data_Consistency(t *testing.T) {
+	// GIVEN writers that move amounts between two keys, and read-only transactions that read both keys
+	// WHEN the writers complete
+	// THEN every read-only transaction should see the same total
+	db, _ := newSeededTestDB(t)
+	routineCount := 4
+	iterCount := 100
+	wg := sync.WaitGroup{}
+	wg.Add(2 * routineCount)
+	for range routineCount {
+		go func() {
+			defer wg.Done()
+			ctx, _ := db.Connect()
+			for range iterCount {
+				for {
+					_ = db.BeginTransaction(ctx)
+					// An aborted operation leaves the transaction in Ready mode, so it is rolled back
+					first := <-db.Get(ctx, "test", 1)
+					second := <-db.Get(ctx, "test", 2)
+					if first.Err != nil || second.Err != nil {
+						_ = db.RollbackTransaction(ctx)
+						continue
+					}
+					err := errors.Join((<-db.Put(ctx, "test", 1, first.Data.(int)-1)).Err,
+						(<-db.Put(ctx, "test", 2, second.Data.(int)+1)).Err)
+					if err != nil {
+						_ = db.RollbackTransaction(ctx)
+						continue
+					}
+					if err = db.CommitTransaction(ctx); err != nil {
+						t.Error(err)
+						return
+					}
+					break
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			ctx, _ := db.Connect()
+			for range iterCount {
+				_ = db.BeginTransaction(ctx, TxOptions{ReadOnly: true})
+				first := <-db.Get(ctx, "test", 1)
+				second := <-db.Get(ctx, "test", 2)
+				assert.Nil(t, db.CommitTransaction(ctx))
+				assert.Equal(t, 30, first.Data.(int)+second.Data.(int))
+			}
+		}()
+	}
+	wg.Wait()
+}
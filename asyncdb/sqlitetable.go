@@ -0,0 +1,179 @@
+package asyncdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Volume999/AsyncDB/internal/databases/sqlite"
+	"strings"
+)
+
+// SqliteTableFactory creates tables in an embedded sqlite database, either a file or an in-memory database
+type SqliteTableFactory struct {
+	handler *sqlite.Handler
+	// persistent is set for database files
+	persistent bool
+}
+
+// NewSqliteTableFactory opens the database file at the path, or an in-memory database for ":memory:"
+func NewSqliteTableFactory(path string) (*SqliteTableFactory, error) {
+	handler, err := sqlite.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = handler.DB.Ping(); err != nil {
+		_ = handler.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return &SqliteTableFactory{handler: handler, persistent: path != sqlite.MemoryPath}, nil
+}
+
+func (f *SqliteTableFactory) Close() error {
+	return f.handler.Close()
+}
+
+// GetTable creates a table storing the keys and values formatted as strings, like PgTable does
+func (f *SqliteTableFactory) GetTable(name string) (Table, error) {
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (key VARCHAR(500) PRIMARY KEY, value VARCHAR(500))", name)
+	_, err := f.handler.DB.Exec(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create table: %w", err)
+	}
+	return newSqliteTable(name, f.handler.DB, f.persistent), nil
+}
+
+func (f *SqliteTableFactory) DeleteTable(name string) error {
+	_, err := f.handler.DB.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", name))
+	if err != nil {
+		return fmt.Errorf("failed to delete table: %w", err)
+	}
+	return nil
+}
+
+func (f *SqliteTableFactory) GetExistingTables() ([]Table, error) {
+	rows, err := f.handler.DB.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing tables: %w", err)
+	}
+	defer rows.Close()
+	var tables []Table
+	for rows.Next() {
+		var tableName string
+		if err = rows.Scan(&tableName); err != nil {
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
+		}
+		tables = append(tables, newSqliteTable(tableName, f.handler.DB, f.persistent))
+	}
+	return tables, rows.Err()
+}
+
+// SqliteTable stores keys and values as strings. Sqlite compares text byte by byte, like CompareKeys does
+type SqliteTable struct {
+	db         *sql.DB
+	name       string
+	persistent bool
+}
+
+func newSqliteTable(name string, db *sql.DB, persistent bool) *SqliteTable {
+	return &SqliteTable{db: db, name: name, persistent: persistent}
+}
+
+// durable reports whether the table is in a database file, which keeps committed writes
+func (s *SqliteTable) durable() bool {
+	return s.persistent
+}
+
+func (s *SqliteTable) Name() string {
+	return s.name
+}
+
+func (s *SqliteTable) Get(key interface{}) (value interface{}, err error) {
+	return s.GetContext(context.Background(), key)
+}
+
+// GetContext interrupts the statement when the context is done. It also stops waiting for a free connection,
+// which matters for in-memory databases, where all tables share one connection
+func (s *SqliteTable) GetContext(ctx context.Context, key interface{}) (value interface{}, err error) {
+	query := fmt.Sprintf("SELECT value FROM %s WHERE key = ?", s.name)
+	var valueFromDb string
+	err = s.db.QueryRowContext(ctx, query, fmt.Sprintf("%v", key)).Scan(&valueFromDb)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w - %v", ErrKeyNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get value from database: %w", err)
+	}
+	return valueFromDb, nil
+}
+
+func (s *SqliteTable) Put(key interface{}, value interface{}) error {
+	query := fmt.Sprintf("INSERT INTO %s (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", s.name)
+	_, err := s.db.Exec(query, fmt.Sprintf("%v", key), fmt.Sprintf("%v", value))
+	if err != nil {
+		return fmt.Errorf("failed to insert value into database: %w", err)
+	}
+	return nil
+}
+
+func (s *SqliteTable) Delete(key interface{}) error {
+	res, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE key = ?", s.name), fmt.Sprintf("%v", key))
+	if err != nil {
+		return fmt.Errorf("failed to delete value from database: %w", err)
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("%w - %v", ErrKeyNotFound, key)
+	}
+	return nil
+}
+
+// ValidateTypes accepts any key and value. Sqlite stores them in the text columns as formatted by fmt.Sprintf
+func (s *SqliteTable) ValidateTypes(_ interface{}, _ interface{}) error {
+	return nil
+}
+
+// Scan orders rows by the string representation of the key, the same one used to store it
+func (s *SqliteTable) Scan(from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	return s.ScanContext(context.Background(), from, to, limit)
+}
+
+// ScanContext stops the scan like GetContext, also between rows
+func (s *SqliteTable) ScanContext(ctx context.Context, from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	conditions := make([]string, 0, 2)
+	args := make([]interface{}, 0, 3)
+	if from != nil {
+		args = append(args, fmt.Sprintf("%v", from))
+		conditions = append(conditions, "key >= ?")
+	}
+	if to != nil {
+		args = append(args, fmt.Sprintf("%v", to))
+		conditions = append(conditions, "key <= ?")
+	}
+	query := fmt.Sprintf("SELECT key, value FROM %s", s.name)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY key"
+	if limit > 0 {
+		args = append(args, limit)
+		query += " LIMIT ?"
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan table: %w", err)
+	}
+	defer rows.Close()
+	res := make([]KeyValue, 0)
+	for rows.Next() {
+		var key, value string
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		res = append(res, KeyValue{Key: key, Value: value})
+	}
+	return res, rows.Err()
+}
+
+func (s *SqliteTable) CompareKeys(a interface{}, b interface{}) int {
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
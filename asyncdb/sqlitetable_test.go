@@ -0,0 +1,65 @@
+package asyncdb
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func newSqliteTestTable(t *testing.T) *SqliteTable {
+	factory, err := NewSqliteTableFactory(":memory:")
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = factory.Close() })
+	table, err := factory.GetTable("test")
+	assert.Nil(t, err)
+	return table.(*SqliteTable)
+}
+
+func TestSqliteTable_Should_Put_Get_And_Delete(t *testing.T) {
+	table := newSqliteTestTable(t)
+	assert.Nil(t, table.Put(1, 10))
+	assert.Nil(t, table.Put(1, 11))
+	value, err := table.Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "11", value)
+	assert.Nil(t, table.Delete(1))
+	_, err = table.Get(1)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.ErrorIs(t, table.Delete(1), ErrKeyNotFound)
+}
+
+func TestSqliteTable_Scan_Should_Order_Keys_As_Strings(t *testing.T) {
+	table := newSqliteTestTable(t)
+	for _, key := range []int{3, 10, 1, 2} {
+		assert.Nil(t, table.Put(key, key))
+	}
+	rows, err := table.Scan(nil, nil, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyValue{{"1", "1"}, {"10", "10"}, {"2", "2"}, {"3", "3"}}, rows)
+	rows, err = table.Scan(10, 3, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyValue{{"10", "10"}, {"2", "2"}}, rows)
+	assert.Equal(t, -1, table.CompareKeys(10, 2))
+}
+
+func TestSqliteTableFactory_File_Should_Keep_Tables_After_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	factory, err := NewSqliteTableFactory(path)
+	assert.Nil(t, err)
+	table, _ := factory.GetTable("test")
+	assert.Nil(t, table.Put("key", "value"))
+	assert.Nil(t, factory.Close())
+
+	factory, err = NewSqliteTableFactory(path)
+	assert.Nil(t, err)
+	defer factory.Close()
+	tables, err := factory.GetExistingTables()
+	assert.Nil(t, err)
+	assert.Len(t, tables, 1)
+	value, err := tables[0].Get("key")
+	assert.Nil(t, err)
+	assert.Equal(t, "value", value)
+	assert.Nil(t, factory.DeleteTable("test"))
+	tables, _ = factory.GetExistingTables()
+	assert.Empty(t, tables)
+}
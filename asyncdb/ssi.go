@@ -0,0 +1,255 @@
+package asyncdb
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// ssiTxn is the conflict state of a transaction. in means a concurrent transaction read what this one wrote,
+// out means this one read what a concurrent transaction wrote, both are rw-antidependencies
+type ssiTxn struct {
+	tId TransactId
+	// start is the snapshot of the transaction, commitTs is set once it is committing
+	start    int64
+	commitTs int64
+	in       bool
+	out      bool
+	// doomed transactions are aborted at commit, as they complete a dangerous structure with committed ones
+	doomed bool
+	keys   []readKey
+	ranges []readRange
+}
+
+func (t *ssiTxn) concurrentWith(other *ssiTxn) bool {
+	return other.commitTs == 0 || other.commitTs > t.start
+}
+
+func (t *ssiTxn) isPivot() bool {
+	return t.in && t.out
+}
+
+// ssiControl is serializable snapshot isolation. Transactions read their snapshot of the MVCC version store
+// without locks, like in WithMVCC, and the rw-antidependencies between concurrent transactions are tracked.
+// A transaction with both incoming and outgoing rw-antidependencies (a pivot) can be part of a non-serializable
+// execution, so it is aborted with ErrXactAborted, or, if it has already committed, the transaction completing
+// the structure is aborted instead
+type ssiControl struct {
+	mv *mvccControl
+	// txns keeps running transactions and the committed ones that are concurrent with a running transaction
+	txns map[TransactId]*ssiTxn
+	// committed finds the writer of a version by its timestamp
+	committed map[int64]*ssiTxn
+	// readers keeps the transactions that read a key, ranges are kept by the transactions
+	readers map[readKey][]*ssiTxn
+	m       *sync.Mutex
+}
+
+// WithSSI replaces locking with serializable snapshot isolation. The lock manager is not used
+func WithSSI() func(*AsyncDB) {
+	return func(db *AsyncDB) {
+		db.cc = newSSIControl(db.data)
+	}
+}
+
+func newSSIControl(data *ThreadSafeMap[uint64, Table]) *ssiControl {
+	return &ssiControl{
+		mv:        newMVCCControl(data),
+		txns:      make(map[TransactId]*ssiTxn),
+		committed: make(map[int64]*ssiTxn),
+		readers:   make(map[readKey][]*ssiTxn),
+		m:         &sync.Mutex{},
+	}
+}
+
+func (c *ssiControl) begin(txn *TransactInfo) {
+	c.mv.begin(txn)
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.txns[txn.tId] = &ssiTxn{tId: txn.tId, start: txn.ts}
+}
+
+func (c *ssiControl) read(ctx context.Context, txn *TransactInfo, tableId uint64, table Table, key interface{}) (interface{}, error) {
+	value, err := c.mv.read(ctx, txn, tableId, table, key)
+	c.m.Lock()
+	defer c.m.Unlock()
+	t, ok := c.txns[txn.tId]
+	if !ok {
+		return nil, ErrXactInTerminalState
+	}
+	k := readKey{tableId, key}
+	if !slices.Contains(t.keys, k) {
+		t.keys = append(t.keys, k)
+		c.readers[k] = append(c.readers[k], t)
+	}
+	// Versions newer than the snapshot are writes of concurrent transactions the reader did not see
+	c.mv.m.RLock()
+	for _, v := range c.mv.versions[tableId][key] {
+		if v.ts > t.start {
+			c.addReadConflict(t, v.ts)
+		}
+	}
+	c.mv.m.RUnlock()
+	return value, err
+}
+
+func (c *ssiControl) scan(ctx context.Context, txn *TransactInfo, tableId uint64, table OrderedTable, from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	rows, err := c.mv.scan(ctx, txn, tableId, table, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	t, ok := c.txns[txn.tId]
+	if !ok {
+		return nil, ErrXactInTerminalState
+	}
+	keyRange := KeyRange{From: from, To: to, Compare: table.CompareKeys}
+	t.ranges = append(t.ranges, readRange{tableId, keyRange})
+	c.mv.m.RLock()
+	for key, versions := range c.mv.versions[tableId] {
+		if !keyRange.contains(key) {
+			continue
+		}
+		for _, v := range versions {
+			if v.ts > t.start {
+				c.addReadConflict(t, v.ts)
+			}
+		}
+	}
+	c.mv.m.RUnlock()
+	return rows, nil
+}
+
+// addReadConflict records that the reader did not see the version committed at ts. Requires m
+func (c *ssiControl) addReadConflict(reader *ssiTxn, ts int64) {
+	writer, ok := c.committed[ts]
+	if !ok || writer == reader {
+		return
+	}
+	reader.out = true
+	writer.in = true
+	// The writer has already committed, so only the reader can be aborted
+	if reader.isPivot() || writer.isPivot() {
+		reader.doomed = true
+	}
+}
+
+func (c *ssiControl) write(ctx context.Context, txn *TransactInfo, tableId uint64, key interface{}) error {
+	return c.mv.write(ctx, txn, tableId, key)
+}
+
+func (c *ssiControl) commit(txn *TransactInfo, tables map[uint64][]LogEntry, apply func() error) error {
+	return c.mv.commit(txn, tables, func() error {
+		// The versions are installed, and the commit timestamp is not taken until the clock is published
+		if err := c.checkCommit(txn, tables, c.mv.clock.next()); err != nil {
+			return err
+		}
+		err := apply()
+		if err != nil {
+			c.cancelCommit(txn)
+		}
+		return err
+	})
+}
+
+// checkCommit adds the rw-antidependencies from the concurrent readers of the written keys, and aborts the
+// transaction if it is a pivot, or if it makes a committed reader one. Otherwise, the transaction is registered
+// as the writer of the versions at commitTs
+func (c *ssiControl) checkCommit(txn *TransactInfo, tables map[uint64][]LogEntry, commitTs int64) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	t, ok := c.txns[txn.tId]
+	if !ok {
+		return ErrXactInTerminalState
+	}
+	if t.doomed {
+		return ErrXactAborted
+	}
+	readers := make([]*ssiTxn, 0)
+	for tableId, entries := range tables {
+		for _, entry := range entries {
+			for _, r := range c.readers[readKey{tableId, entry.Key}] {
+				if !slices.Contains(readers, r) {
+					readers = append(readers, r)
+				}
+			}
+			for _, r := range c.txns {
+				if !slices.Contains(readers, r) && slices.ContainsFunc(r.ranges, func(rr readRange) bool {
+					return rr.tableId == tableId && rr.keyRange.contains(entry.Key)
+				}) {
+					readers = append(readers, r)
+				}
+			}
+		}
+	}
+	in := false
+	for _, r := range readers {
+		if r == t || !t.concurrentWith(r) {
+			continue
+		}
+		in = true
+		if r.commitTs != 0 && r.in {
+			return ErrXactAborted
+		}
+	}
+	if in && t.out {
+		return ErrXactAborted
+	}
+	for _, r := range readers {
+		if r == t || !t.concurrentWith(r) {
+			continue
+		}
+		r.out = true
+		if r.isPivot() {
+			r.doomed = true
+		}
+	}
+	t.in = t.in || in
+	t.commitTs = commitTs
+	c.committed[commitTs] = t
+	return nil
+}
+
+func (c *ssiControl) cancelCommit(txn *TransactInfo) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if t, ok := c.txns[txn.tId]; ok {
+		delete(c.committed, t.commitTs)
+		t.commitTs = 0
+	}
+}
+
+// end forgets the transaction, unless it has committed and is concurrent with a running transaction.
+// Committed transactions that are not concurrent with any running one are forgotten as well
+func (c *ssiControl) end(txn *TransactInfo) error {
+	err := c.mv.end(txn)
+	oldest := c.mv.clock.oldest()
+	c.m.Lock()
+	defer c.m.Unlock()
+	if t, ok := c.txns[txn.tId]; ok && t.commitTs == 0 {
+		c.forget(t)
+	}
+	for _, t := range c.txns {
+		if t.commitTs != 0 && t.commitTs <= oldest {
+			c.forget(t)
+		}
+	}
+	return err
+}
+
+// forget removes the transaction with its reads. Requires m
+func (c *ssiControl) forget(t *ssiTxn) {
+	delete(c.txns, t.tId)
+	if t.commitTs != 0 {
+		delete(c.committed, t.commitTs)
+	}
+	for _, k := range t.keys {
+		readers := slices.DeleteFunc(c.readers[k], func(r *ssiTxn) bool { return r == t })
+		if len(readers) == 0 {
+			delete(c.readers, k)
+		} else {
+			c.readers[k] = readers
+		}
+	}
+}
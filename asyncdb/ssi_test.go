@@ -0,0 +1,93 @@
+package asyncdb
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newSSITestDB(t *testing.T, option func(*AsyncDB)) (*AsyncDB, *ConnectionContext) {
+	db, ctx := newTestDB(t, NewLockManager(), []Table{newTestTable[int, int](t, "doctors")}, option)
+	// Both doctors are on call, and at least one of them has to stay on call
+	assert.Nil(t, (<-db.Put(ctx, "doctors", 1, 1)).Err)
+	assert.Nil(t, (<-db.Put(ctx, "doctors", 2, 1)).Err)
+	return db, ctx
+}
+
+// goOffCall takes the doctor off call if the other one is still on call
+func goOffCall(db *AsyncDB, ctx *ConnectionContext, doctor int, other int) {
+	_ = db.BeginTransaction(ctx)
+	if (<-db.Get(ctx, "doctors", other)).Data == 1 {
+		<-db.Put(ctx, "doctors", doctor, 0)
+	}
+}
+
+func TestMVCC_Write_Skew_Is_Allowed(t *testing.T) {
+	db, first := newSSITestDB(t, WithMVCC())
+	second, _ := db.Connect()
+	goOffCall(db, first, 1, 2)
+	goOffCall(db, second, 2, 1)
+	assert.Nil(t, db.CommitTransaction(first))
+	assert.Nil(t, db.CommitTransaction(second))
+	// Nobody is on call
+	assert.Equal(t, 0, (<-db.Get(first, "doctors", 1)).Data)
+	assert.Equal(t, 0, (<-db.Get(first, "doctors", 2)).Data)
+}
+
+func TestSSI_Write_Skew_Should_Be_Prevented(t *testing.T) {
+	db, first := newSSITestDB(t, WithSSI())
+	second, _ := db.Connect()
+	goOffCall(db, first, 1, 2)
+	goOffCall(db, second, 2, 1)
+	assert.Nil(t, db.CommitTransaction(first))
+	assert.ErrorIs(t, db.CommitTransaction(second), ErrXactAborted)
+	assert.Equal(t, 0, (<-db.Get(first, "doctors", 1)).Data)
+	assert.Equal(t, 1, (<-db.Get(first, "doctors", 2)).Data)
+}
+
+func TestSSI_Write_Skew_Through_Ranges_Should_Be_Prevented(t *testing.T) {
+	db, first := newSSITestDB(t, WithSSI())
+	second, _ := db.Connect()
+	// Each transaction inserts a doctor only if it sees two of them
+	insert := func(ctx *ConnectionContext, doctor int) {
+		_ = db.BeginTransaction(ctx)
+		count := 0
+		for range db.Scan(ctx, "doctors", 1, 10, 0) {
+			count++
+		}
+		if count == 2 {
+			<-db.Put(ctx, "doctors", doctor, 1)
+		}
+	}
+	insert(first, 3)
+	insert(second, 4)
+	assert.Nil(t, db.CommitTransaction(first))
+	assert.ErrorIs(t, db.CommitTransaction(second), ErrXactAborted)
+}
+
+func TestSSI_Transactions_Without_Conflicts_Should_Commit(t *testing.T) {
+	db, first := newSSITestDB(t, WithSSI())
+	second, _ := db.Connect()
+	_ = db.BeginTransaction(first)
+	_ = db.BeginTransaction(second)
+	<-db.Get(first, "doctors", 1)
+	<-db.Put(first, "doctors", 1, 0)
+	<-db.Get(second, "doctors", 2)
+	<-db.Put(second, "doctors", 2, 0)
+	assert.Nil(t, db.CommitTransaction(first))
+	assert.Nil(t, db.CommitTransaction(second))
+}
+
+func TestSSI_Should_Forget_Transactions_Not_Concurrent_With_Running_Ones(t *testing.T) {
+	db, first := newSSITestDB(t, WithSSI())
+	cc := db.cc.(*ssiControl)
+	second, _ := db.Connect()
+	goOffCall(db, first, 1, 2)
+	goOffCall(db, second, 2, 1)
+	assert.Nil(t, db.CommitTransaction(first))
+	// The committed transaction is kept while the concurrent one is running
+	assert.Len(t, cc.committed, 1)
+	_ = db.RollbackTransaction(second)
+	assert.Empty(t, cc.txns)
+	assert.Empty(t, cc.committed)
+	assert.Empty(t, cc.readers)
+}
@@ -1,12 +1,15 @@
 package asyncdb
 
 import (
+	"context"
 	"errors"
 )
 
 var ErrKeyNotFound = errors.New("key not found")
 var ErrTypeMismatch = errors.New("type mismatch")
 var ErrEmptyTableName = errors.New("table name cannot be empty")
+var ErrXactNotPrepared = errors.New("transaction not prepared")
+var ErrScanNotSupported = errors.New("table does not support scans")
 
 type Table interface {
 	Name() string
@@ -15,3 +18,77 @@ type Table interface {
 	Delete(key interface{}) error
 	ValidateTypes(key interface{}, value interface{}) error
 }
+
+// KeyValue is a row returned by a scan
+type KeyValue struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// OrderedTable is a table that can return its rows ordered by key
+type OrderedTable interface {
+	Table
+	// Scan returns the rows with keys between from and to, both inclusive, in key order.
+	// A nil bound leaves that side of the range open, and a limit less than or equal to zero means no limit
+	Scan(from interface{}, to interface{}, limit int) ([]KeyValue, error)
+	// CompareKeys defines the order used by Scan
+	CompareKeys(a interface{}, b interface{}) int
+}
+
+// ContextTable is a table with reads that can be cancelled, for example by a deadline of the operation
+type ContextTable interface {
+	Table
+	GetContext(ctx context.Context, key interface{}) (value interface{}, err error)
+}
+
+// ContextOrderedTable is an ordered table with scans that can be cancelled
+type ContextOrderedTable interface {
+	OrderedTable
+	ScanContext(ctx context.Context, from interface{}, to interface{}, limit int) ([]KeyValue, error)
+}
+
+// getContext reads the key with the context if the table supports it. Other tables are not interrupted,
+// so the context is checked only before the read
+func getContext(ctx context.Context, table Table, key interface{}) (interface{}, error) {
+	if t, ok := table.(ContextTable); ok {
+		return t.GetContext(ctx, key)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return table.Get(key)
+}
+
+// scanContext scans the table with the context if the table supports it, like getContext
+func scanContext(ctx context.Context, table OrderedTable, from interface{}, to interface{}, limit int) ([]KeyValue, error) {
+	if t, ok := table.(ContextOrderedTable); ok {
+		return t.ScanContext(ctx, from, to, limit)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return table.Scan(from, to, limit)
+}
+
+// PreparableTable is a table that can take part in two-phase commit.
+// Prepare must guarantee that CommitPrepared will succeed, without making the changes visible
+type PreparableTable interface {
+	Table
+	Prepare(tid TransactId, entries []LogEntry) error
+	CommitPrepared(tid TransactId) error
+	AbortPrepared(tid TransactId) error
+	// PreparedTransactions lists transactions that are prepared but not yet committed or aborted
+	PreparedTransactions() ([]TransactId, error)
+}
+
+// BatchTable is a table that applies many operations at once, for example in a single round-trip.
+// Commits use it to write the entries of a table without two-phase commit support
+type BatchTable interface {
+	Table
+	// GetMany returns the values of the keys in the same order, and whether each key exists
+	GetMany(keys []interface{}) (values []interface{}, found []bool, err error)
+	// PutMany writes the rows in order
+	PutMany(rows []KeyValue) error
+	// DeleteMany deletes the keys in order, and fails with ErrKeyNotFound if one of them does not exist
+	DeleteMany(keys []interface{}) error
+}
@@ -2,7 +2,9 @@ package asyncdb
 
 import (
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	"slices"
 )
 
 const (
@@ -11,8 +13,9 @@ const (
 )
 
 var (
-	ErrConnInXact    = errors.New("connection in transaction")
-	ErrConnNotInXact = errors.New("connection not in transaction")
+	ErrConnInXact        = errors.New("connection in transaction")
+	ErrConnNotInXact     = errors.New("connection not in transaction")
+	ErrSavepointNotFound = errors.New("savepoint not found")
 )
 
 type Txn struct {
@@ -35,10 +38,20 @@ type LogEntry struct {
 
 type TransactionLog struct {
 	l *ThreadSafeMap[uint64, []LogEntry]
+	// savepoints are ordered by creation, and are guarded by l
+	savepoints []savepoint
+}
+
+// savepoint marks the length of the entries of every table when it was created
+type savepoint struct {
+	name    string
+	lengths map[uint64]int
 }
 
 type TransactionManager interface {
 	StartTransaction(ConnId uuid.UUID) (TransactId, error)
+	// StartReadOnlyTransaction starts a transaction without a log, GetLog fails with ErrReadOnlyTxn
+	StartReadOnlyTransaction(ConnId uuid.UUID) (TransactId, error)
 	DeleteLog(ConnId uuid.UUID) error
 	GetLog(ConnId uuid.UUID) (*TransactionLog, error)
 	EndTransaction(ConnId uuid.UUID) error
@@ -55,6 +68,16 @@ func NewTransactionManager() *TransactionManagerImpl {
 }
 
 func (t *TransactionManagerImpl) StartTransaction(ConnId uuid.UUID) (TransactId, error) {
+	return t.startTransaction(ConnId, &TransactionLog{
+		l: NewThreadSafeMap[uint64, []LogEntry](),
+	})
+}
+
+func (t *TransactionManagerImpl) StartReadOnlyTransaction(ConnId uuid.UUID) (TransactId, error) {
+	return t.startTransaction(ConnId, nil)
+}
+
+func (t *TransactionManagerImpl) startTransaction(ConnId uuid.UUID, tLog *TransactionLog) (TransactId, error) {
 	t.tLogs.Lock()
 	defer t.tLogs.Unlock()
 	if _, ok := t.tLogs.GetUnsafe(ConnId); ok {
@@ -63,9 +86,7 @@ func (t *TransactionManagerImpl) StartTransaction(ConnId uuid.UUID) (TransactId,
 	txnId := TransactId(uuid.New())
 	txn := &Txn{
 		txnID: txnId,
-		tLog: &TransactionLog{
-			l: NewThreadSafeMap[uint64, []LogEntry](),
-		},
+		tLog:  tLog,
 	}
 	t.tLogs.PutUnsafe(ConnId, txn)
 	return txnId, nil
@@ -103,11 +124,45 @@ func (t *TransactionLog) findLastValue(tableId uint64, key interface{}) (interfa
 	return nil, false
 }
 
+// lastEntries returns the last entry of every key written to the table, in the order they were first written
+func (t *TransactionLog) lastEntries(tableId uint64) []LogEntry {
+	t.l.Lock()
+	defer t.l.Unlock()
+	entries, _ := t.l.GetUnsafe(tableId)
+	positions := make(map[interface{}]int)
+	res := make([]LogEntry, 0)
+	for _, entry := range entries {
+		if pos, ok := positions[entry.Key]; ok {
+			res[pos] = entry
+			continue
+		}
+		positions[entry.Key] = len(res)
+		res = append(res, entry)
+	}
+	return res
+}
+
+// entries returns a copy of the logged actions grouped by table
+func (t *TransactionLog) entries() map[uint64][]LogEntry {
+	t.l.Lock()
+	defer t.l.Unlock()
+	res := make(map[uint64][]LogEntry, len(t.l.m))
+	for tableId, entries := range t.l.m {
+		if len(entries) > 0 {
+			res[tableId] = slices.Clone(entries)
+		}
+	}
+	return res
+}
+
 func (t *TransactionManagerImpl) GetLog(ConnId uuid.UUID) (*TransactionLog, error) {
 	tLog, ok := t.tLogs.Get(ConnId)
 	if !ok {
 		return nil, ErrConnNotInXact
 	}
+	if tLog.tLog == nil {
+		return nil, ErrReadOnlyTxn
+	}
 	return tLog.tLog, nil
 }
 
@@ -118,8 +173,61 @@ func (t *TransactionManagerImpl) DeleteLog(ConnId uuid.UUID) error {
 	if !ok {
 		return ErrConnNotInXact
 	}
+	if txn.tLog == nil {
+		return ErrReadOnlyTxn
+	}
 	txn.tLog.l.Lock()
 	defer txn.tLog.l.Unlock()
 	txn.tLog.l = NewThreadSafeMap[uint64, []LogEntry]()
 	return nil
 }
+
+// savepoint marks the current end of the log. A savepoint with the same name as an older one hides it
+func (t *TransactionLog) savepoint(name string) {
+	t.l.Lock()
+	defer t.l.Unlock()
+	lengths := make(map[uint64]int, len(t.l.m))
+	for tableId, entries := range t.l.m {
+		lengths[tableId] = len(entries)
+	}
+	t.savepoints = append(t.savepoints, savepoint{name: name, lengths: lengths})
+}
+
+// findSavepoint returns the position of the newest savepoint with the name. Requires l
+func (t *TransactionLog) findSavepoint(name string) (int, error) {
+	for i := len(t.savepoints) - 1; i >= 0; i-- {
+		if t.savepoints[i].name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w - %s", ErrSavepointNotFound, name)
+}
+
+// rollbackTo drops the entries logged after the savepoint and the savepoints created after it.
+// The savepoint itself is kept, so it can be rolled back to again
+func (t *TransactionLog) rollbackTo(name string) error {
+	t.l.Lock()
+	defer t.l.Unlock()
+	i, err := t.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	sp := t.savepoints[i]
+	for tableId, entries := range t.l.m {
+		t.l.m[tableId] = entries[:sp.lengths[tableId]]
+	}
+	t.savepoints = t.savepoints[:i+1]
+	return nil
+}
+
+// release drops the savepoint and the savepoints created after it, keeping the entries
+func (t *TransactionLog) release(name string) error {
+	t.l.Lock()
+	defer t.l.Unlock()
+	i, err := t.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	t.savepoints = t.savepoints[:i]
+	return nil
+}
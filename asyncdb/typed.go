@@ -0,0 +1,148 @@
+package asyncdb
+
+import (
+	"context"
+	"fmt"
+	"github.com/Volume999/AsyncDB/internal/databases"
+)
+
+// Future is the result of an operation that runs in the background
+type Future[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// newFuture completes the future with the conversion of the results once they arrive
+func newFuture[V any](results <-chan databases.RequestResult, convert func(results <-chan databases.RequestResult) (V, error)) *Future[V] {
+	f := &Future[V]{done: make(chan struct{})}
+	go func() {
+		f.value, f.err = convert(results)
+		close(f.done)
+	}()
+	return f
+}
+
+// Await waits for the result of the operation
+func (f *Future[V]) Await() (V, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// AwaitCtx waits for the result of the operation until the context is done, and then returns the context error.
+// The operation keeps running, and its result can be awaited again
+func (f *Future[V]) AwaitCtx(ctx context.Context) (V, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// Done is closed when the result is available
+func (f *Future[V]) Done() <-chan struct{} {
+	return f.done
+}
+
+// TypedKeyValue is a row of a TypedTable
+type TypedKeyValue[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// TypedTable is a handle to the table with the name in the database, with the key and value types of the table.
+// The table does not have to exist when the handle is created, the operations fail with ErrTableNotFound until it does
+type TypedTable[K comparable, V any] struct {
+	db   *AsyncDB
+	name string
+}
+
+func NewTypedTable[K comparable, V any](db *AsyncDB, name string) *TypedTable[K, V] {
+	return &TypedTable[K, V]{db: db, name: name}
+}
+
+func (t *TypedTable[K, V]) Name() string {
+	return t.name
+}
+
+func (t *TypedTable[K, V]) Get(ctx *ConnectionContext, key K) *Future[V] {
+	return t.GetContext(context.Background(), ctx, key)
+}
+
+func (t *TypedTable[K, V]) GetContext(goCtx context.Context, ctx *ConnectionContext, key K) *Future[V] {
+	return newFuture(t.db.GetContext(goCtx, ctx, t.name, key), func(results <-chan databases.RequestResult) (V, error) {
+		res := <-results
+		if res.Err != nil {
+			var zero V
+			return zero, res.Err
+		}
+		return typedValue[V](res.Data)
+	})
+}
+
+func (t *TypedTable[K, V]) Put(ctx *ConnectionContext, key K, value V) *Future[struct{}] {
+	return t.PutContext(context.Background(), ctx, key, value)
+}
+
+func (t *TypedTable[K, V]) PutContext(goCtx context.Context, ctx *ConnectionContext, key K, value V) *Future[struct{}] {
+	return newFuture(t.db.PutContext(goCtx, ctx, t.name, key, value), awaitDone)
+}
+
+func (t *TypedTable[K, V]) Delete(ctx *ConnectionContext, key K) *Future[struct{}] {
+	return t.DeleteContext(context.Background(), ctx, key)
+}
+
+func (t *TypedTable[K, V]) DeleteContext(goCtx context.Context, ctx *ConnectionContext, key K) *Future[struct{}] {
+	return newFuture(t.db.DeleteContext(goCtx, ctx, t.name, key), awaitDone)
+}
+
+// Scan returns the rows with keys between from and to, like AsyncDB.Scan. A nil bound leaves that side open
+func (t *TypedTable[K, V]) Scan(ctx *ConnectionContext, from *K, to *K, limit int) *Future[[]TypedKeyValue[K, V]] {
+	return t.ScanContext(context.Background(), ctx, from, to, limit)
+}
+
+func (t *TypedTable[K, V]) ScanContext(goCtx context.Context, ctx *ConnectionContext, from *K, to *K, limit int) *Future[[]TypedKeyValue[K, V]] {
+	var fromKey, toKey interface{}
+	if from != nil {
+		fromKey = *from
+	}
+	if to != nil {
+		toKey = *to
+	}
+	results := t.db.ScanContext(goCtx, ctx, t.name, fromKey, toKey, limit)
+	return newFuture(results, func(results <-chan databases.RequestResult) ([]TypedKeyValue[K, V], error) {
+		rows := make([]TypedKeyValue[K, V], 0)
+		for res := range results {
+			if res.Err != nil {
+				return nil, res.Err
+			}
+			kv := res.Data.(KeyValue)
+			key, err := typedValue[K](kv.Key)
+			if err != nil {
+				return nil, err
+			}
+			value, err := typedValue[V](kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, TypedKeyValue[K, V]{Key: key, Value: value})
+		}
+		return rows, nil
+	})
+}
+
+// awaitDone waits for an operation without a result
+func awaitDone(results <-chan databases.RequestResult) (struct{}, error) {
+	return struct{}{}, (<-results).Err
+}
+
+// typedValue converts a value read from a table, which may store other types than the handle expects
+func typedValue[V any](data interface{}) (V, error) {
+	value, ok := data.(V)
+	if !ok {
+		return value, fmt.Errorf("%w: expected value type - %T, got - %T", ErrTypeMismatch, *new(V), data)
+	}
+	return value, nil
+}
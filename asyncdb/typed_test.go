@@ -0,0 +1,71 @@
+package asyncdb
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type typedTestRow struct {
+	Name  string
+	Count int
+}
+
+func newTypedTestDB(t *testing.T) (*AsyncDB, *ConnectionContext, *TypedTable[int, typedTestRow]) {
+	db, ctx := newTestDB(t, NewLockManager(), []Table{newTestTable[int, typedTestRow](t, "test")})
+	return db, ctx, NewTypedTable[int, typedTestRow](db, "test")
+}
+
+func TestTypedTable_Should_Put_Get_And_Delete(t *testing.T) {
+	_, ctx, table := newTypedTestDB(t)
+	_, err := table.Put(ctx, 1, typedTestRow{Name: "one", Count: 1}).Await()
+	assert.Nil(t, err)
+	row, err := table.Get(ctx, 1).Await()
+	assert.Nil(t, err)
+	assert.Equal(t, typedTestRow{Name: "one", Count: 1}, row)
+	_, err = table.Delete(ctx, 1).Await()
+	assert.Nil(t, err)
+	_, err = table.Get(ctx, 1).Await()
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestTypedTable_Scan_Should_Return_Typed_Rows(t *testing.T) {
+	_, ctx, table := newTypedTestDB(t)
+	for key := 1; key <= 5; key++ {
+		_, _ = table.Put(ctx, key, typedTestRow{Count: key}).Await()
+	}
+	from, to := 2, 4
+	rows, err := table.Scan(ctx, &from, &to, 0).Await()
+	assert.Nil(t, err)
+	assert.Equal(t, []TypedKeyValue[int, typedTestRow]{{2, typedTestRow{Count: 2}}, {3, typedTestRow{Count: 3}}, {4, typedTestRow{Count: 4}}}, rows)
+	rows, err = table.Scan(ctx, nil, nil, 2).Await()
+	assert.Nil(t, err)
+	assert.Len(t, rows, 2)
+}
+
+func TestTypedTable_Should_Report_Mismatching_Table(t *testing.T) {
+	db, ctx, _ := newTypedTestDB(t)
+	<-db.Put(ctx, "test", 1, typedTestRow{})
+	_, err := NewTypedTable[int, string](db, "test").Get(ctx, 1).Await()
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+	_, err = NewTypedTable[int, string](db, "missing").Get(ctx, 1).Await()
+	assert.ErrorIs(t, err, ErrTableNotFound)
+}
+
+func TestFuture_AwaitCtx_Should_Stop_Waiting_When_Context_Done(t *testing.T) {
+	db, ctx, table := newTypedTestDB(t)
+	holder, _ := db.Connect()
+	// The reader begins first, so it is older and waits for the holder
+	_ = db.BeginTransaction(ctx)
+	_ = db.BeginTransaction(holder)
+	_, _ = table.Put(holder, 1, typedTestRow{}).Await()
+	future := table.Get(ctx, 1)
+	waitCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := future.AwaitCtx(waitCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_ = db.RollbackTransaction(holder)
+	_, err = future.Await()
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
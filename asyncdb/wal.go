@@ -0,0 +1,168 @@
+package asyncdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	WALCommit = 1 + iota
+	WALAbort
+)
+
+var ErrWALCorrupted = errors.New("write-ahead log corrupted")
+
+// WALRecord is a single entry of the write-ahead log.
+// Keys and values are encoded with encoding/gob, so custom types have to be registered with gob.Register
+type WALRecord struct {
+	Type   int
+	TxnId  TransactId
+	Tables map[uint64][]LogEntry
+}
+
+type WriteAheadLog interface {
+	// Append durably writes the record. The record is considered persisted only when Append returns nil
+	Append(rec WALRecord) error
+	// Replay calls f for every committed and not aborted transaction in the order they were logged
+	Replay(f func(rec WALRecord) error) error
+	// Checkpoint atomically replaces the whole log with the records
+	Checkpoint(records []WALRecord) error
+	Close() error
+}
+
+type FileWAL struct {
+	path string
+	file *os.File
+	m    *sync.Mutex
+}
+
+func NewFileWAL(path string) (*FileWAL, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	return &FileWAL{path: path, file: file, m: &sync.Mutex{}}, nil
+}
+
+func encodeWALRecord(rec WALRecord) ([]byte, error) {
+	buf, err := encodeFrame(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode write-ahead log record: %w", err)
+	}
+	return buf, nil
+}
+
+func (w *FileWAL) Append(rec WALRecord) error {
+	buf, err := encodeWALRecord(rec)
+	if err != nil {
+		return err
+	}
+	w.m.Lock()
+	defer w.m.Unlock()
+	if _, err := w.file.Write(buf); err != nil {
+		return fmt.Errorf("failed to write write-ahead log record: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// Replay reads the log from the beginning. A record that was not fully written (crash during Append)
+// belongs to a transaction that never committed, so the log is truncated at that point and the record is dropped
+func (w *FileWAL) Replay(f func(rec WALRecord) error) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	records, validSize, err := w.readRecords()
+	if err != nil {
+		return err
+	}
+	if err = w.file.Truncate(validSize); err != nil {
+		return fmt.Errorf("failed to truncate write-ahead log: %w", err)
+	}
+	aborted := make(map[TransactId]bool)
+	for _, rec := range records {
+		if rec.Type == WALAbort {
+			aborted[rec.TxnId] = true
+		}
+	}
+	for _, rec := range records {
+		if rec.Type != WALCommit || aborted[rec.TxnId] {
+			continue
+		}
+		if err = f(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *FileWAL) readRecords() ([]WALRecord, int64, error) {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, fmt.Errorf("failed to read write-ahead log: %w", err)
+	}
+	records := make([]WALRecord, 0)
+	validSize, err := readFrames(w.file, func(payload []byte, _ int64, _ int64) error {
+		var rec WALRecord
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&rec); err != nil {
+			return fmt.Errorf("%w: %w", ErrWALCorrupted, err)
+		}
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, validSize, nil
+}
+
+// Checkpoint writes the records to a new file and renames it over the log, so a crash leaves either
+// the old log or the new one
+func (w *FileWAL) Checkpoint(records []WALRecord) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	tmpPath := w.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create write-ahead log checkpoint: %w", err)
+	}
+	err = func() error {
+		for _, rec := range records {
+			buf, err := encodeWALRecord(rec)
+			if err != nil {
+				return err
+			}
+			if _, err = file.Write(buf); err != nil {
+				return fmt.Errorf("failed to write write-ahead log checkpoint: %w", err)
+			}
+		}
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync write-ahead log checkpoint: %w", err)
+		}
+		if err := os.Rename(tmpPath, w.path); err != nil {
+			return fmt.Errorf("failed to replace write-ahead log: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	syncDir(filepath.Dir(w.path))
+	_ = w.file.Close()
+	w.file = file
+	return nil
+}
+
+func (w *FileWAL) Close() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.file.Close()
+}
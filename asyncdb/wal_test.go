@@ -0,0 +1,298 @@
+package asyncdb
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newWALTestDB(t *testing.T, path string) (*AsyncDB, *FileWAL, *ConnectionContext) {
+	wal, err := NewFileWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, ctx := newTestDB(t, NewLockManager(), []Table{newTestTable[int, int](t, "test")}, WithWAL(wal))
+	return db, wal, ctx
+}
+
+func TestFileWAL_Replay_Should_Return_Appended_Records(t *testing.T) {
+	wal, _ := NewFileWAL(filepath.Join(t.TempDir(), "wal"))
+	defer wal.Close()
+	tid := TransactId(uuid.New())
+	err := wal.Append(WALRecord{Type: WALCommit, TxnId: tid, Tables: map[uint64][]LogEntry{1: {{Op: LPut, Key: 1, Value: "a"}}}})
+	assert.Nil(t, err)
+	records := make([]WALRecord, 0)
+	err = wal.Replay(func(rec WALRecord) error {
+		records = append(records, rec)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, tid, records[0].TxnId)
+	assert.Equal(t, []LogEntry{{Op: LPut, Key: 1, Value: "a"}}, records[0].Tables[1])
+}
+
+func TestFileWAL_Replay_Should_Drop_Torn_Record(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	wal, _ := NewFileWAL(path)
+	_ = wal.Append(WALRecord{Type: WALCommit, TxnId: TransactId(uuid.New()), Tables: map[uint64][]LogEntry{1: {{Op: LPut, Key: 1, Value: 1}}}})
+	_ = wal.Append(WALRecord{Type: WALCommit, TxnId: TransactId(uuid.New()), Tables: map[uint64][]LogEntry{1: {{Op: LPut, Key: 2, Value: 2}}}})
+	_ = wal.Close()
+	// Simulate a crash in the middle of writing the second record
+	info, _ := os.Stat(path)
+	_ = os.Truncate(path, info.Size()-3)
+
+	wal, _ = NewFileWAL(path)
+	defer wal.Close()
+	count := 0
+	err := wal.Replay(func(rec WALRecord) error {
+		count++
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	// The torn record is removed, so new records are appended after the last valid one
+	_ = wal.Append(WALRecord{Type: WALCommit, TxnId: TransactId(uuid.New())})
+	count = 0
+	_ = wal.Replay(func(rec WALRecord) error {
+		count++
+		return nil
+	})
+	assert.Equal(t, 2, count)
+}
+
+func TestAsyncDB_Recover_Should_Restore_Committed_Transactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	db, wal, ctx := newWALTestDB(t, path)
+	_ = db.BeginTransaction(ctx)
+	<-db.Put(ctx, "test", 1, 2)
+	<-db.Put(ctx, "test", 2, 3)
+	assert.Nil(t, db.CommitTransaction(ctx))
+	_ = db.BeginTransaction(ctx)
+	<-db.Delete(ctx, "test", 2)
+	assert.Nil(t, db.CommitTransaction(ctx))
+	// Uncommitted transactions are not logged
+	_ = db.BeginTransaction(ctx)
+	<-db.Put(ctx, "test", 3, 4)
+	_ = db.RollbackTransaction(ctx)
+	_ = wal.Close()
+
+	db, wal, ctx = newWALTestDB(t, path)
+	defer wal.Close()
+	assert.Nil(t, db.Recover())
+	res := <-db.Get(ctx, "test", 1)
+	assert.Nil(t, res.Err)
+	assert.Equal(t, 2, res.Data)
+	res = <-db.Get(ctx, "test", 2)
+	assert.EqualError(t, res.Err, "key not found - 2")
+	res = <-db.Get(ctx, "test", 3)
+	assert.EqualError(t, res.Err, "key not found - 3")
+}
+
+func TestAsyncDB_Recover_Should_Apply_Commit_Record_Written_Before_Crash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	db, wal, ctx := newWALTestDB(t, path)
+	// Crash after the commit record is persisted, but before the log is applied
+	_ = db.BeginTransaction(ctx)
+	<-db.Put(ctx, "test", 1, 2)
+	tLog, _ := db.tManager.GetLog(ctx.ID)
+	assert.Nil(t, db.logCommit(ctx.Txn.tId, tLog.entries()))
+	_ = wal.Close()
+
+	db, wal, ctx = newWALTestDB(t, path)
+	defer wal.Close()
+	assert.Nil(t, db.Recover())
+	res := <-db.Get(ctx, "test", 1)
+	assert.Nil(t, res.Err)
+	assert.Equal(t, 2, res.Data)
+}
+
+func TestFileWAL_Replay_Should_Skip_Aborted_Transactions(t *testing.T) {
+	wal, _ := NewFileWAL(filepath.Join(t.TempDir(), "wal"))
+	defer wal.Close()
+	committed := TransactId(uuid.New())
+	aborted := TransactId(uuid.New())
+	_ = wal.Append(WALRecord{Type: WALCommit, TxnId: aborted, Tables: map[uint64][]LogEntry{1: {{Op: LPut, Key: 1, Value: 1}}}})
+	_ = wal.Append(WALRecord{Type: WALCommit, TxnId: committed, Tables: map[uint64][]LogEntry{1: {{Op: LPut, Key: 2, Value: 2}}}})
+	_ = wal.Append(WALRecord{Type: WALAbort, TxnId: aborted})
+	replayed := make([]TransactId, 0)
+	err := wal.Replay(func(rec WALRecord) error {
+		replayed = append(replayed, rec.TxnId)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []TransactId{committed}, replayed)
+}
+
+func TestFileWAL_Checkpoint_Should_Replace_Log(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	wal, _ := NewFileWAL(path)
+	for key := 0; key < 10; key++ {
+		_ = wal.Append(WALRecord{Type: WALCommit, TxnId: TransactId(uuid.New()), Tables: map[uint64][]LogEntry{1: {{Op: LPut, Key: key, Value: key}}}})
+	}
+	before, _ := os.Stat(path)
+	assert.Nil(t, wal.Checkpoint([]WALRecord{{Type: WALCommit, Tables: map[uint64][]LogEntry{1: {{Op: LPut, Key: 1, Value: 1}}}}}))
+	after, _ := os.Stat(path)
+	assert.Less(t, after.Size(), before.Size())
+	// New records go after the checkpoint
+	tid := TransactId(uuid.New())
+	assert.Nil(t, wal.Append(WALRecord{Type: WALCommit, TxnId: tid}))
+	_ = wal.Close()
+
+	wal, _ = NewFileWAL(path)
+	defer wal.Close()
+	records := make([]WALRecord, 0)
+	assert.Nil(t, wal.Replay(func(rec WALRecord) error {
+		records = append(records, rec)
+		return nil
+	}))
+	assert.Len(t, records, 2)
+	assert.Equal(t, []LogEntry{{Op: LPut, Key: 1, Value: 1}}, records[0].Tables[1])
+	assert.Equal(t, tid, records[1].TxnId)
+}
+
+func TestAsyncDB_Checkpoint_Should_Bound_Log_And_Keep_State(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	wal, _ := NewFileWAL(path)
+	db, ctx := newTestDB(t, NewLockManager(), []Table{newTestTable[int, int](t, "test")}, WithWAL(wal), WithCheckpointEvery(5))
+	for key := 0; key < 22; key++ {
+		assert.Nil(t, (<-db.Put(ctx, "test", key%10, key)).Err)
+	}
+	assert.Nil(t, (<-db.Delete(ctx, "test", 0)).Err)
+	// Checkpoints run in the background
+	db.checkpoints.Wait()
+	count := 0
+	_ = wal.Replay(func(rec WALRecord) error {
+		count++
+		return nil
+	})
+	// The snapshot of the last checkpoint and the commits after it
+	assert.LessOrEqual(t, count, 5)
+	_ = wal.Close()
+
+	db, wal, ctx = newWALTestDB(t, path)
+	defer wal.Close()
+	assert.Nil(t, db.Recover())
+	assert.ErrorIs(t, (<-db.Get(ctx, "test", 0)).Err, ErrKeyNotFound)
+	for key := 1; key < 10; key++ {
+		expected := key + 10
+		if key < 2 {
+			expected = key + 20
+		}
+		assert.Equal(t, expected, (<-db.Get(ctx, "test", key)).Data)
+	}
+	// Recover checkpoints the replayed writes
+	count = 0
+	_ = wal.Replay(func(rec WALRecord) error {
+		count++
+		return nil
+	})
+	assert.Equal(t, 1, count)
+}
+
+// abortFailingWAL is a write-ahead log that fails to write abort records
+type abortFailingWAL struct {
+	memoryWAL
+}
+
+func (a *abortFailingWAL) Append(rec WALRecord) error {
+	if rec.Type == WALAbort {
+		return errors.New("write failed")
+	}
+	return a.memoryWAL.Append(rec)
+}
+
+func TestAsyncDB_CommitTransaction_Should_Log_Abort_Before_Undo(t *testing.T) {
+	db, ctx := newCommitTestDB(t)
+	wal := &memoryWAL{}
+	db.wal = wal
+	_ = db.BeginTransaction(ctx)
+	<-db.Put(ctx, "test", 1, 1)
+	<-db.Put(ctx, "failing", 99, 1)
+	assert.ErrorIs(t, db.CommitTransaction(ctx), ErrCommitFailed)
+	assert.Len(t, wal.records, 2)
+	assert.Equal(t, WALAbort, wal.records[1].Type)
+	assert.ErrorIs(t, (<-db.Get(ctx, "test", 1)).Err, ErrKeyNotFound)
+
+	// Without the abort record the transaction stays committed, and Recover applies it
+	failing := &abortFailingWAL{}
+	db.wal = failing
+	_ = db.BeginTransaction(ctx)
+	<-db.Put(ctx, "test", 2, 2)
+	<-db.Put(ctx, "failing", 99, 2)
+	assert.ErrorIs(t, db.CommitTransaction(ctx), ErrCommitNotApplied)
+	assert.Len(t, failing.records, 1)
+	assert.Equal(t, WALCommit, failing.records[0].Type)
+}
+
+// checkpointFailingWAL is a write-ahead log that fails to checkpoint
+type checkpointFailingWAL struct {
+	memoryWAL
+}
+
+func (c *checkpointFailingWAL) Checkpoint(_ []WALRecord) error {
+	return errors.New("write failed")
+}
+
+func TestAsyncDB_CommitTransaction_Should_Succeed_When_Checkpoint_Fails(t *testing.T) {
+	wal := &checkpointFailingWAL{}
+	db, ctx := newTestDB(t, NewLockManager(), []Table{newTestTable[int, int](t, "test")}, WithWAL(wal), WithCheckpointEvery(1))
+	assert.Nil(t, (<-db.Put(ctx, "test", 1, 1)).Err)
+	db.checkpoints.Wait()
+	// The old log stays in place
+	assert.Len(t, wal.records, 1)
+	assert.Equal(t, 1, (<-db.Get(ctx, "test", 1)).Data)
+}
+
+func TestAsyncDB_CreateTable_Should_Refuse_Tables_Without_Scans_With_WAL(t *testing.T) {
+	db := NewAsyncDB(NewTransactionManager(), NewLockManager(), NewStringHasher(), WithWAL(&memoryWAL{}))
+	ctx, _ := db.Connect()
+	assert.ErrorIs(t, db.CreateTable(ctx, NewSimulatedTable("simulated", 0)), ErrScanNotSupported)
+	db = NewAsyncDB(NewTransactionManager(), NewLockManager(), NewStringHasher())
+	assert.Nil(t, db.CreateTable(ctx, NewSimulatedTable("simulated", 0)))
+}
+
+func TestAsyncDB_Checkpoint_Should_Keep_Commits_During_Scan(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	db, wal, _ := newWALTestDB(t, path)
+	writers := 4
+	writes := 200
+	done := make(chan struct{})
+	for w := 0; w < writers; w++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			ctx, _ := db.Connect()
+			for i := 0; i < writes; i++ {
+				key := w*writes + i
+				assert.Nil(t, (<-db.Put(ctx, "test", key, i)).Err)
+				if i%3 == 0 {
+					assert.Nil(t, (<-db.Delete(ctx, "test", key)).Err)
+				}
+			}
+		}()
+	}
+	for running := writers; running > 0; {
+		select {
+		case <-done:
+			running--
+		default:
+			assert.Nil(t, db.Checkpoint())
+		}
+	}
+	_ = wal.Close()
+
+	db, wal, ctx := newWALTestDB(t, path)
+	defer wal.Close()
+	assert.Nil(t, db.Recover())
+	for key := 0; key < writers*writes; key++ {
+		res := <-db.Get(ctx, "test", key)
+		if key%writes%3 == 0 {
+			assert.ErrorIs(t, res.Err, ErrKeyNotFound)
+		} else {
+			assert.Equal(t, key%writes, res.Data)
+		}
+	}
+}
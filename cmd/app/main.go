@@ -13,6 +13,7 @@ import (
 	async2 "github.com/Volume999/AsyncDB/internal/tpcc/stores/async"
 	"github.com/kr/pretty"
 	"sync"
+	"sync/atomic"
 )
 
 var ErrBusinessLogic = errors.New("business logic error")
@@ -132,12 +133,10 @@ func debug() {
 
 	// Customer Store
 	customerStore := async2.NewCustomerStore(nil, db)
-	resChan = customerStore.Put(ctx, models.Customer{ID: 1, DistrictId: 1, WarehouseId: 1})
-	res = <-resChan
-	fmt.Printf("Result: %# v\n", pretty.Formatter(res))
-	resChan = customerStore.Get(ctx, models.CustomerPK{ID: 1, DistrictId: 1, WarehouseId: 1})
-	res = <-resChan
-	fmt.Printf("Result: %# v\n", pretty.Formatter(res))
+	_, err := customerStore.Put(ctx, models.Customer{ID: 1, DistrictId: 1, WarehouseId: 1}).Await()
+	fmt.Printf("Result: %# v\n", pretty.Formatter(err))
+	customer, err := customerStore.Get(ctx, models.CustomerPK{ID: 1, DistrictId: 1, WarehouseId: 1}).Await()
+	fmt.Printf("Result: %# v, %v\n", pretty.Formatter(customer), err)
 
 	// New Order Service
 	stores := async2.Stores{
@@ -189,33 +188,24 @@ func SetupAsyncDBWorkflow(db *asyncdb.AsyncDB, pgFactory *asyncdb.PgTableFactory
 	err := db.Disconnect(ctx)
 	return err
 }
-func withTransaction(db *asyncdb.AsyncDB, ctx *asyncdb.ConnectionContext, idx int, workflow func() error) {
-	err := db.BeginTransaction(ctx)
-	if idx == -1 {
-		ctx.Txn.SetTimestamp(0)
-	}
+func withTransaction(db *asyncdb.AsyncDB, ctx *asyncdb.ConnectionContext, idx int, workflow func() error) error {
 	fmt.Printf("Transaction %v Started\n", idx)
+	attempts, err := db.RunInTransaction(ctx, asyncdb.TxOptions{}, func(ctx *asyncdb.ConnectionContext) error {
+		return workflow()
+	}, asyncdb.WithMaxAttempts(0))
 	if err != nil {
-		panic("Failed to begin transaction: " + err.Error())
-	}
-	abortCount := 0
-	err = workflow()
-	for err != nil {
-		abortCount++
-		fmt.Printf("Transaction aborted, idx: %v, count: %d, error: %v\n", idx, abortCount, err.Error())
-		//time.Sleep(1 * time.Second)
-		err = workflow()
-	}
-	err = db.CommitTransaction(ctx)
-	if err != nil {
-		panic("Failed to commit transaction: " + err.Error())
+		fmt.Printf("Transaction failed, idx: %v, attempts: %d, error: %v\n", idx, attempts, err.Error())
+		return err
 	}
-	fmt.Println("Transaction aborted count: ", abortCount)
+	fmt.Println("Transaction aborted count: ", attempts-1)
+	return nil
 }
 
-func executeWorkflow(db *asyncdb.AsyncDB, idx int) {
+// executeWorkflow runs the workflow in a transaction of a new connection. begun is called when an attempt has begun
+func executeWorkflow(db *asyncdb.AsyncDB, idx int, begun func()) error {
 	ctx, _ := db.Connect()
-	withTransaction(db, ctx, idx, func() error {
+	return withTransaction(db, ctx, idx, func() error {
+		begun()
 		var err error
 		resChan := make(chan databases.RequestResult, 10)
 		for i := 0; i < 10; i++ {
@@ -260,21 +250,33 @@ func debugAsyncDBWorkflow() {
 	workflowCount := 10
 	iters := 10
 	wg.Add(workflowCount)
+	// Workflow -1 begins its first transaction before the others start, so that transaction is the oldest one
+	oldestBegun := make(chan struct{})
+	var failed atomic.Int64
 	go func() {
 		defer wg.Done()
+		var once sync.Once
+		markBegun := func() { once.Do(func() { close(oldestBegun) }) }
+		defer markBegun()
 		for j := 0; j < iters; j++ {
-			executeWorkflow(db, -1)
+			if err := executeWorkflow(db, -1, markBegun); err != nil {
+				failed.Add(1)
+			}
 		}
 	}()
 	for i := range workflowCount - 1 {
 		go func() {
 			defer wg.Done()
+			<-oldestBegun
 			for j := 0; j < iters; j++ {
-				executeWorkflow(db, i)
+				if err := executeWorkflow(db, i, func() {}); err != nil {
+					failed.Add(1)
+				}
 			}
 		}()
 	}
 	wg.Wait()
+	fmt.Println("Failed transactions: ", failed.Load())
 }
 
 func main() {
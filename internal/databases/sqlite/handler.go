@@ -8,6 +8,8 @@ import (
 
 const (
 	databasePath = "internal/databases/sqlite/db"
+	// MemoryPath opens a database that lives as long as its handler
+	MemoryPath = ":memory:"
 )
 
 type Handler struct {
@@ -15,15 +17,30 @@ type Handler struct {
 }
 
 func NewHandler(dbType string) (*Handler, error) {
-	var db *sql.DB
-	var err error
 	if dbType == "in-memory" {
-		db, err = sql.Open("sqlite3", ":memory:")
-	} else {
-		db, err = sql.Open("sqlite3", databasePath)
+		return Open(MemoryPath)
 	}
+	return Open(databasePath)
+}
+
+// Open opens the database file at the path, creating it if needed, or an in-memory database for MemoryPath
+func Open(path string) (*Handler, error) {
+	dsn := path
+	if path != MemoryPath {
+		// Concurrent writers wait for each other instead of failing with SQLITE_BUSY
+		dsn = fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", path)
+	}
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("error opening database, %s", err)
 	}
+	if path == MemoryPath {
+		// Every connection to :memory: opens a separate database, so the pool keeps a single one
+		db.SetMaxOpenConns(1)
+	}
 	return &Handler{DB: db}, nil
 }
+
+func (h *Handler) Close() error {
+	return h.DB.Close()
+}
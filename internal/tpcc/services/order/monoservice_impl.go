@@ -2,7 +2,6 @@ package order
 
 import (
 	"github.com/Volume999/AsyncDB/asyncdb"
-	"github.com/Volume999/AsyncDB/internal/databases"
 	"github.com/Volume999/AsyncDB/internal/tpcc/models"
 	"github.com/Volume999/AsyncDB/internal/tpcc/stores/async"
 	"log"
@@ -38,11 +37,10 @@ func (s *MonoService) CreateOrder(command Command) Response {
 	cCh := s.stores.Customer.Get(ctx, models.CustomerPK{ID: command.CustomerId, DistrictId: command.DistrictId, WarehouseId: command.WarehouseId})
 
 	// Insert Order and New Order
-	dRes := <-dCh
-	if dRes.Err != nil {
-		return Response{ExecutionStatus: "Error getting district: " + dRes.Err.Error()}
+	d, err := dCh.Await()
+	if err != nil {
+		return Response{ExecutionStatus: "Error getting district: " + err.Error()}
 	}
-	d := dRes.Data.(models.District)
 	orderId := d.NextOId
 	noCh := s.stores.NewOrder.Put(ctx, models.NewOrder{OrderId: orderId, DistrictId: command.DistrictId, WarehouseId: command.WarehouseId})
 	allLocal := 1
@@ -56,26 +54,24 @@ func (s *MonoService) CreateOrder(command Command) Response {
 	dPutCh := s.stores.District.Put(ctx, d)
 
 	// Insert Order Lines
-	orderLineResponse := make([]<-chan databases.RequestResult, numOfItems)
+	orderLineResponse := make([]*asyncdb.Future[struct{}], numOfItems)
 	orderLines := make([]ResponseItems, numOfItems)
 	for i, orderItem := range command.Items {
 		itemChan := s.stores.Item.Get(ctx, models.ItemPK{Id: orderItem.ItemId})
 		stockChan := s.stores.Stock.Get(ctx, models.StockPK{ItemId: orderItem.ItemId, WarehouseId: orderItem.SupplyWarehouseId})
-		itemChanRes := <-itemChan
-		if itemChanRes.Err != nil {
+		item, err := itemChan.Await()
+		if err != nil {
 			// Abort Transaction
 			// Todo: Maybe this shouldn't be here
-			err := s.db.RollbackTransaction(ctx)
-			if err != nil {
-				s.l.Println("Error rolling back transaction: " + err.Error())
+			if rbErr := s.db.RollbackTransaction(ctx); rbErr != nil {
+				s.l.Println("Error rolling back transaction: " + rbErr.Error())
 			}
-			return Response{ExecutionStatus: "Error getting itemChanRes: " + itemChanRes.Err.Error()}
+			return Response{ExecutionStatus: "Error getting itemChanRes: " + err.Error()}
 		}
-		stockChanRes := <-stockChan
-		if stockChanRes.Err != nil {
-			return Response{ExecutionStatus: "Error getting stockChanRes: " + stockChanRes.Err.Error()}
+		stock, err := stockChan.Await()
+		if err != nil {
+			return Response{ExecutionStatus: "Error getting stockChanRes: " + err.Error()}
 		}
-		item, stock := itemChanRes.Data.(models.Item), stockChanRes.Data.(models.Stock)
 		orderLineAmount := float64(orderItem.Quantity) * item.Price
 		brandGeneric := "G"
 		if strings.Contains(item.Data, "ORIGINAL") && strings.Contains(stock.Data, "ORIGINAL") {
@@ -92,9 +88,8 @@ func (s *MonoService) CreateOrder(command Command) Response {
 		stock.OrderCnt += 1
 		// Update Stock
 		stockCh := s.stores.Stock.Put(ctx, stock)
-		stockRes := <-stockCh
-		if stockRes.Err != nil {
-			return Response{ExecutionStatus: "Error updating stock: " + stockRes.Err.Error()}
+		if _, err = stockCh.Await(); err != nil {
+			return Response{ExecutionStatus: "Error updating stock: " + err.Error()}
 		}
 		// Insert Order Line
 		olCh := s.stores.OrderLine.Put(ctx, models.OrderLine{
@@ -123,37 +118,32 @@ func (s *MonoService) CreateOrder(command Command) Response {
 	for _, orderLine := range orderLines {
 		totalAmount += orderLine.Amount
 	}
-	warehouseRes := <-whCh
-	if warehouseRes.Err != nil {
-		return Response{ExecutionStatus: "Error getting warehouse: " + warehouseRes.Err.Error()}
+	warehouse, err := whCh.Await()
+	if err != nil {
+		return Response{ExecutionStatus: "Error getting warehouse: " + err.Error()}
 	}
-	customerRes := <-cCh
-	if customerRes.Err != nil {
-		return Response{ExecutionStatus: "Error getting customer: " + customerRes.Err.Error()}
+	customer, err := cCh.Await()
+	if err != nil {
+		return Response{ExecutionStatus: "Error getting customer: " + err.Error()}
 	}
-	warehouse, customer := warehouseRes.Data.(models.Warehouse), customerRes.Data.(models.Customer)
 	warehouseTax := warehouse.Tax
 	districtTax := d.Tax
 	customerDiscount := customer.Discount
 	totalAmount = totalAmount * (1 + warehouseTax + districtTax) * (1 - customerDiscount)
 	// Await all put functions
-	noRes := <-noCh
-	if noRes.Err != nil {
-		return Response{ExecutionStatus: "Error inserting new order: " + noRes.Err.Error()}
+	if _, err = noCh.Await(); err != nil {
+		return Response{ExecutionStatus: "Error inserting new order: " + err.Error()}
 	}
-	oRes := <-oCh
-	if oRes.Err != nil {
-		return Response{ExecutionStatus: "Error inserting order: " + oRes.Err.Error()}
+	if _, err = oCh.Await(); err != nil {
+		return Response{ExecutionStatus: "Error inserting order: " + err.Error()}
 	}
-	for _, olRes := range orderLineResponse {
-		olRes := <-olRes
-		if olRes.Err != nil {
-			return Response{ExecutionStatus: "Error inserting order line: " + olRes.Err.Error()}
+	for _, olCh := range orderLineResponse {
+		if _, err = olCh.Await(); err != nil {
+			return Response{ExecutionStatus: "Error inserting order line: " + err.Error()}
 		}
 	}
-	dPutRes := <-dPutCh
-	if dPutRes.Err != nil {
-		return Response{ExecutionStatus: "Error updating district: " + dPutRes.Err.Error()}
+	if _, err = dPutCh.Await(); err != nil {
+		return Response{ExecutionStatus: "Error updating district: " + err.Error()}
 	}
 	// Commit Transaction
 	err = s.db.CommitTransaction(ctx)
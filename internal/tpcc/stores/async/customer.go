@@ -2,28 +2,27 @@ package async
 
 import (
 	"github.com/Volume999/AsyncDB/asyncdb"
-	"github.com/Volume999/AsyncDB/internal/databases"
 	"github.com/Volume999/AsyncDB/internal/tpcc/models"
 	"log"
 )
 
 type CustomerStore struct {
-	l  *log.Logger
-	db *asyncdb.AsyncDB
+	l     *log.Logger
+	table *asyncdb.TypedTable[models.CustomerPK, models.Customer]
 }
 
-func (c *CustomerStore) Put(ctx *asyncdb.ConnectionContext, value models.Customer) <-chan databases.RequestResult {
-	return c.db.Put(ctx, "Customer", models.CustomerPK{ID: value.ID}, value)
+func (c *CustomerStore) Put(ctx *asyncdb.ConnectionContext, value models.Customer) *asyncdb.Future[struct{}] {
+	return c.table.Put(ctx, models.CustomerPK{ID: value.ID}, value)
 }
 
-func (c *CustomerStore) Get(ctx *asyncdb.ConnectionContext, key models.CustomerPK) <-chan databases.RequestResult {
-	return c.db.Get(ctx, "Customer", key)
+func (c *CustomerStore) Get(ctx *asyncdb.ConnectionContext, key models.CustomerPK) *asyncdb.Future[models.Customer] {
+	return c.table.Get(ctx, key)
 }
 
-func (c *CustomerStore) Delete(ctx *asyncdb.ConnectionContext, key models.CustomerPK) <-chan databases.RequestResult {
-	return c.db.Delete(ctx, "Customer", key)
+func (c *CustomerStore) Delete(ctx *asyncdb.ConnectionContext, key models.CustomerPK) *asyncdb.Future[struct{}] {
+	return c.table.Delete(ctx, key)
 }
 
 func NewCustomerStore(l *log.Logger, db *asyncdb.AsyncDB) Store[models.Customer, models.CustomerPK] {
-	return &CustomerStore{db: db, l: l}
+	return &CustomerStore{table: asyncdb.NewTypedTable[models.CustomerPK, models.Customer](db, "Customer"), l: l}
 }
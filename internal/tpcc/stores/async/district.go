@@ -2,28 +2,27 @@ package async
 
 import (
 	"github.com/Volume999/AsyncDB/asyncdb"
-	"github.com/Volume999/AsyncDB/internal/databases"
 	"github.com/Volume999/AsyncDB/internal/tpcc/models"
 	"log"
 )
 
 type DisctrictStore struct {
-	l  *log.Logger
-	db *asyncdb.AsyncDB
+	l     *log.Logger
+	table *asyncdb.TypedTable[models.DistrictPK, models.District]
 }
 
 func NewDiscrictStore(l *log.Logger, db *asyncdb.AsyncDB) Store[models.District, models.DistrictPK] {
-	return &DisctrictStore{db: db, l: l}
+	return &DisctrictStore{table: asyncdb.NewTypedTable[models.DistrictPK, models.District](db, "District"), l: l}
 }
 
-func (d *DisctrictStore) Put(ctx *asyncdb.ConnectionContext, value models.District) <-chan databases.RequestResult {
-	return d.db.Put(ctx, "District", models.DistrictPK{Id: value.Id}, value)
+func (d *DisctrictStore) Put(ctx *asyncdb.ConnectionContext, value models.District) *asyncdb.Future[struct{}] {
+	return d.table.Put(ctx, models.DistrictPK{Id: value.Id}, value)
 }
 
-func (d *DisctrictStore) Get(ctx *asyncdb.ConnectionContext, key models.DistrictPK) <-chan databases.RequestResult {
-	return d.db.Get(ctx, "District", key)
+func (d *DisctrictStore) Get(ctx *asyncdb.ConnectionContext, key models.DistrictPK) *asyncdb.Future[models.District] {
+	return d.table.Get(ctx, key)
 }
 
-func (d *DisctrictStore) Delete(ctx *asyncdb.ConnectionContext, key models.DistrictPK) <-chan databases.RequestResult {
-	return d.db.Delete(ctx, "District", key)
+func (d *DisctrictStore) Delete(ctx *asyncdb.ConnectionContext, key models.DistrictPK) *asyncdb.Future[struct{}] {
+	return d.table.Delete(ctx, key)
 }
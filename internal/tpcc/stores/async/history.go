@@ -2,29 +2,28 @@ package async
 
 import (
 	"github.com/Volume999/AsyncDB/asyncdb"
-	"github.com/Volume999/AsyncDB/internal/databases"
 	"github.com/Volume999/AsyncDB/internal/tpcc/models"
 	"log"
 )
 
 type HistoryStore struct {
-	l  *log.Logger
-	db *asyncdb.AsyncDB
+	l     *log.Logger
+	table *asyncdb.TypedTable[models.HistoryPK, models.History]
 }
 
 func NewHistoryStore(l *log.Logger, db *asyncdb.AsyncDB) Store[models.History, models.HistoryPK] {
-	return &HistoryStore{db: db, l: l}
+	return &HistoryStore{table: asyncdb.NewTypedTable[models.HistoryPK, models.History](db, "History"), l: l}
 }
 
-func (i *HistoryStore) Put(ctx *asyncdb.ConnectionContext, value models.History) <-chan databases.RequestResult {
+func (i *HistoryStore) Put(ctx *asyncdb.ConnectionContext, value models.History) *asyncdb.Future[struct{}] {
 	// History is not used in the benchmark, and it does not have a primary key
 	panic("implement me")
 }
 
-func (i *HistoryStore) Get(ctx *asyncdb.ConnectionContext, key models.HistoryPK) <-chan databases.RequestResult {
-	return i.db.Get(ctx, "History", key)
+func (i *HistoryStore) Get(ctx *asyncdb.ConnectionContext, key models.HistoryPK) *asyncdb.Future[models.History] {
+	return i.table.Get(ctx, key)
 }
 
-func (i *HistoryStore) Delete(ctx *asyncdb.ConnectionContext, key models.HistoryPK) <-chan databases.RequestResult {
-	return i.db.Delete(ctx, "History", key)
+func (i *HistoryStore) Delete(ctx *asyncdb.ConnectionContext, key models.HistoryPK) *asyncdb.Future[struct{}] {
+	return i.table.Delete(ctx, key)
 }
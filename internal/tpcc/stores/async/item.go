@@ -2,28 +2,27 @@ package async
 
 import (
 	"github.com/Volume999/AsyncDB/asyncdb"
-	"github.com/Volume999/AsyncDB/internal/databases"
 	"github.com/Volume999/AsyncDB/internal/tpcc/models"
 	"log"
 )
 
 type ItemStore struct {
-	l  *log.Logger
-	db *asyncdb.AsyncDB
+	l     *log.Logger
+	table *asyncdb.TypedTable[models.ItemPK, models.Item]
 }
 
 func NewItemStore(l *log.Logger, db *asyncdb.AsyncDB) Store[models.Item, models.ItemPK] {
-	return &ItemStore{db: db, l: l}
+	return &ItemStore{table: asyncdb.NewTypedTable[models.ItemPK, models.Item](db, "Item"), l: l}
 }
 
-func (i *ItemStore) Put(ctx *asyncdb.ConnectionContext, value models.Item) <-chan databases.RequestResult {
-	return i.db.Put(ctx, "Item", models.ItemPK{Id: value.Id}, value)
+func (i *ItemStore) Put(ctx *asyncdb.ConnectionContext, value models.Item) *asyncdb.Future[struct{}] {
+	return i.table.Put(ctx, models.ItemPK{Id: value.Id}, value)
 }
 
-func (i *ItemStore) Get(ctx *asyncdb.ConnectionContext, key models.ItemPK) <-chan databases.RequestResult {
-	return i.db.Get(ctx, "Item", key)
+func (i *ItemStore) Get(ctx *asyncdb.ConnectionContext, key models.ItemPK) *asyncdb.Future[models.Item] {
+	return i.table.Get(ctx, key)
 }
 
-func (i *ItemStore) Delete(ctx *asyncdb.ConnectionContext, key models.ItemPK) <-chan databases.RequestResult {
-	return i.db.Delete(ctx, "Item", key)
+func (i *ItemStore) Delete(ctx *asyncdb.ConnectionContext, key models.ItemPK) *asyncdb.Future[struct{}] {
+	return i.table.Delete(ctx, key)
 }
@@ -2,28 +2,27 @@ package async
 
 import (
 	"github.com/Volume999/AsyncDB/asyncdb"
-	"github.com/Volume999/AsyncDB/internal/databases"
 	"github.com/Volume999/AsyncDB/internal/tpcc/models"
 	"log"
 )
 
 type OrderStore struct {
-	l  *log.Logger
-	db *asyncdb.AsyncDB
+	l     *log.Logger
+	table *asyncdb.TypedTable[models.OrderPK, models.Order]
 }
 
 func NewOrderStore(l *log.Logger, db *asyncdb.AsyncDB) Store[models.Order, models.OrderPK] {
-	return &OrderStore{db: db, l: l}
+	return &OrderStore{table: asyncdb.NewTypedTable[models.OrderPK, models.Order](db, "Order"), l: l}
 }
 
-func (o OrderStore) Put(ctx *asyncdb.ConnectionContext, value models.Order) <-chan databases.RequestResult {
-	return o.db.Put(ctx, "Order", models.OrderPK{Id: value.Id, DistrictId: value.DistrictId, WarehouseId: value.WarehouseId}, value)
+func (o OrderStore) Put(ctx *asyncdb.ConnectionContext, value models.Order) *asyncdb.Future[struct{}] {
+	return o.table.Put(ctx, models.OrderPK{Id: value.Id, DistrictId: value.DistrictId, WarehouseId: value.WarehouseId}, value)
 }
 
-func (o OrderStore) Get(ctx *asyncdb.ConnectionContext, key models.OrderPK) <-chan databases.RequestResult {
-	return o.db.Get(ctx, "Order", key)
+func (o OrderStore) Get(ctx *asyncdb.ConnectionContext, key models.OrderPK) *asyncdb.Future[models.Order] {
+	return o.table.Get(ctx, key)
 }
 
-func (o OrderStore) Delete(ctx *asyncdb.ConnectionContext, key models.OrderPK) <-chan databases.RequestResult {
-	return o.db.Delete(ctx, "Order", key)
+func (o OrderStore) Delete(ctx *asyncdb.ConnectionContext, key models.OrderPK) *asyncdb.Future[struct{}] {
+	return o.table.Delete(ctx, key)
 }
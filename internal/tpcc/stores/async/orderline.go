@@ -2,29 +2,28 @@ package async
 
 import (
 	"github.com/Volume999/AsyncDB/asyncdb"
-	"github.com/Volume999/AsyncDB/internal/databases"
 	"github.com/Volume999/AsyncDB/internal/tpcc/models"
 	"log"
 )
 
 type OrderLineStore struct {
-	l  *log.Logger
-	db *asyncdb.AsyncDB
+	l     *log.Logger
+	table *asyncdb.TypedTable[models.OrderLinePK, models.OrderLine]
 }
 
 func NewOrderLineStore(l *log.Logger, db *asyncdb.AsyncDB) Store[models.OrderLine, models.OrderLinePK] {
-	return &OrderLineStore{db: db, l: l}
+	return &OrderLineStore{table: asyncdb.NewTypedTable[models.OrderLinePK, models.OrderLine](db, "OrderLine"), l: l}
 }
 
-func (o OrderLineStore) Put(ctx *asyncdb.ConnectionContext, value models.OrderLine) <-chan databases.RequestResult {
-	return o.db.Put(ctx, "OrderLine", models.OrderLinePK{OrderId: value.OrderId,
+func (o OrderLineStore) Put(ctx *asyncdb.ConnectionContext, value models.OrderLine) *asyncdb.Future[struct{}] {
+	return o.table.Put(ctx, models.OrderLinePK{OrderId: value.OrderId,
 		DistrictId: value.DistrictId, WarehouseId: value.WarehouseId, LineNumber: value.LineNumber}, value)
 }
 
-func (o OrderLineStore) Get(ctx *asyncdb.ConnectionContext, key models.OrderLinePK) <-chan databases.RequestResult {
-	return o.db.Get(ctx, "OrderLine", key)
+func (o OrderLineStore) Get(ctx *asyncdb.ConnectionContext, key models.OrderLinePK) *asyncdb.Future[models.OrderLine] {
+	return o.table.Get(ctx, key)
 }
 
-func (o OrderLineStore) Delete(ctx *asyncdb.ConnectionContext, key models.OrderLinePK) <-chan databases.RequestResult {
-	return o.db.Delete(ctx, "OrderLine", key)
+func (o OrderLineStore) Delete(ctx *asyncdb.ConnectionContext, key models.OrderLinePK) *asyncdb.Future[struct{}] {
+	return o.table.Delete(ctx, key)
 }
@@ -2,28 +2,27 @@ package async
 
 import (
 	"github.com/Volume999/AsyncDB/asyncdb"
-	"github.com/Volume999/AsyncDB/internal/databases"
 	"github.com/Volume999/AsyncDB/internal/tpcc/models"
 	"log"
 )
 
 type StockStore struct {
-	l  *log.Logger
-	db *asyncdb.AsyncDB
+	l     *log.Logger
+	table *asyncdb.TypedTable[models.StockPK, models.Stock]
 }
 
 func NewStockStore(l *log.Logger, db *asyncdb.AsyncDB) Store[models.Stock, models.StockPK] {
-	return &StockStore{db: db, l: l}
+	return &StockStore{table: asyncdb.NewTypedTable[models.StockPK, models.Stock](db, "Stock"), l: l}
 }
 
-func (s StockStore) Put(ctx *asyncdb.ConnectionContext, value models.Stock) <-chan databases.RequestResult {
-	return s.db.Put(ctx, "Stock", models.StockPK{ItemId: value.ItemId, WarehouseId: value.WarehouseId}, value)
+func (s StockStore) Put(ctx *asyncdb.ConnectionContext, value models.Stock) *asyncdb.Future[struct{}] {
+	return s.table.Put(ctx, models.StockPK{ItemId: value.ItemId, WarehouseId: value.WarehouseId}, value)
 }
 
-func (s StockStore) Get(ctx *asyncdb.ConnectionContext, key models.StockPK) <-chan databases.RequestResult {
-	return s.db.Get(ctx, "Stock", key)
+func (s StockStore) Get(ctx *asyncdb.ConnectionContext, key models.StockPK) *asyncdb.Future[models.Stock] {
+	return s.table.Get(ctx, key)
 }
 
-func (s StockStore) Delete(ctx *asyncdb.ConnectionContext, key models.StockPK) <-chan databases.RequestResult {
-	return s.db.Delete(ctx, "Stock", key)
+func (s StockStore) Delete(ctx *asyncdb.ConnectionContext, key models.StockPK) *asyncdb.Future[struct{}] {
+	return s.table.Delete(ctx, key)
 }
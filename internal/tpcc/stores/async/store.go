@@ -2,14 +2,13 @@ package async
 
 import (
 	"github.com/Volume999/AsyncDB/asyncdb"
-	"github.com/Volume999/AsyncDB/internal/databases"
 	"github.com/Volume999/AsyncDB/internal/tpcc/models"
 )
 
 type Store[V any, K any] interface {
-	Put(ctx *asyncdb.ConnectionContext, value V) <-chan databases.RequestResult
-	Get(ctx *asyncdb.ConnectionContext, key K) <-chan databases.RequestResult
-	Delete(ctx *asyncdb.ConnectionContext, key K) <-chan databases.RequestResult
+	Put(ctx *asyncdb.ConnectionContext, value V) *asyncdb.Future[struct{}]
+	Get(ctx *asyncdb.ConnectionContext, key K) *asyncdb.Future[V]
+	Delete(ctx *asyncdb.ConnectionContext, key K) *asyncdb.Future[struct{}]
 }
 
 type Stores struct {
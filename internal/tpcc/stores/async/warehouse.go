@@ -2,28 +2,27 @@ package async
 
 import (
 	"github.com/Volume999/AsyncDB/asyncdb"
-	"github.com/Volume999/AsyncDB/internal/databases"
 	"github.com/Volume999/AsyncDB/internal/tpcc/models"
 	"log"
 )
 
 type WarehouseStore struct {
-	l  *log.Logger
-	db *asyncdb.AsyncDB
+	l     *log.Logger
+	table *asyncdb.TypedTable[models.WarehousePK, models.Warehouse]
 }
 
-func (w WarehouseStore) Put(ctx *asyncdb.ConnectionContext, value models.Warehouse) <-chan databases.RequestResult {
-	return w.db.Put(ctx, "Warehouse", models.WarehousePK{Id: value.Id}, value)
+func (w WarehouseStore) Put(ctx *asyncdb.ConnectionContext, value models.Warehouse) *asyncdb.Future[struct{}] {
+	return w.table.Put(ctx, models.WarehousePK{Id: value.Id}, value)
 }
 
-func (w WarehouseStore) Get(ctx *asyncdb.ConnectionContext, key models.WarehousePK) <-chan databases.RequestResult {
-	return w.db.Get(ctx, "Warehouse", key)
+func (w WarehouseStore) Get(ctx *asyncdb.ConnectionContext, key models.WarehousePK) *asyncdb.Future[models.Warehouse] {
+	return w.table.Get(ctx, key)
 }
 
-func (w WarehouseStore) Delete(ctx *asyncdb.ConnectionContext, key models.WarehousePK) <-chan databases.RequestResult {
-	return w.db.Delete(ctx, "Warehouse", key)
+func (w WarehouseStore) Delete(ctx *asyncdb.ConnectionContext, key models.WarehousePK) *asyncdb.Future[struct{}] {
+	return w.table.Delete(ctx, key)
 }
 
 func NewWarehouseStore(l *log.Logger, db *asyncdb.AsyncDB) Store[models.Warehouse, models.WarehousePK] {
-	return &WarehouseStore{db: db, l: l}
+	return &WarehouseStore{table: asyncdb.NewTypedTable[models.WarehousePK, models.Warehouse](db, "Warehouse"), l: l}
 }